@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/provider"
+	"path/filepath"
+)
+
+// objectAttributes 按规则获取本地文件上传时的对象属性，rel为文件相对于源目录的路径
+// 规则未指定Content-Type时按扩展名或文件内容识别
+func objectAttributes(rules []config.ObjectRule, rel string, path string) (*provider.PutOptions, error) {
+	opt := &provider.PutOptions{Meta: map[string]string{}, Tags: map[string]string{}}
+	for _, rule := range rules {
+		if !helper.MatchPattern(rule.Pattern, rel) {
+			continue
+		}
+		setIfNotEmpty(&opt.ContentType, rule.ContentType)
+		setIfNotEmpty(&opt.CacheControl, rule.CacheControl)
+		setIfNotEmpty(&opt.ContentDisposition, rule.ContentDisposition)
+		setIfNotEmpty(&opt.Acl, rule.Acl)
+		setIfNotEmpty(&opt.StorageClass, rule.StorageClass)
+		for k, v := range rule.Meta {
+			opt.Meta[k] = v
+		}
+		for k, v := range rule.Tags {
+			opt.Tags[k] = v
+		}
+	}
+	if opt.ContentType == "" {
+		contentType, err := helper.DetectContentType(path)
+		if err != nil {
+			return nil, err
+		}
+		opt.ContentType = contentType
+	}
+	return opt, nil
+}
+
+// setIfNotEmpty 值不为空时覆盖目标值
+func setIfNotEmpty(target *string, value string) {
+	if value != "" {
+		*target = value
+	}
+}
+
+// relPath 获取文件相对于源目录的路径，分隔符统一为 /
+func relPath(root string, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MetaCompression 上传时压缩的对象元数据，值为压缩算法
+const MetaCompression = "osd-compression"
+
+// compressionExts 压缩算法对应的对象路径后缀
+var compressionExts = map[string]string{
+	helper.Gzip: ".gz",
+	helper.Zstd: ".zst",
+}
+
+// compressionTypes 压缩算法对应的Content-Type，用于后缀方式压缩的对象
+var compressionTypes = map[string]string{
+	helper.Gzip: "application/gzip",
+	helper.Zstd: "application/zstd",
+}
+
+// contentCompressor 上传压缩器，上传前压缩文件
+type contentCompressor struct {
+	algorithm string
+	mode      string
+	level     int
+	skip      []string
+}
+
+// newContentCompressor 按配置获取上传压缩器，未开启压缩时返回nil
+func newContentCompressor(cfg *config.Compression) (*contentCompressor, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	c := &contentCompressor{algorithm: strings.ToLower(cfg.Algorithm), mode: strings.ToLower(cfg.Mode),
+		level: cfg.Level, skip: cfg.Skip}
+	if c.algorithm == "" {
+		c.algorithm = helper.Gzip
+	}
+	if c.mode == "" {
+		c.mode = config.CompressionEncoding
+	}
+	if _, ok := compressionExts[c.algorithm]; !ok {
+		return nil, errors.New(fmt.Sprintf("compression algorithm '%s' is not supported", cfg.Algorithm))
+	}
+	if c.mode != config.CompressionEncoding && c.mode != config.CompressionSuffix {
+		return nil, errors.New(fmt.Sprintf("compression mode '%s' is not supported", cfg.Mode))
+	}
+	return c, nil
+}
+
+// shouldCompress 判断文件是否需要压缩，扩展名或内容已是压缩格式的文件不压缩
+func (c *contentCompressor) shouldCompress(path string) bool {
+	compressed, err := helper.IsCompressedFile(path, c.skip)
+	if err != nil {
+		logger.Error("detect compression error", "file", path, "error", err)
+		return false
+	}
+	return !compressed
+}
+
+// objectKey 获取压缩后的对象路径，suffix模式下追加算法对应的后缀
+func (c *contentCompressor) objectKey(key string) string {
+	if c.mode == config.CompressionSuffix {
+		return key + compressionExts[c.algorithm]
+	}
+	return key
+}
+
+// compressFile 压缩文件到临时文件，返回临时文件路径和需要写入对象的元数据，调用方负责删除临时文件
+func (c *contentCompressor) compressFile(path string) (string, map[string]string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "osd-tool-*"+compressionExts[c.algorithm])
+	if err != nil {
+		return "", nil, err
+	}
+	defer dst.Close()
+	if err := helper.CompressStream(dst, src, c.algorithm, c.level); err != nil {
+		os.Remove(dst.Name())
+		return "", nil, err
+	}
+	return dst.Name(), map[string]string{MetaCompression: c.algorithm}, nil
+}
+
+// compressionOf 获取对象的压缩算法，非本工具压缩的对象按Content-Encoding判断
+func compressionOf(meta map[string]string, contentEncoding string) string {
+	if algorithm := meta[MetaCompression]; algorithm != "" {
+		return algorithm
+	}
+	if _, ok := compressionExts[strings.ToLower(contentEncoding)]; ok {
+		return strings.ToLower(contentEncoding)
+	}
+	return ""
+}
+
+// decompressedPath 获取对象下载解压后的本地路径，按后缀方式压缩的对象去掉压缩后缀
+func decompressedPath(dest string, meta map[string]string, contentEncoding string) string {
+	if meta[MetaCompression] == "" {
+		return dest
+	}
+	return strings.TrimSuffix(dest, compressionExts[compressionOf(meta, contentEncoding)])
+}
+
+// hasCompressionExt 判断对象路径是否带有压缩后缀，只有这类对象可能是按后缀方式压缩的
+func hasCompressionExt(key string) bool {
+	for _, ext := range compressionExts {
+		if strings.HasSuffix(key, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// decompressFile 按压缩算法解压文件到dest，内容不是压缩格式时（已被http客户端解压）直接使用原内容
+func decompressFile(path string, dest string, algorithm string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(src, head)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// 先写到同目录的临时文件，解压成功后再替换，避免留下不完整的文件
+	dst, err := os.CreateTemp(filepath.Dir(dest), ".osd-tool-*.tmp")
+	if err != nil {
+		return err
+	}
+	if helper.IsCompressedBy(head[:n], algorithm) {
+		err = helper.DecompressStream(dst, src, algorithm)
+	} else {
+		_, err = io.Copy(dst, src)
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return os.Rename(dst.Name(), dest)
+}
@@ -1,11 +1,31 @@
 package config
 
-import "gopkg.in/yaml.v3"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/helper"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	DirectionUpload   = "upload"   // 上传方向
+	DirectionDownload = "download" // 下载方向
+)
 
 // TransferConfig 同步配置
 type TransferConfig struct {
-	Storage string `yaml:"storage"`
-	Upload  struct {
+	Storage         string              `yaml:"storage"`
+	Profile         string              `yaml:"profile,omitempty"`
+	Profiles        map[string]*Profile `yaml:"profiles,omitempty"`
+	CredentialsFile string              `yaml:"credentials_file,omitempty"`
+	KeyFile         string              `yaml:"key_file,omitempty"`
+	Upload          struct {
 		List   []Path   `yaml:"list"`
 		Ignore []string `yaml:"ignore"`
 	} `yaml:"upload"`
@@ -13,53 +33,509 @@ type TransferConfig struct {
 		List   []Path   `yaml:"list"`
 		Ignore []string `yaml:"ignore,omitempty"`
 	} `yaml:"download"`
-	Osd struct {
-		SecretId  string `yaml:"secret_id"`
-		SecretKey string `yaml:"secret_key"`
-		Bucket    string `yaml:"bucket"`
-		Region    string `yaml:"region"`
-		Timeout   int    `yaml:"timeout"`
-	} `yaml:"osd"`
+	Osd        Osd         `yaml:"osd"`
+	Jobs       []Job       `yaml:"jobs,omitempty"`
+	Encryption *Encryption `yaml:"encryption,omitempty"`
+	// ServerSideEncryption 服务端加密配置，作用于upload、download及未单独配置的任务
+	ServerSideEncryption *ServerSideEncryption `yaml:"server_side_encryption,omitempty"`
+	// Compression 上传压缩配置，作用于upload及未单独配置的任务
+	Compression *Compression `yaml:"compression,omitempty"`
+	// Rules 上传对象属性规则，作用于upload及未单独配置的任务
+	Rules []ObjectRule `yaml:"rules,omitempty"`
+	// Restore 归档对象取回配置，作用于download、restore命令及未单独配置的任务
+	Restore *Restore `yaml:"restore,omitempty"`
+	// Upgrade 升级来源配置，不配置时从GitHub升级
+	Upgrade *Upgrade `yaml:"upgrade,omitempty"`
+	// Report 传输报告配置，不配置时不输出报告
+	Report *Report `yaml:"report,omitempty"`
+}
+
+// 传输报告的格式
+const (
+	ReportJson = "json"
+	ReportCsv  = "csv"
+)
+
+// Report 传输报告配置，每次upload、download、run结束后把每个文件的处理结果写入报告
+type Report struct {
+	// Path 报告文件路径，{time} 替换为开始时间，如 reports/osd-tool-{time}.json，避免定时执行时覆盖
+	Path   string `yaml:"path"`
+	Format string `yaml:"format,omitempty"` // json 或 csv，不配置时按扩展名判断，默认json
+}
+
+// Upgrade 升级来源配置，无法访问GitHub时可以使用内部镜像、对象存储或本地安装包升级
+type Upgrade struct {
+	// Source 升级来源，为与GitHub API格式相同的地址（如GitHub Enterprise的 https://host/api/v3）、
+	// osd://前缀（对象存储中按版本号存放的目录）或本地安装包路径
+	Source  string `yaml:"source,omitempty"`
+	Proxy   string `yaml:"proxy,omitempty"`   // http代理，不配置时使用环境变量 HTTPS_PROXY、HTTP_PROXY
+	Timeout int    `yaml:"timeout,omitempty"` // http请求的超时秒数，默认300
+}
+
+// Restore 归档对象取回配置，下载时遇到归档、深度归档的对象按配置发起取回
+type Restore struct {
+	Enabled  bool   `yaml:"enabled"`            // 下载时是否自动取回
+	Days     int    `yaml:"days,omitempty"`     // 取回后可读的天数，默认1
+	Tier     string `yaml:"tier,omitempty"`     // 取回模式 Expedited、Standard、Bulk，不配置时使用对象存储的默认模式
+	Wait     bool   `yaml:"wait,omitempty"`     // 是否等待取回完成后下载，不等待时只发起取回
+	Interval int    `yaml:"interval,omitempty"` // 等待时的轮询间隔秒数，默认60
+	Timeout  int    `yaml:"timeout,omitempty"`  // 最长等待秒数，默认48小时
+}
+
+// ObjectRule 上传对象属性规则，pattern按文件相对于源目录的路径匹配，不含 / 时只匹配文件名，支持 * ? ** {a,b} 通配
+// 多条规则匹配时按顺序合并，后面的规则覆盖前面规则的同名属性
+type ObjectRule struct {
+	Pattern            string            `yaml:"pattern"`
+	ContentType        string            `yaml:"content_type,omitempty"` // 不配置时按扩展名或文件内容识别
+	CacheControl       string            `yaml:"cache_control,omitempty"`
+	ContentDisposition string            `yaml:"content_disposition,omitempty"`
+	Meta               map[string]string `yaml:"meta,omitempty"`
+	Tags               map[string]string `yaml:"tags,omitempty"`
+	Acl                string            `yaml:"acl,omitempty"`           // private、public-read 等
+	StorageClass       string            `yaml:"storage_class,omitempty"` // STANDARD、IA、ARCHIVE、DEEP_ARCHIVE
+}
+
+// 压缩后的对象标识方式
+const (
+	CompressionEncoding = "encoding" // 设置Content-Encoding，对象路径不变
+	CompressionSuffix   = "suffix"   // 对象路径追加 .gz、.zst 后缀
+)
+
+// Compression 上传压缩配置，algorithm 为 gzip 或 zstd，已压缩的文件及skip中的扩展名不压缩
+type Compression struct {
+	Enabled   bool     `yaml:"enabled"`
+	Algorithm string   `yaml:"algorithm,omitempty"`
+	Mode      string   `yaml:"mode,omitempty"`
+	Level     int      `yaml:"level,omitempty"`
+	Skip      []string `yaml:"skip,omitempty"`
+}
+
+// ServerSideEncryption 服务端加密配置
+// mode 为 sse 时使用对象存储托管的密钥（SSE-COS、SSE-OSS），为 kms 时使用KMS密钥，为 sse-c 时使用客户提供的密钥
+type ServerSideEncryption struct {
+	Mode        string `yaml:"mode"`
+	KmsKeyId    string `yaml:"kms_key_id,omitempty"`
+	CustomerKey string `yaml:"customer_key,omitempty"` // SSE-C使用的32字节密钥，base64编码
+}
+
+// Encryption 客户端加密配置，密钥依次从key_file、passphrase获取
+type Encryption struct {
+	Enabled    bool   `yaml:"enabled"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	Passphrase string `yaml:"passphrase,omitempty"`
+}
+
+// Osd 对象存储的访问配置
+type Osd struct {
+	SecretId  string `yaml:"secret_id"`
+	SecretKey string `yaml:"secret_key"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint,omitempty"`
+	Timeout   int    `yaml:"timeout"`
+	// SessionToken 临时密钥的token
+	SessionToken string `yaml:"session_token,omitempty"`
+	// CredentialProcess 获取临时密钥的外部命令，输出JSON格式的密钥
+	CredentialProcess string `yaml:"credential_process,omitempty"`
+	// CredentialEndpoint 获取临时密钥的HTTP地址，返回JSON格式的密钥
+	CredentialEndpoint string `yaml:"credential_endpoint,omitempty"`
+	// SecretSource 密钥的实际来源，不从配置文件读取
+	SecretSource string `yaml:"-"`
+}
+
+// Profile 命名的存储配置，包含存储类型和访问配置
+type Profile struct {
+	Storage string `yaml:"storage"`
+	Osd     `yaml:",inline"`
 }
 
 type Path struct {
-	Source string `yaml:"source"`
-	Dest   string `yaml:"dest"`
+	Source  string `yaml:"source"`
+	Dest    string `yaml:"dest"`
+	Profile string `yaml:"profile,omitempty"`
+}
+
+// Job 命名的传输任务，每个任务有独立的方向、路径、过滤、并发、删除策略和存储配置
+type Job struct {
+	Name        string   `yaml:"name"`
+	Direction   string   `yaml:"direction"`
+	Source      string   `yaml:"source"`
+	Dest        string   `yaml:"dest"`
+	Profile     string   `yaml:"profile,omitempty"`
+	Ignore      []string `yaml:"ignore,omitempty"`
+	Concurrency int      `yaml:"concurrency,omitempty"`
+	Delete      bool     `yaml:"delete,omitempty"` // 是否删除目标端存在而源端不存在的文件
+	// Encryption 客户端加密配置，不配置时使用全局的encryption配置
+	Encryption *Encryption `yaml:"encryption,omitempty"`
+	// ServerSideEncryption 服务端加密配置，不配置时使用全局的server_side_encryption配置
+	ServerSideEncryption *ServerSideEncryption `yaml:"server_side_encryption,omitempty"`
+	// Compression 上传压缩配置，不配置时使用全局的compression配置
+	Compression *Compression `yaml:"compression,omitempty"`
+	// Rules 上传对象属性规则，不配置时使用全局的rules配置
+	Rules []ObjectRule `yaml:"rules,omitempty"`
+	// Restore 归档对象取回配置，不配置时使用全局的restore配置
+	Restore *Restore `yaml:"restore,omitempty"`
+}
+
+// Path 获取任务的目录映射
+func (j Job) Path() Path {
+	return Path{Source: j.Source, Dest: j.Dest, Profile: j.Profile}
+}
+
+// GetJob 获取指定名称的任务
+func (c *TransferConfig) GetJob(name string) (*Job, error) {
+	for _, job := range c.GetJobs() {
+		if job.Name == name {
+			return &job, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("job '%s' is not found", name))
+}
+
+// GetJobs 获取所有任务，未单独配置加密、压缩、对象属性的任务使用对应的全局配置
+func (c *TransferConfig) GetJobs() []Job {
+	jobs := make([]Job, 0, len(c.Jobs))
+	for _, job := range c.Jobs {
+		if job.Encryption == nil {
+			job.Encryption = c.Encryption
+		}
+		if job.ServerSideEncryption == nil {
+			job.ServerSideEncryption = c.ServerSideEncryption
+		}
+		if job.Compression == nil {
+			job.Compression = c.Compression
+		}
+		if job.Rules == nil {
+			job.Rules = c.Rules
+		}
+		if job.Restore == nil {
+			job.Restore = c.Restore
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// UploadJobs 把upload配置转换为任务列表
+func (c *TransferConfig) UploadJobs() []Job {
+	jobs := make([]Job, 0, len(c.Upload.List))
+	for _, p := range c.Upload.List {
+		jobs = append(jobs, Job{
+			Direction: DirectionUpload, Source: p.Source, Dest: p.Dest, Profile: p.Profile, Ignore: c.Upload.Ignore,
+			Encryption: c.Encryption, ServerSideEncryption: c.ServerSideEncryption, Compression: c.Compression,
+			Rules: c.Rules,
+		})
+	}
+	return jobs
+}
+
+// DownloadJobs 把download配置转换为任务列表
+func (c *TransferConfig) DownloadJobs() []Job {
+	jobs := make([]Job, 0, len(c.Download.List))
+	for _, p := range c.Download.List {
+		jobs = append(jobs, Job{
+			Direction: DirectionDownload, Source: p.Source, Dest: p.Dest, Profile: p.Profile, Ignore: c.Download.Ignore,
+			Encryption: c.Encryption, ServerSideEncryption: c.ServerSideEncryption, Compression: c.Compression,
+			Restore: c.Restore,
+		})
+	}
+	return jobs
+}
+
+// Load 加载配置文件，替换其中的 ${ENV} 环境变量，解密加密的配置项，并按凭证链获取密钥
+// 配置文件中有未知的配置项时返回错误
+func Load(path string) (*TransferConfig, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &TransferConfig{}
+	if err := decodeStrict(buf, cfg); err != nil {
+		return nil, errors.New(fmt.Sprintf("config file '%s' is invalid, %s", path, err.Error()))
+	}
+	if err := cfg.DecryptSecrets(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ResolveCredentials(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeStrict 解析yaml并只在值中替换 ${ENV} 环境变量，避免环境变量的内容改变yaml结构，注释中的引用也不会被替换
+// 拼写错误等未知的配置项会报错并带上行号
+func decodeStrict(buf []byte, out interface{}) error {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(buf, doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		return nil
+	}
+	var unknown []string
+	checkKnownFields(doc, reflect.TypeOf(out), &unknown)
+	if len(unknown) > 0 {
+		return &yaml.TypeError{Errors: unknown}
+	}
+	expandEnv(doc)
+	return doc.Decode(out)
+}
+
+// expandEnv 替换节点中所有值的 ${ENV} 环境变量，映射的键不替换
+func expandEnv(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value := helper.ExpandEnv(node.Value, os.LookupEnv)
+		if value == node.Value {
+			return
+		}
+		node.Value = value
+		// 未加引号的值按替换后的内容推断类型，如 timeout: ${TIMEOUT} 替换后为数字
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 &&
+			node.Tag == "!!str" {
+			node.Tag = resolveTag(value)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			expandEnv(node.Content[i])
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, n := range node.Content {
+			expandEnv(n)
+		}
+	}
+}
+
+// resolveTag 推断未加引号的值的类型，替换后的内容只作为标量解析，不会产生新的配置项
+func resolveTag(value string) string {
+	n := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(value), n); err != nil || len(n.Content) != 1 ||
+		n.Content[0].Kind != yaml.ScalarNode || n.Content[0].Value != value {
+		return "!!str"
+	}
+	return n.Content[0].Tag
+}
+
+// checkKnownFields 检查映射中的键是否都是结构体中定义的配置项，错误信息与yaml严格解析一致
+func checkKnownFields(node *yaml.Node, typ reflect.Type, unknown *[]string) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			checkKnownFields(n, typ, unknown)
+		}
+	case yaml.AliasNode:
+		checkKnownFields(node.Alias, typ, unknown)
+	case yaml.SequenceNode:
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			for _, n := range node.Content {
+				checkKnownFields(n, typ.Elem(), unknown)
+			}
+		}
+	case yaml.MappingNode:
+		if typ.Kind() == reflect.Map {
+			for i := 1; i < len(node.Content); i += 2 {
+				checkKnownFields(node.Content[i], typ.Elem(), unknown)
+			}
+			return
+		}
+		if typ.Kind() != reflect.Struct {
+			return
+		}
+		fields := yamlFields(typ)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				checkKnownFields(value, typ, unknown)
+				continue
+			}
+			if ft, ok := fields[key.Value]; ok {
+				checkKnownFields(value, ft, unknown)
+			} else {
+				*unknown = append(*unknown, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, typ))
+			}
+		}
+	}
+}
+
+// yamlFields 获取结构体中yaml配置项名称到类型的映射，包含inline的结构体中的配置项
+func yamlFields(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// GetProfile 获取指定名称的存储配置，名称为空时使用默认配置
+// 默认配置为profile指定的配置，未指定时使用storage和osd配置
+func (c *TransferConfig) GetProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" {
+		return &Profile{Storage: c.Storage, Osd: c.Osd}, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok || p == nil {
+		return nil, errors.New(fmt.Sprintf("profile '%s' is not found", name))
+	}
+	return p, nil
+}
+
+// UseProfile 强制所有上传、下载配置及任务都使用指定名称的存储配置
+func (c *TransferConfig) UseProfile(name string) {
+	c.Profile = name
+	for i := range c.Upload.List {
+		c.Upload.List[i].Profile = name
+	}
+	for i := range c.Download.List {
+		c.Download.List[i].Profile = name
+	}
+	for i := range c.Jobs {
+		c.Jobs[i].Profile = name
+	}
+}
+
+// Fingerprint 获取配置的指纹，为去掉密钥后的配置内容的sha256，密钥轮换不影响指纹
+func (c *TransferConfig) Fingerprint() string {
+	buf, err := yaml.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	clean := &TransferConfig{}
+	if err := yaml.Unmarshal(buf, clean); err != nil {
+		return ""
+	}
+	clean.Osd.clearSecrets()
+	for _, p := range clean.Profiles {
+		if p != nil {
+			p.Osd.clearSecrets()
+		}
+	}
+	clearKeys(clean.Encryption, clean.ServerSideEncryption)
+	for i := range clean.Jobs {
+		clearKeys(clean.Jobs[i].Encryption, clean.Jobs[i].ServerSideEncryption)
+	}
+	if buf, err = yaml.Marshal(clean); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// clearSecrets 清除密钥
+func (o *Osd) clearSecrets() {
+	o.SecretId, o.SecretKey, o.SessionToken = "", "", ""
+}
+
+// clearKeys 清除客户端加密的口令和服务端加密的客户密钥
+func clearKeys(enc *Encryption, sse *ServerSideEncryption) {
+	if enc != nil {
+		enc.Passphrase = ""
+	}
+	if sse != nil {
+		sse.CustomerKey = ""
+	}
 }
 
+// GetConfigDemo 获取带注释的模版配置
 func GetConfigDemo() []byte {
-	buf, _ := yaml.Marshal(TransferConfig{
-		Storage: "",
-		Upload: struct {
-			List   []Path   `yaml:"list"`
-			Ignore []string `yaml:"ignore"`
-		}{
-			List: []Path{
-				{
-					Source: "",
-					Dest:   "",
-				},
-			},
-			Ignore: []string{".git", ".idea"},
+	cfg := &TransferConfig{}
+	cfg.Upload.List = []Path{
+		{
+			Source: "",
+			Dest:   "",
 		},
-		Download: struct {
-			List   []Path   `yaml:"list"`
-			Ignore []string `yaml:"ignore,omitempty"`
-		}{
-			List: []Path{
-				{
-					Source: "",
-					Dest:   "",
-				},
-			},
+	}
+	cfg.Upload.Ignore = []string{".git", ".idea"}
+	cfg.Download.List = []Path{
+		{
+			Source: "",
+			Dest:   "",
 		},
-		Osd: struct {
-			SecretId  string `yaml:"secret_id"`
-			SecretKey string `yaml:"secret_key"`
-			Bucket    string `yaml:"bucket"`
-			Region    string `yaml:"region"`
-			Timeout   int    `yaml:"timeout"`
-		}{},
-	})
+	}
+	buf, _ := RenderConfig(cfg)
 	return buf
 }
+
+// configComments 生成配置文件时各配置项的注释，key为配置项的路径
+var configComments = map[string]string{
+	"storage":                "存储对象 cos 或者 oss （分别是腾讯云和阿里云）",
+	"credentials_file":       "凭证文件路径，按 profile名称 -> secret_id、secret_key 保存密钥",
+	"upload":                 "上传配置，把本地的source目录上传到对象存储的dest路径",
+	"upload.ignore":          "上传时忽略的文件和文件夹名称",
+	"download":               "下载配置，把对象存储的source路径下载到本地的dest目录",
+	"osd":                    "密钥可不在此配置，按 环境变量 -> 凭证文件(credentials_file) -> 配置文件 的顺序获取\n任意值都可以使用 ${ENV} 或 ${ENV:-默认值} 引用环境变量",
+	"osd.bucket":             "存储桶名称",
+	"osd.region":             "存储桶的区域代码，比如Oss的cn-shenzhen，比如Cos的ap-guangzhou",
+	"osd.endpoint":           "自定义访问域名，不填时使用默认域名",
+	"osd.timeout":            "单位：秒",
+	"osd.credential_process": "获取临时密钥的外部命令，输出JSON格式的密钥",
+}
+
+// RenderConfig 把配置生成带注释的yaml内容
+func RenderConfig(cfg *TransferConfig) ([]byte, error) {
+	doc := &yaml.Node{}
+	if err := doc.Encode(cfg); err != nil {
+		return nil, err
+	}
+	addComments(doc, "")
+
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// addComments 按配置项路径给yaml节点加上注释，顶层及非单值配置项的注释放在上方，其他的放在行尾
+func addComments(node *yaml.Node, prefix string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+		if comment, ok := configComments[path]; ok {
+			if prefix == "" || value.Kind != yaml.ScalarNode {
+				key.HeadComment = comment
+			} else {
+				key.LineComment = comment
+			}
+		}
+		// 忽略列表等简单的数组使用 [a, b] 的形式
+		if value.Kind == yaml.SequenceNode && len(value.Content) > 0 && value.Content[0].Kind == yaml.ScalarNode {
+			value.Style = yaml.FlowStyle
+		}
+		addComments(value, path)
+	}
+}
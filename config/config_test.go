@@ -0,0 +1,82 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLoadExpandEnv(t *testing.T) {
+	t.Setenv("OSD_TEST_BUCKET", "backup-1250000000")
+	t.Setenv("OSD_TEST_TIMEOUT", "600")
+	t.Setenv("OSD_TEST_INJECT", "x\nkey_file: /tmp/evil.key")
+	t.Setenv("OSD_TEST_SECRET", "a: b # ' \" [c]")
+	tests := []struct {
+		name    string
+		content string
+		check   func(cfg *TransferConfig) string
+		want    string
+		wantErr string
+	}{
+		{
+			"expand values",
+			"storage: cos\nosd:\n  bucket: ${OSD_TEST_BUCKET}\n  region: ${OSD_TEST_MISSING:-ap-guangzhou}\n" +
+				"  timeout: ${OSD_TEST_TIMEOUT}\n",
+			func(cfg *TransferConfig) string {
+				return cfg.Osd.Bucket + "," + cfg.Osd.Region + "," + strconv.Itoa(cfg.Osd.Timeout)
+			},
+			"backup-1250000000,ap-guangzhou,600", "",
+		},
+		{
+			"value with newline is not injected",
+			"storage: cos\nosd:\n  bucket: ${OSD_TEST_INJECT}\n",
+			func(cfg *TransferConfig) string { return cfg.Osd.Bucket + "," + cfg.KeyFile },
+			"x\nkey_file: /tmp/evil.key,", "",
+		},
+		{
+			"value with yaml characters",
+			"storage: cos\nosd:\n  bucket: \"${OSD_TEST_SECRET}\"\n  region: ${OSD_TEST_SECRET}\n",
+			func(cfg *TransferConfig) string { return cfg.Osd.Bucket + "|" + cfg.Osd.Region },
+			"a: b # ' \" [c]|a: b # ' \" [c]", "",
+		},
+		{
+			"comment is not expanded",
+			"storage: cos # ${OSD_TEST_BUCKET\nosd:\n  bucket: b\n",
+			func(cfg *TransferConfig) string { return cfg.Storage + "," + cfg.Osd.Bucket },
+			"cos,b", "",
+		},
+		{
+			"unknown field",
+			"storage: cos\nosd:\n  bucket: b\n  regoin: ap-guangzhou\n",
+			nil, "", "line 4: field regoin not found in type config.Osd",
+		},
+		{
+			"unknown field in inline profile",
+			"storage: cos\nprofiles:\n  prod:\n    storage: oss\n    bucket: b\n    buckt: c\n",
+			nil, "", "line 6: field buckt not found in type config.Profile",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Load error got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load error: %v", err)
+			}
+			if got := tt.check(cfg); got != tt.want {
+				t.Errorf("Load rsp got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// 密钥来源
+const (
+	SourceConfig = "config" // 配置文件
+	SourceEnv    = "env"    // 环境变量
+	SourceFile   = "file"   // 凭证文件
+)
+
+// DefaultProfile 未命名的默认存储配置在凭证文件中的名称
+const DefaultProfile = "default"
+
+// credentialEnvs 各存储类型支持的密钥环境变量，依次为id、key和临时密钥的token，按顺序查找
+var credentialEnvs = map[string][][3]string{
+	"": {
+		{"OSD_SECRET_ID", "OSD_SECRET_KEY", "OSD_SESSION_TOKEN"},
+	},
+	"cos": {
+		{"TENCENTCLOUD_SECRETID", "TENCENTCLOUD_SECRETKEY", "TENCENTCLOUD_SESSIONTOKEN"},
+		{"COS_SECRETID", "COS_SECRETKEY", "COS_SESSIONTOKEN"},
+	},
+	"oss": {
+		{"OSS_ACCESS_KEY_ID", "OSS_ACCESS_KEY_SECRET", "OSS_SESSION_TOKEN"},
+		{"ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_SECURITY_TOKEN"},
+	},
+}
+
+// Credential 凭证文件中的一组密钥
+type Credential struct {
+	SecretId     string `yaml:"secret_id"`
+	SecretKey    string `yaml:"secret_key"`
+	SessionToken string `yaml:"session_token,omitempty"`
+}
+
+// ResolveCredentials 按 环境变量 -> 凭证文件 -> 配置文件 的顺序获取各存储配置的密钥
+func (c *TransferConfig) ResolveCredentials() error {
+	file, err := loadCredentialsFile(c.CredentialsFile)
+	if err != nil {
+		return err
+	}
+	resolveCredential(DefaultProfile, c.Storage, &c.Osd, file)
+	for name, p := range c.Profiles {
+		if p != nil {
+			resolveCredential(name, p.Storage, &p.Osd, file)
+		}
+	}
+	return nil
+}
+
+// resolveCredential 获取单个存储配置的密钥
+func resolveCredential(name string, storage string, osd *Osd, file map[string]Credential) {
+	// 环境变量：先找带配置名称的，如 OSD_PROD_SECRET_ID，再找通用的和各云厂商的
+	envs := [][3]string{{envName(name, "SECRET_ID"), envName(name, "SECRET_KEY"), envName(name, "SESSION_TOKEN")}}
+	envs = append(envs, credentialEnvs[""]...)
+	envs = append(envs, credentialEnvs[strings.ToLower(storage)]...)
+	for _, env := range envs {
+		id, key := os.Getenv(env[0]), os.Getenv(env[1])
+		if id != "" && key != "" {
+			osd.SecretId, osd.SecretKey, osd.SessionToken = id, key, os.Getenv(env[2])
+			osd.SecretSource = SourceEnv
+			return
+		}
+	}
+
+	// 凭证文件
+	if cred, ok := file[name]; ok && cred.SecretId != "" && cred.SecretKey != "" {
+		osd.SecretId, osd.SecretKey, osd.SessionToken = cred.SecretId, cred.SecretKey, cred.SessionToken
+		osd.SecretSource = SourceFile
+		return
+	}
+
+	// 配置文件，加密保存的已在解密时标记了来源
+	if osd.SecretSource == "" && (osd.SecretId != "" || osd.SecretKey != "") {
+		osd.SecretSource = SourceConfig
+	}
+}
+
+// envName 获取带配置名称的环境变量名，如 OSD_PROD_SECRET_ID
+func envName(profile string, suffix string) string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(profile))
+	return fmt.Sprintf("OSD_%s_%s", name, suffix)
+}
+
+// CredentialsFilePath 获取凭证文件路径，未配置时依次使用环境变量 OSD_CREDENTIALS_FILE 和 ~/.osd-tool/credentials
+func CredentialsFilePath(path string) string {
+	if path == "" {
+		path = os.Getenv("OSD_CREDENTIALS_FILE")
+	}
+	if path == "" {
+		homeDir, _ := os.UserHomeDir()
+		path = filepath.Join(homeDir, ".osd-tool", "credentials")
+	}
+	return path
+}
+
+// loadCredentialsFile 加载凭证文件，文件不存在时返回空
+func loadCredentialsFile(path string) (map[string]Credential, error) {
+	path = CredentialsFilePath(path)
+	info, err := os.Stat(path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 凭证文件不允许其他用户访问
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, errors.New(fmt.Sprintf("credentials file %s has insecure permissions %#o, please run: chmod 600 %s",
+			path, info.Mode().Perm(), path))
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	creds := map[string]Credential{}
+	if err := yaml.Unmarshal(buf, &creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// SaveCredential 把密钥写入凭证文件中指定名称的配置，保留文件中的其他配置，文件权限为0600
+func SaveCredential(path string, name string, cred Credential) error {
+	path = CredentialsFilePath(path)
+	creds, err := loadCredentialsFile(path)
+	if err != nil {
+		return err
+	}
+	if creds == nil {
+		creds = map[string]Credential{}
+	}
+	creds[name] = cred
+	buf, err := yaml.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0600)
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/helper"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SourceEncrypted 密钥来源为配置文件中的加密内容
+const SourceEncrypted = "encrypted"
+
+// SecretFields 支持加密保存的配置项
+var SecretFields = []string{"secret_id", "secret_key", "session_token"}
+
+// LoadKeyFile 读取密钥文件中的口令，去掉首尾的空白字符，避免文件末尾的换行导致口令不一致
+func LoadKeyFile(keyFile string) ([]byte, error) {
+	buf, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if key := bytes.TrimSpace(buf); len(key) > 0 {
+		return key, nil
+	}
+	return nil, errors.New(fmt.Sprintf("key file %s is empty", keyFile))
+}
+
+// LoadPassphrase 获取加解密配置项的口令
+// 依次使用 密钥文件 -> 环境变量OSD_KEY_FILE指定的密钥文件 -> 环境变量OSD_PASSPHRASE -> 终端输入
+// confirm为true时终端输入需要重复一次，用于加密时避免输错口令导致无法解密
+func LoadPassphrase(keyFile string, confirm bool) ([]byte, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv("OSD_KEY_FILE")
+	}
+	if keyFile != "" {
+		return LoadKeyFile(keyFile)
+	}
+	if passphrase := os.Getenv("OSD_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("passphrase is required, please set OSD_PASSPHRASE or OSD_KEY_FILE")
+	}
+	fmt.Fprint(os.Stderr, "Enter passphrase: ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// ReadKeyFile 读取配置文件中的key_file配置项，不加载、不解密整个配置
+func ReadKeyFile(path string) (string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	raw := &struct {
+		KeyFile string `yaml:"key_file"`
+	}{}
+	if err := yaml.Unmarshal(buf, raw); err != nil {
+		return "", err
+	}
+	return raw.KeyFile, nil
+}
+
+// DecryptSecrets 解密配置文件中加密保存的密钥，没有加密内容时不需要口令
+func (c *TransferConfig) DecryptSecrets() error {
+	targets := []*Osd{&c.Osd}
+	for _, p := range c.Profiles {
+		if p != nil {
+			targets = append(targets, &p.Osd)
+		}
+	}
+
+	var passphrase []byte
+	for _, osd := range targets {
+		for _, field := range []*string{&osd.SecretId, &osd.SecretKey, &osd.SessionToken} {
+			if !helper.IsEncrypted(*field) {
+				continue
+			}
+			if passphrase == nil {
+				var err error
+				if passphrase, err = LoadPassphrase(c.KeyFile, false); err != nil {
+					return err
+				}
+			}
+			value, err := helper.DecryptString(*field, passphrase)
+			if err != nil {
+				return err
+			}
+			*field = value
+			osd.SecretSource = SourceEncrypted
+		}
+	}
+	return nil
+}
+
+// SetSecret 加密value并写入配置文件中指定存储配置的field配置项，profile为空时写入osd配置
+// keyFile不为空时同时写入key_file配置项，保证加载配置时使用相同的密钥文件解密
+// 通过yaml.Node修改以保留配置文件中的注释
+func SetSecret(path string, profile string, field string, value string, passphrase []byte, keyFile string) error {
+	if !helper.InArray(field, SecretFields) {
+		return errors.New(fmt.Sprintf("field '%s' is not supported, supported fields: %s",
+			field, strings.Join(SecretFields, ", ")))
+	}
+	encrypted, err := helper.EncryptString(value, passphrase)
+	if err != nil {
+		return err
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(buf, doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	keys := []string{"osd", field}
+	if profile != "" {
+		keys = []string{"profiles", profile, field}
+	}
+	node := doc.Content[0]
+	for _, key := range keys {
+		node = mappingValue(node, key)
+	}
+	node.Kind, node.Tag, node.Value, node.Style = yaml.ScalarNode, "!!str", encrypted, 0
+	if keyFile != "" {
+		// 保存绝对路径，避免在其他目录执行时找不到密钥文件
+		if keyFile, err = filepath.Abs(keyFile); err != nil {
+			return err
+		}
+		node = mappingValue(doc.Content[0], "key_file")
+		node.Kind, node.Tag, node.Value, node.Style = yaml.ScalarNode, "!!str", keyFile, 0
+	}
+
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out.Bytes(), info.Mode().Perm())
+}
+
+// mappingValue 获取映射节点中key对应的值节点，不存在时创建
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		node.Kind, node.Tag, node.Value, node.Content = yaml.MappingNode, "!!map", "", nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
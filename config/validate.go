@@ -0,0 +1,290 @@
+package config
+
+import (
+	"fmt"
+	"github.com/jorben/osd-tool/helper"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 配置问题的级别
+const (
+	LevelError   = "error"   // 错误，无法正常执行
+	LevelWarning = "warning" // 警告，可以执行但可能不符合预期
+)
+
+// 各配置项支持的取值，与provider中的定义保持一致
+var (
+	supportedStorages     = []string{"cos", "oss"}
+	supportedSseModes     = []string{"sse", "kms", "sse-c"}
+	supportedCompressions = []string{"gzip", "zstd"}
+	supportedRestoreTiers = []string{"Expedited", "Standard", "Bulk"}
+	supportedClasses      = []string{"STANDARD", "IA", "ARCHIVE", "DEEP_ARCHIVE"}
+)
+
+// Issue 配置校验发现的问题
+type Issue struct {
+	Level   string
+	Field   string // 配置项的路径，如 upload.list[0].source
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Level, i.Field, i.Message)
+}
+
+// validator 收集配置校验的问题
+type validator struct {
+	issues []Issue
+}
+
+func (v *validator) errorf(field string, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{Level: LevelError, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(field string, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{Level: LevelWarning, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// target 传输的目标路径，用于检查目标路径是否重叠
+type target struct {
+	field   string
+	profile string // 上传目标所在的存储配置，下载目标均在本地，为空
+	dest    string
+	delete  bool
+}
+
+// Validate 对配置做语义检查，包括存储配置、路径、任务及加密、压缩等选项，返回发现的问题
+func (c *TransferConfig) Validate() []Issue {
+	v := &validator{}
+	c.validateProfiles(v)
+
+	var uploads, downloads []target
+	for i, p := range c.Upload.List {
+		field := fmt.Sprintf("upload.list[%d]", i)
+		c.validatePath(v, field, DirectionUpload, p)
+		uploads = append(uploads, target{field: field + ".dest", profile: c.profileName(p.Profile), dest: p.Dest})
+	}
+	for i, p := range c.Download.List {
+		field := fmt.Sprintf("download.list[%d]", i)
+		c.validatePath(v, field, DirectionDownload, p)
+		downloads = append(downloads, target{field: field + ".dest", dest: p.Dest})
+	}
+
+	names := map[string]bool{}
+	for i, job := range c.Jobs {
+		field := fmt.Sprintf("jobs[%d]", i)
+		if job.Name == "" {
+			v.errorf(field+".name", "name is required")
+		} else if names[job.Name] {
+			v.errorf(field+".name", "job '%s' is duplicated", job.Name)
+		}
+		names[job.Name] = true
+		if job.Direction != DirectionUpload && job.Direction != DirectionDownload {
+			v.errorf(field+".direction", "direction '%s' is not supported, use upload or download", job.Direction)
+			continue
+		}
+		if job.Concurrency < 0 {
+			v.errorf(field+".concurrency", "concurrency must not be negative")
+		}
+		c.validatePath(v, field, job.Direction, job.Path())
+		c.validateOptions(v, field+".", job.Encryption, job.ServerSideEncryption, job.Compression, job.Restore, job.Rules)
+		t := target{field: field + ".dest", dest: job.Dest, delete: job.Delete}
+		if job.Direction == DirectionUpload {
+			t.profile = c.profileName(job.Profile)
+			uploads = append(uploads, t)
+		} else {
+			// 下载到同一本地目录的任务无论使用哪个存储配置都会互相影响
+			downloads = append(downloads, t)
+		}
+	}
+
+	c.validateOptions(v, "", c.Encryption, c.ServerSideEncryption, c.Compression, c.Restore, c.Rules)
+	if c.Upgrade != nil {
+		if c.Upgrade.Proxy != "" {
+			if u, err := url.Parse(c.Upgrade.Proxy); err != nil || u.Host == "" {
+				v.errorf("upgrade.proxy", "proxy '%s' is invalid, it should be like http://host:port", c.Upgrade.Proxy)
+			}
+		}
+		if c.Upgrade.Timeout < 0 {
+			v.errorf("upgrade.timeout", "timeout must not be negative")
+		}
+	}
+	if c.Report != nil {
+		if c.Report.Path == "" {
+			v.errorf("report.path", "path is required")
+		}
+		if c.Report.Format != "" && c.Report.Format != ReportJson && c.Report.Format != ReportCsv {
+			v.errorf("report.format", "format '%s' is not supported, use %s or %s", c.Report.Format, ReportJson, ReportCsv)
+		}
+	}
+	validateOverlap(v, uploads, func(dest string) string {
+		return strings.Trim(dest, "/") + "/"
+	})
+	validateOverlap(v, downloads, func(dest string) string {
+		return strings.TrimSuffix(filepath.Clean(dest), string(filepath.Separator)) + string(filepath.Separator)
+	})
+	return v.issues
+}
+
+// profileName 获取目录映射实际使用的存储配置名称
+func (c *TransferConfig) profileName(name string) string {
+	if name == "" {
+		return c.Profile
+	}
+	return name
+}
+
+// validateProfiles 检查默认存储配置及profiles中的各存储配置
+func (c *TransferConfig) validateProfiles(v *validator) {
+	if c.Profile != "" {
+		if _, ok := c.Profiles[c.Profile]; !ok {
+			v.errorf("profile", "profile '%s' is not found in profiles", c.Profile)
+		}
+	} else if c.Storage != "" || c.Osd.Bucket != "" || len(c.Profiles) == 0 {
+		validateProfile(v, "osd", &Profile{Storage: c.Storage, Osd: c.Osd})
+	}
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "profiles." + name
+		if c.Profiles[name] == nil {
+			v.errorf(field, "profile is empty")
+			continue
+		}
+		validateProfile(v, field, c.Profiles[name])
+	}
+}
+
+// validateProfile 检查单个存储配置的存储类型、存储桶、地域和密钥
+func validateProfile(v *validator, field string, p *Profile) {
+	storageField := field + ".storage"
+	if field == "osd" {
+		storageField = "storage"
+	}
+	if p.Storage == "" {
+		v.errorf(storageField, "storage is required, use %s", strings.Join(supportedStorages, " or "))
+	} else if !helper.InArray(strings.ToLower(p.Storage), supportedStorages) {
+		v.errorf(storageField, "storage '%s' is not supported, use %s", p.Storage, strings.Join(supportedStorages, " or "))
+	}
+	if p.Bucket == "" {
+		v.errorf(field+".bucket", "bucket is required")
+	}
+	if p.Region == "" && p.Endpoint == "" {
+		v.errorf(field+".region", "region is required when endpoint is not set")
+	}
+	if p.Timeout < 0 {
+		v.errorf(field+".timeout", "timeout must not be negative")
+	}
+	if (p.SecretId == "" || p.SecretKey == "") && p.CredentialProcess == "" && p.CredentialEndpoint == "" {
+		v.warnf(field+".secret_id", "secret_id or secret_key is empty, and no credential_process or credential_endpoint is set")
+	}
+}
+
+// validatePath 检查目录映射的路径和引用的存储配置
+func (c *TransferConfig) validatePath(v *validator, field string, direction string, p Path) {
+	if p.Profile != "" {
+		if _, ok := c.Profiles[p.Profile]; !ok {
+			v.errorf(field+".profile", "profile '%s' is not found in profiles", p.Profile)
+		}
+	}
+	if direction == DirectionUpload {
+		if p.Source == "" {
+			v.errorf(field+".source", "source is required")
+		} else if info, err := os.Stat(p.Source); err != nil {
+			v.errorf(field+".source", "local path '%s' does not exist", p.Source)
+		} else if !info.IsDir() {
+			v.warnf(field+".source", "local path '%s' is not a directory", p.Source)
+		}
+		if strings.Trim(p.Dest, "/") == "" {
+			v.warnf(field+".dest", "dest is empty, files will be uploaded to the root of the bucket")
+		}
+		return
+	}
+	if p.Dest == "" {
+		v.errorf(field+".dest", "dest is required")
+	}
+	if strings.Trim(p.Source, "/") == "" {
+		v.warnf(field+".source", "source is empty, the whole bucket will be downloaded")
+	}
+}
+
+// validateOptions 检查加密、压缩、取回及对象属性等选项，prefix为配置项路径的前缀
+func (c *TransferConfig) validateOptions(v *validator, prefix string, enc *Encryption, sse *ServerSideEncryption,
+	comp *Compression, restore *Restore, rules []ObjectRule) {
+	if enc != nil && enc.Enabled {
+		if enc.KeyFile == "" && enc.Passphrase == "" {
+			v.errorf(prefix+"encryption", "key_file or passphrase is required when encryption is enabled")
+		} else if enc.KeyFile != "" {
+			if _, err := os.Stat(enc.KeyFile); err != nil {
+				v.errorf(prefix+"encryption.key_file", "key file '%s' does not exist", enc.KeyFile)
+			}
+		}
+	}
+	if sse != nil && sse.Mode != "" {
+		if !helper.InArray(sse.Mode, supportedSseModes) {
+			v.errorf(prefix+"server_side_encryption.mode", "mode '%s' is not supported, use %s",
+				sse.Mode, strings.Join(supportedSseModes, ", "))
+		} else if sse.Mode == "sse-c" && sse.CustomerKey == "" {
+			v.errorf(prefix+"server_side_encryption.customer_key", "customer_key is required when mode is sse-c")
+		}
+	}
+	if comp != nil && comp.Enabled {
+		if comp.Algorithm != "" && !helper.InArray(strings.ToLower(comp.Algorithm), supportedCompressions) {
+			v.errorf(prefix+"compression.algorithm", "algorithm '%s' is not supported, use %s",
+				comp.Algorithm, strings.Join(supportedCompressions, " or "))
+		}
+		mode := strings.ToLower(comp.Mode)
+		if mode != "" && mode != CompressionEncoding && mode != CompressionSuffix {
+			v.errorf(prefix+"compression.mode", "mode '%s' is not supported, use %s or %s",
+				comp.Mode, CompressionEncoding, CompressionSuffix)
+		}
+	}
+	if restore != nil {
+		if restore.Tier != "" && !helper.InArray(restore.Tier, supportedRestoreTiers) {
+			v.errorf(prefix+"restore.tier", "tier '%s' is not supported, use %s",
+				restore.Tier, strings.Join(supportedRestoreTiers, ", "))
+		}
+		if restore.Days < 0 || restore.Interval < 0 || restore.Timeout < 0 {
+			v.errorf(prefix+"restore", "days, interval and timeout must not be negative")
+		}
+	}
+	for i, rule := range rules {
+		field := fmt.Sprintf("%srules[%d]", prefix, i)
+		if rule.Pattern == "" {
+			v.errorf(field+".pattern", "pattern is required")
+		}
+		if rule.StorageClass != "" && !helper.InArray(strings.ToUpper(rule.StorageClass), supportedClasses) {
+			v.warnf(field+".storage_class", "storage class '%s' is not one of %s, it is passed to the storage as is",
+				rule.StorageClass, strings.Join(supportedClasses, ", "))
+		}
+	}
+}
+
+// validateOverlap 检查同一存储配置下的目标路径是否重叠，下载目标不区分存储配置，normalize把路径转换为以分隔符结尾的形式
+// 重叠的任务可能互相覆盖，开启删除时会误删对方的文件
+func validateOverlap(v *validator, targets []target, normalize func(dest string) string) {
+	for i := 0; i < len(targets); i++ {
+		for j := i + 1; j < len(targets); j++ {
+			a, b := targets[i], targets[j]
+			if a.profile != b.profile {
+				continue
+			}
+			da, db := normalize(a.dest), normalize(b.dest)
+			if da == "/" || db == "/" || strings.HasPrefix(da, db) || strings.HasPrefix(db, da) {
+				if a.delete || b.delete {
+					v.errorf(b.field, "dest '%s' overlaps with %s '%s', and delete is enabled", b.dest, a.field, a.dest)
+				} else {
+					v.warnf(b.field, "dest '%s' overlaps with %s '%s'", b.dest, a.field, a.dest)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	file := filepath.Join(src, "a.txt")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// base 没有任何问题的配置
+	base := func() *TransferConfig {
+		cfg := &TransferConfig{Storage: "cos"}
+		cfg.Osd = Osd{SecretId: "id", SecretKey: "key", Bucket: "test-1250000000", Region: "ap-guangzhou"}
+		cfg.Upload.List = []Path{{Source: src, Dest: "/backup"}}
+		cfg.Download.List = []Path{{Source: "/backup", Dest: dest}}
+		return cfg
+	}
+	tests := []struct {
+		name   string
+		modify func(cfg *TransferConfig)
+		want   []string // 期望的问题，格式为 level: field
+	}{
+		{"valid", func(cfg *TransferConfig) {}, nil},
+		{"missing storage", func(cfg *TransferConfig) { cfg.Storage = "" }, []string{"error: storage"}},
+		{"bad storage", func(cfg *TransferConfig) { cfg.Storage = "s3" }, []string{"error: storage"}},
+		{"missing bucket and region", func(cfg *TransferConfig) { cfg.Osd.Bucket, cfg.Osd.Region = "", "" },
+			[]string{"error: osd.bucket", "error: osd.region"}},
+		{"endpoint without region", func(cfg *TransferConfig) {
+			cfg.Osd.Region, cfg.Osd.Endpoint = "", "https://cos.example.com"
+		}, nil},
+		{"missing secret", func(cfg *TransferConfig) { cfg.Osd.SecretKey = "" }, []string{"warning: osd.secret_id"}},
+		{"unknown profile", func(cfg *TransferConfig) { cfg.Profile = "prod" }, []string{"error: profile"}},
+		{"bad profile storage", func(cfg *TransferConfig) {
+			cfg.Profiles = map[string]*Profile{"prod": {Storage: "s3", Osd: Osd{Bucket: "b", Region: "r",
+				SecretId: "id", SecretKey: "key"}}}
+		}, []string{"error: profiles.prod.storage"}},
+		{"missing upload source", func(cfg *TransferConfig) { cfg.Upload.List[0].Source = "" },
+			[]string{"error: upload.list[0].source"}},
+		{"upload source not exist", func(cfg *TransferConfig) { cfg.Upload.List[0].Source = filepath.Join(src, "none") },
+			[]string{"error: upload.list[0].source"}},
+		{"upload source is file", func(cfg *TransferConfig) { cfg.Upload.List[0].Source = file },
+			[]string{"warning: upload.list[0].source"}},
+		{"missing download dest", func(cfg *TransferConfig) { cfg.Download.List[0].Dest = "" },
+			[]string{"error: download.list[0].dest"}},
+		{"upload path profile not found", func(cfg *TransferConfig) { cfg.Upload.List[0].Profile = "prod" },
+			[]string{"error: upload.list[0].profile"}},
+		{"upload overlap", func(cfg *TransferConfig) {
+			cfg.Upload.List = append(cfg.Upload.List, Path{Source: src, Dest: "/backup/photos"})
+		}, []string{"warning: upload.list[1].dest"}},
+		{"overlap with delete", func(cfg *TransferConfig) {
+			cfg.Jobs = []Job{{Name: "mirror", Direction: DirectionUpload, Source: src, Dest: "backup/", Delete: true}}
+		}, []string{"error: jobs[0].dest"}},
+		{"overlap on other profile", func(cfg *TransferConfig) {
+			cfg.Profiles = map[string]*Profile{"prod": {Storage: "oss", Osd: Osd{Bucket: "b", Region: "r",
+				SecretId: "id", SecretKey: "key"}}}
+			cfg.Jobs = []Job{{Name: "mirror", Direction: DirectionUpload, Source: src, Dest: "/backup",
+				Profile: "prod", Delete: true}}
+		}, nil},
+		{"download overlap on other profile", func(cfg *TransferConfig) {
+			cfg.Profiles = map[string]*Profile{"prod": {Storage: "oss", Osd: Osd{Bucket: "b", Region: "r",
+				SecretId: "id", SecretKey: "key"}}}
+			cfg.Jobs = []Job{{Name: "mirror", Direction: DirectionDownload, Source: "/other", Dest: dest,
+				Profile: "prod", Delete: true}}
+		}, []string{"error: jobs[0].dest"}},
+		{"download overlap", func(cfg *TransferConfig) {
+			cfg.Download.List = append(cfg.Download.List, Path{Source: "/other", Dest: filepath.Join(dest, "sub")})
+		}, []string{"warning: download.list[1].dest"}},
+		{"bad jobs", func(cfg *TransferConfig) {
+			cfg.Jobs = []Job{
+				{Name: "a", Direction: "sync", Source: src, Dest: "/a"},
+				{Name: "a", Direction: DirectionDownload, Source: "/a", Dest: filepath.Join(dest, "a"), Concurrency: -1},
+				{Direction: DirectionDownload, Source: "/b", Dest: filepath.Join(t.TempDir(), "b")},
+			}
+		}, []string{"error: jobs[0].direction", "error: jobs[1].name", "error: jobs[1].concurrency",
+			"error: jobs[2].name", "warning: jobs[1].dest"}},
+		{"bad enums", func(cfg *TransferConfig) {
+			cfg.ServerSideEncryption = &ServerSideEncryption{Mode: "aes"}
+			cfg.Compression = &Compression{Enabled: true, Algorithm: "brotli", Mode: "inline"}
+			cfg.Restore = &Restore{Tier: "Fast", Days: -1}
+			cfg.Report = &Report{Path: "report.json", Format: "xml"}
+		}, []string{"error: server_side_encryption.mode", "error: compression.algorithm", "error: compression.mode",
+			"error: restore.tier", "error: restore", "error: report.format"}},
+		{"sse-c without key", func(cfg *TransferConfig) { cfg.ServerSideEncryption = &ServerSideEncryption{Mode: "sse-c"} },
+			[]string{"error: server_side_encryption.customer_key"}},
+		{"encryption without key", func(cfg *TransferConfig) { cfg.Encryption = &Encryption{Enabled: true} },
+			[]string{"error: encryption"}},
+		{"encryption key file not exist", func(cfg *TransferConfig) {
+			cfg.Encryption = &Encryption{Enabled: true, KeyFile: filepath.Join(src, "none.key")}
+		}, []string{"error: encryption.key_file"}},
+		{"job options", func(cfg *TransferConfig) {
+			cfg.Jobs = []Job{{Name: "a", Direction: DirectionUpload, Source: src, Dest: "/a",
+				Rules: []ObjectRule{{StorageClass: "COLD"}}}}
+		}, []string{"error: jobs[0].rules[0].pattern", "warning: jobs[0].rules[0].storage_class"}},
+		{"bad upgrade", func(cfg *TransferConfig) { cfg.Upgrade = &Upgrade{Proxy: "127.0.0.1", Timeout: -1} },
+			[]string{"error: upgrade.proxy", "error: upgrade.timeout"}},
+		{"missing report path", func(cfg *TransferConfig) { cfg.Report = &Report{} }, []string{"error: report.path"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base()
+			tt.modify(cfg)
+			var got []string
+			for _, issue := range cfg.Validate() {
+				got = append(got, issue.Level+": "+issue.Field)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Validate rsp got %v, want %v", cfg.Validate(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadKnownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr []string
+	}{
+		{"known fields", "storage: cos\nosd:\n  bucket: b\njobs:\n  - name: a\n    direction: upload\n", nil},
+		{"unknown top level", "storage: cos\nuplaod:\n  list: []\n", []string{"line 2: field uplaod not found"}},
+		{"unknown in job", "jobs:\n  - name: a\n    dirction: upload\n", []string{"line 3: field dirction not found"}},
+		{"unknown in rule", "rules:\n  - pattern: '*.jpg'\n    storage: IA\n", []string{"line 3: field storage not found"}},
+		{"unknown in path", "upload:\n  list:\n    - source: /a\n      desc: /b\n", []string{"line 4: field desc not found"}},
+		{"all unknown reported", "storage: cos\nbucket: b\nosd:\n  regoin: r\n",
+			[]string{"line 2: field bucket not found", "line 4: field regoin not found"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Load error got %v, want nil", err)
+				}
+				return
+			}
+			for _, want := range tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("Load error got %v, want %s", err, want)
+				}
+			}
+		})
+	}
+}
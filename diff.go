@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/logger"
+	"github.com/jorben/osd-tool/provider"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DiffOnlyLocal  = "only-local"  // 仅本地存在
+	DiffOnlyRemote = "only-remote" // 仅云端存在
+	DiffDifferent  = "different"   // 两端都存在但内容不同
+)
+
+// DiffEntry 本地与云端的一条差异
+type DiffEntry struct {
+	Direction  string `json:"direction"`
+	Status     string `json:"status"`
+	Key        string `json:"key"`
+	Local      string `json:"local"`
+	Reason     string `json:"reason,omitempty"`
+	LocalSize  int64  `json:"local_size"`
+	RemoteSize int64  `json:"remote_size"` // 客户端加密的对象为加密前的大小
+}
+
+// DiffOptions 差异对比方式
+type DiffOptions struct {
+	Size     bool // 对比文件大小
+	Checksum bool // 对比md5与ETag
+	Mtime    bool // 对比修改时间，源端更新时视为不同
+}
+
+// localFile 本地文件信息
+type localFile struct {
+	path       string
+	info       fs.FileInfo
+	compressed bool // 上传时压缩，云端对象的大小和md5与本地文件不同
+}
+
+// remoteObject 云端对象信息
+type remoteObject struct {
+	provider.Object
+	local string // 对象对应的本地路径，下载时与实际下载的路径一致
+}
+
+// Diff 按任务的上传或下载规则，对比任务目录映射在本地与云端的差异
+func (t *CloudTransfer) Diff(job config.Job, opt DiffOptions) ([]DiffEntry, error) {
+	locals, remotes, err := t.collect(job)
+	if err != nil {
+		return nil, err
+	}
+	dir, direction := job.Path(), job.Direction
+	p, err := t.GetProvider(dir.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []DiffEntry
+	for key, local := range locals {
+		entry := DiffEntry{Direction: direction, Key: key, Local: local.path, LocalSize: local.info.Size()}
+		remote, ok := remotes[key]
+		if !ok {
+			entry.Status = DiffOnlyLocal
+			list = append(list, entry)
+			continue
+		}
+		entry.RemoteSize = remote.Size
+		meta := func() map[string]string {
+			obj, err := p.Head(key, &provider.GetOptions{Encryption: job.ServerSideEncryption})
+			if err != nil {
+				return nil
+			}
+			if size, err := strconv.ParseInt(obj.Meta[MetaPlainSize], 10, 64); err == nil && isEncryptedObject(obj.Meta) {
+				entry.RemoteSize = size
+			}
+			return obj.Meta
+		}
+		if reason := compareFile(local, remote.Object, direction, opt, meta); reason != "" {
+			entry.Status = DiffDifferent
+			entry.Reason = archivedReason(direction, remote.Object, reason)
+			list = append(list, entry)
+		}
+	}
+	for key, remote := range remotes {
+		if _, ok := locals[key]; ok {
+			continue
+		}
+		list = append(list, DiffEntry{
+			Direction: direction, Status: DiffOnlyRemote, Key: key, Local: remote.local, RemoteSize: remote.Size,
+			Reason: archivedReason(direction, remote.Object, ""),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list, nil
+}
+
+// archivedReason 下载时归档对象需要取回后才能下载，在差异原因中标记为archived
+func archivedReason(direction string, remote provider.Object, reason string) string {
+	if direction != config.DirectionDownload || !provider.IsArchived(remote.StorageClass) {
+		return reason
+	}
+	if reason == "" {
+		return "archived"
+	}
+	return reason + ",archived"
+}
+
+// collect 按任务的上传或下载规则，获取目录映射两端的文件，均以对象存储路径为key
+// 下载时与download一致，按对象元数据而不是任务当前的压缩配置判断对象对应的本地文件
+func (t *CloudTransfer) collect(job config.Job) (map[string]localFile, map[string]remoteObject, error) {
+	locals := map[string]localFile{}
+	remotes := map[string]remoteObject{}
+	dir, ignore := job.Path(), job.Ignore
+	p, err := t.GetProvider(dir.Profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.Direction == config.DirectionUpload {
+		compressor, err := newContentCompressor(job.Compression)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = t.walkUpload(dir, ignore, func(key string, path string, info fs.FileInfo) {
+			compressed := compressor != nil && compressor.shouldCompress(path)
+			if compressed {
+				key = compressor.objectKey(key)
+			}
+			locals[key] = localFile{path: path, info: info, compressed: compressed}
+		}, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		prefix := dirPrefix(dir.Dest)
+		objects, err := p.List(prefix, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, obj := range objects {
+			if strings.HasSuffix(obj.Key, "/") || isIgnoredKey(strings.TrimPrefix(obj.Key, prefix), ignore) {
+				continue
+			}
+			local := strings.Replace(obj.Key, strings.TrimLeft(dir.Dest, "/"), dir.Source, 1)
+			remotes[obj.Key] = remoteObject{Object: obj, local: local}
+		}
+		return locals, remotes, nil
+	}
+
+	prefix := strings.TrimLeft(dir.Source, "/")
+	keys := map[string]string{} // 本地路径到对象路径
+	err = walkDownload(p, dir, ignore, func(obj provider.Object, dest string) {
+		if strings.HasSuffix(obj.Key, "/") {
+			return
+		}
+		// 只有带压缩后缀的对象可能下载到去掉后缀的路径，需要获取元数据
+		if hasCompressionExt(obj.Key) {
+			head, err := p.Head(obj.Key, &provider.GetOptions{Encryption: job.ServerSideEncryption})
+			if err != nil {
+				logger.Warn("head error, compare it as uncompressed", "key", obj.Key, "error", err)
+			} else {
+				dest = decompressedPath(dest, head.Meta, head.ContentEncoding)
+			}
+		}
+		remotes[obj.Key] = remoteObject{Object: obj, local: dest}
+		keys[filepath.Clean(dest)] = obj.Key
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = filepath.Walk(dir.Dest, func(path string, info fs.FileInfo, err error) error {
+		if info == nil || info.IsDir() {
+			return nil
+		}
+		key := strings.Replace(path, dir.Dest, prefix, 1)
+		// 按后缀方式压缩的对象，对应去掉后缀的本地文件
+		compressed := false
+		if k, ok := keys[filepath.Clean(path)]; ok {
+			compressed = filepath.Clean(path) != filepath.Clean(strings.Replace(k, prefix, dir.Dest, 1))
+			key = k
+		}
+		if isIgnoredKey(strings.TrimPrefix(key, prefix), ignore) {
+			return nil
+		}
+		locals[key] = localFile{path: path, info: info, compressed: compressed}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return locals, remotes, nil
+}
+
+// compareFile 对比本地文件和云端对象，返回不同的原因，相同时返回空字符串
+// 大小或md5不同时通过meta获取对象的元数据，客户端加密的对象按加密前的大小对比且不对比md5，压缩的对象不对比大小和md5
+func compareFile(local localFile, remote provider.Object, direction string, opt DiffOptions,
+	meta func() map[string]string) string {
+	var m map[string]string
+	loaded := false
+	// 只在需要时获取一次元数据
+	objectMeta := func() map[string]string {
+		if !loaded {
+			m, loaded = meta(), true
+		}
+		return m
+	}
+	if opt.Size && !local.compressed && local.info.Size() != remote.Size {
+		m := objectMeta()
+		switch {
+		case m[MetaCompression] != "":
+		case isEncryptedObject(m):
+			if m[MetaPlainSize] != strconv.FormatInt(local.info.Size(), 10) {
+				return "size"
+			}
+		default:
+			return "size"
+		}
+	}
+	// 分片上传的ETag不是md5，无法对比
+	if opt.Checksum && !local.compressed && len(remote.ETag) == 32 {
+		sum, err := helper.FileMd5(local.path)
+		if err == nil && !strings.EqualFold(sum, remote.ETag) {
+			if m := objectMeta(); m[MetaCompression] == "" && !isEncryptedObject(m) {
+				return "checksum"
+			}
+		}
+	}
+	if opt.Mtime && !remote.LastModified.IsZero() {
+		if direction == config.DirectionUpload && local.info.ModTime().After(remote.LastModified) {
+			return "mtime"
+		}
+		if direction == config.DirectionDownload && remote.LastModified.After(local.info.ModTime()) {
+			return "mtime"
+		}
+	}
+	return ""
+}
+
+// isIgnoredKey 判断对象路径中是否有需要忽略的文件或文件夹
+func isIgnoredKey(rel string, ignore []string) bool {
+	for _, name := range strings.Split(rel, "/") {
+		if helper.InArray(name, ignore) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDiffOptions 解析逗号分隔的对比方式，如 size,checksum,mtime
+func ParseDiffOptions(compare string) (DiffOptions, error) {
+	opt := DiffOptions{}
+	for _, item := range strings.Split(compare, ",") {
+		switch strings.TrimSpace(strings.ToLower(item)) {
+		case "size":
+			opt.Size = true
+		case "checksum":
+			opt.Checksum = true
+		case "mtime":
+			opt.Mtime = true
+		case "":
+		default:
+			return opt, errors.New(fmt.Sprintf("compare method '%s' is not supported", item))
+		}
+	}
+	return opt, nil
+}
+
+// PrintDiff 按指定格式输出差异列表
+func PrintDiff(w io.Writer, format string, list []DiffEntry) error {
+	switch strings.ToLower(format) {
+	case "", "json":
+		enc := json.NewEncoder(w)
+		for _, entry := range list {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"direction", "status", "key", "local", "reason", "local_size", "remote_size"})
+		for _, e := range list {
+			_ = cw.Write([]string{e.Direction, e.Status, e.Key, e.Local, e.Reason,
+				strconv.FormatInt(e.LocalSize, 10), strconv.FormatInt(e.RemoteSize, 10)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return errors.New(fmt.Sprintf("output format '%s' is not supported", format))
+	}
+}
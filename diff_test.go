@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/provider"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("hello osd-tool"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	local := localFile{path: path, info: info}
+	// 与本地文件不同的md5
+	md5 := "0f1b3ac1fe2ba7d5f8be5e0a2f1e07a5"
+	encrypted := map[string]string{MetaEncryption: EncryptionAlgorithm, MetaPlainSize: "14"}
+	size := DiffOptions{Size: true}
+	checksum := DiffOptions{Checksum: true}
+	tests := []struct {
+		name   string
+		remote provider.Object
+		opt    DiffOptions
+		meta   map[string]string
+		want   string
+	}{
+		{"same size", provider.Object{Size: 14}, size, nil, ""},
+		{"different size", provider.Object{Size: 20}, size, map[string]string{}, "size"},
+		{"encrypted same plain size", provider.Object{Size: 50}, size, encrypted, ""},
+		{"encrypted different plain size", provider.Object{Size: 50}, size,
+			map[string]string{MetaEncryption: EncryptionAlgorithm, MetaPlainSize: "15"}, "size"},
+		{"compressed by other job", provider.Object{Size: 30}, size, map[string]string{MetaCompression: "zstd"}, ""},
+		{"head failed", provider.Object{Size: 20}, size, nil, "size"},
+		{"different checksum", provider.Object{ETag: md5}, checksum, map[string]string{}, "checksum"},
+		{"encrypted checksum skipped", provider.Object{ETag: md5}, checksum, encrypted, ""},
+		{"multipart etag skipped", provider.Object{ETag: md5 + "-2"}, checksum, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heads := 0
+			meta := func() map[string]string {
+				heads++
+				return tt.meta
+			}
+			if got := compareFile(local, tt.remote, config.DirectionUpload, tt.opt, meta); got != tt.want {
+				t.Errorf("compareFile rsp got %q, want %q", got, tt.want)
+			}
+			if heads > 1 {
+				t.Errorf("compareFile heads got %d, want at most 1", heads)
+			}
+		})
+	}
+}
+
+func TestDiffListError(t *testing.T) {
+	p := newMemProvider()
+	p.listErr = errors.New("list error")
+	for _, direction := range []string{config.DirectionUpload, config.DirectionDownload} {
+		job := config.Job{Name: "a", Direction: direction, Source: t.TempDir(), Dest: "/backup"}
+		if direction == config.DirectionDownload {
+			job.Source, job.Dest = "/backup", t.TempDir()
+		}
+		// 列出失败时不把部分结果当作差异返回
+		if list, err := newMemTransfer(p).Diff(job, DiffOptions{Size: true}); err == nil || list != nil {
+			t.Errorf("Diff %s rsp got %v %v, want list error", direction, list, err)
+		}
+	}
+}
+
+func TestDiffDownloadMapping(t *testing.T) {
+	dest := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "hello osd-tool", "b.txt": "b"} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := newMemProvider()
+	compressed := map[string]string{MetaCompression: helper.Gzip}
+	// 上传时按后缀方式压缩的对象，压缩后大小与本地文件不同
+	p.objects["backup/a.txt.gz"], p.meta["backup/a.txt.gz"] = []byte("gz"), compressed
+	p.objects["backup/d.txt.gz"], p.meta["backup/d.txt.gz"] = []byte("gz"), compressed
+	// 本身就是gz文件的对象，没有压缩元数据
+	p.objects["backup/b.txt.gz"] = []byte("gz")
+	p.objects["backup/c.txt"] = []byte("c")
+	p.classes = map[string]string{"backup/c.txt": provider.StorageArchive}
+
+	// 任务当前未开启压缩，按对象元数据判断与download一致
+	job := config.Job{Name: "a", Direction: config.DirectionDownload, Source: "/backup", Dest: dest}
+	list, err := newMemTransfer(p).Diff(job, DiffOptions{Size: true, Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range list {
+		got = append(got, strings.Join([]string{e.Status, e.Key, filepath.Base(e.Local), e.Reason}, " "))
+	}
+	want := []string{
+		"only-local backup/b.txt b.txt ",
+		"only-remote backup/b.txt.gz b.txt.gz ",
+		"only-remote backup/c.txt c.txt archived",
+		"only-remote backup/d.txt.gz d.txt ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Diff rsp got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
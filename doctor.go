@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/provider"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 诊断检查的结果
+const (
+	DoctorPass = "PASS"
+	DoctorFail = "FAIL"
+	DoctorSkip = "SKIP"
+)
+
+// DefaultDoctorTimeout 未配置timeout时DNS解析、TLS握手的超时时间
+const DefaultDoctorTimeout = 10 * time.Second
+
+// cosBucketPattern cos的存储桶名称需要带有APPID后缀
+var cosBucketPattern = regexp.MustCompile(`^[a-z0-9-]+-\d+$`)
+
+// DoctorCheck 单项诊断检查的结果
+type DoctorCheck struct {
+	Name   string
+	Result string
+	Detail string
+	Hint   string
+}
+
+// doctor 按顺序执行诊断检查，前置检查失败时跳过依赖它的检查
+type doctor struct {
+	profile *config.Profile
+	prefix  string // 读写检查使用的临时对象所在的目录，与任务的目标目录一样以/结尾
+	timeout time.Duration
+	checks  []*DoctorCheck
+}
+
+// RunDoctor 诊断存储配置，依次检查配置、域名解析、TLS、密钥及列出、上传、下载、删除权限
+func RunDoctor(profile *config.Profile, prefix string) []*DoctorCheck {
+	d := &doctor{profile: profile, prefix: dirPrefix(prefix), timeout: DefaultDoctorTimeout}
+	if profile.Timeout > 0 {
+		d.timeout = time.Duration(profile.Timeout) * time.Second
+	}
+	if !d.checkConfig() {
+		return d.skip("endpoint", "dns", "tls", "credentials", "list", "put", "get", "delete")
+	}
+	u, ok := d.checkEndpoint()
+	if !ok {
+		return d.skip("dns", "tls", "credentials", "list", "put", "get", "delete")
+	}
+	if proxy, _ := http.ProxyFromEnvironment(&http.Request{URL: u}); proxy != nil {
+		// 通过代理访问时由代理解析域名，本机的解析结果没有参考意义
+		for _, name := range []string{"dns", "tls"} {
+			d.add(name, DoctorSkip, fmt.Sprintf("proxy %s is used", proxy.Host), "")
+		}
+	} else {
+		if !d.checkDns(u.Hostname()) {
+			return d.skip("tls", "credentials", "list", "put", "get", "delete")
+		}
+		if !d.checkTls(u) {
+			return d.skip("credentials", "list", "put", "get", "delete")
+		}
+	}
+	if !d.checkCredentials() {
+		return d.skip("list", "put", "get", "delete")
+	}
+
+	p, err := newProvider(profile)
+	if err != nil {
+		d.fail("list", err, "")
+		return d.skip("put", "get", "delete")
+	}
+	return d.checkObjects(p)
+}
+
+// checkObjects 依次检查列出、上传、下载、删除权限，上传失败时跳过下载和删除
+func (d *doctor) checkObjects(p provider.Provider) []*DoctorCheck {
+	d.checkList(p)
+	key, content, ok := d.checkPut(p)
+	if !ok {
+		return d.skip("get", "delete")
+	}
+	d.checkGet(p, key, content)
+	d.checkDelete(p, key)
+	return d.checks
+}
+
+// add 记录检查结果
+func (d *doctor) add(name string, result string, detail string, hint string) {
+	d.checks = append(d.checks, &DoctorCheck{Name: name, Result: result, Detail: detail, Hint: hint})
+}
+
+// fail 记录失败的检查，未指定提示时按错误推断
+func (d *doctor) fail(name string, err error, hint string) {
+	if hint == "" {
+		hint = d.hint(err)
+	}
+	d.add(name, DoctorFail, err.Error(), hint)
+}
+
+// skip 记录被跳过的检查
+func (d *doctor) skip(names ...string) []*DoctorCheck {
+	for _, name := range names {
+		d.add(name, DoctorSkip, "", "")
+	}
+	return d.checks
+}
+
+// last 获取最后一项检查结果
+func (d *doctor) last() *DoctorCheck {
+	return d.checks[len(d.checks)-1]
+}
+
+// checkConfig 检查存储类型、存储桶名称和地域
+func (d *doctor) checkConfig() bool {
+	storage := strings.ToLower(d.profile.Storage)
+	switch {
+	case storage != provider.COS && storage != provider.OSS:
+		d.add("config", DoctorFail, fmt.Sprintf("storage '%s' is not supported", d.profile.Storage),
+			"storage 的取值为 cos 或 oss")
+	case d.profile.Bucket == "":
+		d.add("config", DoctorFail, "bucket is empty", "请在配置文件中设置 bucket")
+	case d.profile.Region == "" && d.profile.Endpoint == "":
+		d.add("config", DoctorFail, "region is empty", "请在配置文件中设置 region 或 endpoint")
+	case storage == provider.COS && !cosBucketPattern.MatchString(d.profile.Bucket):
+		d.add("config", DoctorFail, fmt.Sprintf("bucket '%s' has no APPID suffix", d.profile.Bucket),
+			"COS的存储桶名称需要带APPID后缀，如 name-1250000000，可在控制台的存储桶列表中查看")
+	default:
+		d.add("config", DoctorPass, fmt.Sprintf("storage: %s, bucket: %s, region: %s",
+			storage, d.profile.Bucket, valueOrNone(d.profile.Region)), "")
+		return true
+	}
+	return false
+}
+
+// checkEndpoint 检查实际访问的域名
+func (d *doctor) checkEndpoint() (u *url.URL, ok bool) {
+	bucketUrl, err := provider.BucketURL(d.profile)
+	if err != nil {
+		d.fail("endpoint", err, "endpoint 需要带协议头，如 https://cos.ap-beijing.myqcloud.com")
+		return nil, false
+	}
+	d.add("endpoint", DoctorPass, bucketUrl.String(), "")
+	return bucketUrl, true
+}
+
+// checkDns 检查域名解析
+func (d *doctor) checkDns(host string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		hint := "域名无法解析，请检查 region、endpoint 是否正确及本机的DNS设置"
+		if strings.ToLower(d.profile.Storage) == provider.COS && d.profile.Endpoint == "" {
+			hint = "域名无法解析，请检查 region 和 bucket 是否正确，COS的存储桶名称需要带APPID后缀，如 name-1250000000"
+		}
+		d.fail("dns", err, hint)
+		return false
+	}
+	d.add("dns", DoctorPass, strings.Join(addrs, ", "), "")
+	return true
+}
+
+// checkTls 检查TLS握手和证书，http协议的endpoint跳过检查
+func (d *doctor) checkTls(u *url.URL) bool {
+	if u.Scheme != "https" {
+		d.add("tls", DoctorSkip, fmt.Sprintf("endpoint uses %s", u.Scheme), "")
+		return true
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: d.timeout}, "tcp", addr,
+		&tls.Config{ServerName: u.Hostname()})
+	if err != nil {
+		d.fail("tls", err, "无法建立TLS连接，请检查网络、防火墙、代理设置及系统时间")
+		return false
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	detail := tls.VersionName(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		detail += fmt.Sprintf(", certificate expires at %s",
+			state.PeerCertificates[0].NotAfter.Format(time.RFC3339))
+	}
+	d.add("tls", DoctorPass, detail, "")
+	return true
+}
+
+// checkCredentials 检查能否获取到密钥，密钥是否有效由后续的请求验证
+func (d *doctor) checkCredentials() bool {
+	creds, err := provider.NewCredentialProvider(d.profile).Retrieve()
+	if err != nil {
+		d.fail("credentials", err, "获取密钥失败，请检查 credential_process、credential_endpoint 配置")
+		return false
+	}
+	if creds.SecretId == "" || creds.SecretKey == "" {
+		d.add("credentials", DoctorFail, "secret_id or secret_key is empty",
+			"请在配置文件、环境变量 OSD_SECRET_ID、OSD_SECRET_KEY 或凭证文件中设置密钥")
+		return false
+	}
+	detail := "secret_id: " + helper.HideSecret(creds.SecretId, 8)
+	if d.profile.SecretSource != "" {
+		detail += ", source: " + d.profile.SecretSource
+	}
+	if !creds.Expiration.IsZero() {
+		detail += ", expires at " + creds.Expiration.Format(time.RFC3339)
+	}
+	d.add("credentials", DoctorPass, detail, "")
+	return true
+}
+
+// checkList 检查列出对象的权限，同时验证密钥和存储桶是否正确
+func (d *doctor) checkList(p provider.Provider) {
+	list, err := p.ListPage(d.prefix, 1)
+	if err != nil {
+		d.fail("list", err, "")
+		return
+	}
+	d.add("list", DoctorPass, fmt.Sprintf("%d objects listed under '%s'", len(list), d.prefix), "")
+}
+
+// checkPut 上传临时对象，返回对象路径和内容
+func (d *doctor) checkPut(p provider.Provider) (string, []byte, bool) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		d.fail("put", err, "")
+		return "", nil, false
+	}
+	key := d.prefix + ".osd-tool-doctor-" + hex.EncodeToString(buf)
+	content := []byte("osd-tool doctor " + time.Now().Format(time.RFC3339Nano))
+
+	fd, err := os.CreateTemp("", "osd-tool-doctor-*")
+	if err != nil {
+		d.fail("put", err, "")
+		return "", nil, false
+	}
+	defer os.Remove(fd.Name())
+	_, err = fd.Write(content)
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		d.fail("put", err, "")
+		return "", nil, false
+	}
+	if err := p.PutFile(key, fd.Name(), nil); err != nil {
+		d.fail("put", err, "")
+		return "", nil, false
+	}
+	d.add("put", DoctorPass, key, "")
+	return key, content, true
+}
+
+// checkGet 下载临时对象并比较内容
+func (d *doctor) checkGet(p provider.Provider, key string, content []byte) {
+	fd, err := os.CreateTemp("", "osd-tool-doctor-*")
+	if err != nil {
+		d.fail("get", err, "")
+		return
+	}
+	fd.Close()
+	defer os.Remove(fd.Name())
+	if err := p.GetFile(key, fd.Name(), nil); err != nil {
+		d.fail("get", err, "")
+		return
+	}
+	got, err := os.ReadFile(fd.Name())
+	if err != nil {
+		d.fail("get", err, "")
+		return
+	}
+	if !bytes.Equal(got, content) {
+		d.add("get", DoctorFail, "content of the downloaded object does not match",
+			"请检查存储桶是否配置了回源、图片处理等会改写内容的规则")
+		return
+	}
+	d.add("get", DoctorPass, fmt.Sprintf("%d bytes", len(got)), "")
+}
+
+// checkDelete 删除临时对象
+func (d *doctor) checkDelete(p provider.Provider, key string) {
+	if err := p.Delete(key); err != nil {
+		d.fail("delete", err, "")
+		d.last().Hint += fmt.Sprintf("，需要手动删除临时对象 %s", key)
+		return
+	}
+	d.add("delete", DoctorPass, key, "")
+}
+
+// hint 按对象存储返回的错误推断可能的原因
+func (d *doctor) hint(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "请求超时，请检查网络和代理设置，或调大 timeout"
+	}
+	status, code := provider.ErrorCode(err)
+	switch code {
+	case "InvalidAccessKeyId", "InvalidSecretId", "SignatureDoesNotMatch", "InvalidAccessKey", "ExpiredToken",
+		"InvalidSecurityToken", "SecurityTokenExpired":
+		return "密钥无效或已过期，请检查 secret_id、secret_key 及临时密钥的 session_token"
+	case "RequestTimeTooSkewed":
+		return "本机时间与服务端相差过大，请校准系统时间"
+	case "NoSuchBucket", "InvalidBucketName":
+		return d.bucketHint()
+	case "AccessDenied":
+		return "当前密钥没有该操作的权限，请检查CAM/RAM访问策略和存储桶的权限设置"
+	}
+	switch status {
+	case http.StatusForbidden:
+		return "当前密钥没有该操作的权限，请检查CAM/RAM访问策略和存储桶的权限设置"
+	case http.StatusNotFound:
+		return d.bucketHint()
+	}
+	return ""
+}
+
+// bucketHint 存储桶不存在时的提示
+func (d *doctor) bucketHint() string {
+	if strings.ToLower(d.profile.Storage) == provider.COS {
+		return "存储桶不存在，请检查 bucket 和 region，COS的存储桶名称需要带APPID后缀，如 name-1250000000"
+	}
+	return "存储桶不存在，请检查 bucket 和 region 是否正确"
+}
+
+// PrintDoctorReport 输出诊断结果表格，返回失败的检查项数
+func PrintDoctorReport(w io.Writer, checks []*DoctorCheck) int {
+	failed := 0
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL\tHINT")
+	for _, c := range checks {
+		if c.Result == DoctorFail {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Result, valueOrNone(c.Detail), c.Hint)
+	}
+	tw.Flush()
+	return failed
+}
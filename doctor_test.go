@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/provider"
+	"github.com/tencentyun/cos-go-sdk-v5"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// doctorProvider 测试用的Provider，对象保存在内存中，可指定各操作返回的错误
+type doctorProvider struct {
+	provider.Provider
+	objects   map[string][]byte
+	listErr   error
+	putErr    error
+	getErr    error
+	deleteErr error
+	rewrite   bool // 下载时改写对象内容
+}
+
+func (s *doctorProvider) ListPage(prefix string, maxKeys int) ([]provider.Object, error) {
+	return nil, s.listErr
+}
+
+func (s *doctorProvider) PutFile(key string, filepath string, opt *provider.PutOptions) error {
+	if s.putErr != nil {
+		return s.putErr
+	}
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = content
+	return nil
+}
+
+func (s *doctorProvider) GetFile(key string, filepath string, opt *provider.GetOptions) error {
+	if s.getErr != nil {
+		return s.getErr
+	}
+	content := s.objects[key]
+	if s.rewrite {
+		content = append([]byte("rewritten "), content...)
+	}
+	return os.WriteFile(filepath, content, 0600)
+}
+
+func (s *doctorProvider) Delete(key string) error {
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+// doctorResults 将检查结果转为 name:result 列表，忽略受本机网络和代理影响的dns、tls检查
+func doctorResults(checks []*DoctorCheck) string {
+	var got []string
+	for _, c := range checks {
+		if c.Name == "dns" || c.Name == "tls" {
+			continue
+		}
+		got = append(got, c.Name+":"+c.Result)
+	}
+	return strings.Join(got, " ")
+}
+
+// cosErr 构造cos返回的错误
+func cosErr(status int, code string) error {
+	req, _ := http.NewRequest(http.MethodGet, "https://test-1250000000.cos.ap-guangzhou.myqcloud.com/a.txt", nil)
+	return &cos.ErrorResponse{Response: &http.Response{StatusCode: status, Request: req}, Code: code}
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile config.Profile
+		want    bool
+		detail  string
+	}{
+		{"valid cos", config.Profile{Storage: "cos", Osd: config.Osd{Bucket: "test-1250000000", Region: "ap-guangzhou"}},
+			true, ""},
+		{"valid oss", config.Profile{Storage: "OSS", Osd: config.Osd{Bucket: "test", Region: "oss-cn-hangzhou"}},
+			true, ""},
+		{"cos without appid", config.Profile{Storage: "cos", Osd: config.Osd{Bucket: "test", Region: "ap-guangzhou"}},
+			false, "bucket 'test' has no APPID suffix"},
+		{"cos endpoint without region", config.Profile{Storage: "cos",
+			Osd: config.Osd{Bucket: "test-1250000000", Endpoint: "https://cos.example.com"}}, true, ""},
+		{"unsupported storage", config.Profile{Storage: "s3", Osd: config.Osd{Bucket: "test", Region: "r"}},
+			false, "storage 's3' is not supported"},
+		{"missing bucket", config.Profile{Storage: "cos", Osd: config.Osd{Region: "ap-guangzhou"}},
+			false, "bucket is empty"},
+		{"missing region", config.Profile{Storage: "oss", Osd: config.Osd{Bucket: "test"}}, false, "region is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &doctor{profile: &tt.profile}
+			if got := d.checkConfig(); got != tt.want {
+				t.Errorf("checkConfig rsp got %v, want %v", got, tt.want)
+			}
+			if c := d.last(); !tt.want && c.Detail != tt.detail {
+				t.Errorf("checkConfig detail got %s, want %s", c.Detail, tt.detail)
+			}
+		})
+	}
+}
+
+func TestDoctorHint(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		err     error
+		want    string
+	}{
+		{"timeout", "cos", &url.Error{Op: "Get", URL: "https://a", Err: os.ErrDeadlineExceeded}, "请求超时"},
+		{"cos invalid secret", "cos", cosErr(http.StatusForbidden, "InvalidSecretId"), "密钥无效"},
+		{"cos signature", "cos", cosErr(http.StatusForbidden, "SignatureDoesNotMatch"), "密钥无效"},
+		{"oss expired token", "oss", oss.ServiceError{StatusCode: http.StatusForbidden, Code: "SecurityTokenExpired"},
+			"密钥无效"},
+		{"time skewed", "cos", cosErr(http.StatusForbidden, "RequestTimeTooSkewed"), "校准系统时间"},
+		{"cos no such bucket", "cos", cosErr(http.StatusNotFound, "NoSuchBucket"), "APPID后缀"},
+		{"oss no such bucket", "oss", oss.ServiceError{StatusCode: http.StatusNotFound, Code: "NoSuchBucket"},
+			"存储桶不存在，请检查 bucket 和 region 是否正确"},
+		{"access denied", "oss", oss.ServiceError{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, "没有该操作的权限"},
+		{"forbidden without code", "cos", cosErr(http.StatusForbidden, ""), "没有该操作的权限"},
+		{"not found without code", "cos", cosErr(http.StatusNotFound, ""), "存储桶不存在"},
+		{"unknown error", "cos", errors.New("connection reset"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &doctor{profile: &config.Profile{Storage: tt.storage}}
+			got := d.hint(tt.err)
+			if (tt.want == "" && got != "") || !strings.Contains(got, tt.want) {
+				t.Errorf("hint rsp got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDoctorSkip(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile config.Profile
+		want    string
+	}{
+		{"config failed", config.Profile{Storage: "cos", Osd: config.Osd{Bucket: "test", Region: "ap-guangzhou"}},
+			"config:FAIL endpoint:SKIP credentials:SKIP list:SKIP put:SKIP get:SKIP delete:SKIP"},
+		{"endpoint failed", config.Profile{Storage: "cos",
+			Osd: config.Osd{Bucket: "test-1250000000", Endpoint: "cos.example.com"}},
+			"config:PASS endpoint:FAIL credentials:SKIP list:SKIP put:SKIP get:SKIP delete:SKIP"},
+		{"credentials failed", config.Profile{Storage: "cos",
+			Osd: config.Osd{Bucket: "test-1250000000", Endpoint: "http://127.0.0.1:1"}},
+			"config:PASS endpoint:PASS credentials:FAIL list:SKIP put:SKIP get:SKIP delete:SKIP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := doctorResults(RunDoctor(&tt.profile, "")); got != tt.want {
+				t.Errorf("RunDoctor rsp got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckObjects(t *testing.T) {
+	denied := cosErr(http.StatusForbidden, "AccessDenied")
+	tests := []struct {
+		name     string
+		provider *doctorProvider
+		want     string
+		wantHint string // 最后一项检查的提示
+	}{
+		{"all passed", &doctorProvider{}, "list:PASS put:PASS get:PASS delete:PASS", ""},
+		{"list failed", &doctorProvider{listErr: denied}, "list:FAIL put:PASS get:PASS delete:PASS", ""},
+		{"put failed", &doctorProvider{putErr: denied}, "list:PASS put:FAIL get:SKIP delete:SKIP", ""},
+		{"get failed", &doctorProvider{getErr: denied}, "list:PASS put:PASS get:FAIL delete:PASS", ""},
+		{"content mismatch", &doctorProvider{rewrite: true}, "list:PASS put:PASS get:FAIL delete:PASS", ""},
+		{"delete failed", &doctorProvider{deleteErr: denied}, "list:PASS put:PASS get:PASS delete:FAIL",
+			"需要手动删除临时对象 tmp/.osd-tool-doctor-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.provider.objects = map[string][]byte{}
+			d := &doctor{profile: &config.Profile{Storage: "cos"}, prefix: dirPrefix("/tmp")}
+			checks := d.checkObjects(tt.provider)
+			if got := doctorResults(checks); got != tt.want {
+				t.Errorf("checkObjects rsp got %s, want %s", got, tt.want)
+			}
+			if hint := d.last().Hint; !strings.Contains(hint, tt.wantHint) {
+				t.Errorf("checkObjects hint got %s, want %s", hint, tt.wantHint)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/provider"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiskUsage 某个前缀下的存储用量
+type DiskUsage struct {
+	Prefix  string                 `json:"prefix"`
+	Count   int64                  `json:"count"`
+	Size    int64                  `json:"size"`
+	Classes map[string]*ClassUsage `json:"classes"`
+}
+
+// ClassUsage 某种存储类型的用量
+type ClassUsage struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// add 累加一个对象的用量
+func (u *DiskUsage) add(obj provider.Object) {
+	u.Count++
+	u.Size += obj.Size
+	class := obj.StorageClass
+	if class == "" {
+		class = "STANDARD"
+	}
+	if u.Classes == nil {
+		u.Classes = map[string]*ClassUsage{}
+	}
+	if _, ok := u.Classes[class]; !ok {
+		u.Classes[class] = &ClassUsage{}
+	}
+	u.Classes[class].Count++
+	u.Classes[class].Size += obj.Size
+}
+
+// classNames 按名称排序的存储类型
+func (u *DiskUsage) classNames() []string {
+	names := make([]string, 0, len(u.Classes))
+	for name := range u.Classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Du 统计prefix下的存储用量，按depth层目录聚合，返回各前缀的用量及总量，列出对象失败时返回错误
+func Du(p provider.Provider, prefix string, depth int) ([]*DiskUsage, *DiskUsage, error) {
+	prefix = strings.TrimLeft(prefix, "/")
+	objects, err := p.List(prefix, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	total := &DiskUsage{Prefix: prefix}
+	groups := map[string]*DiskUsage{}
+	for _, obj := range objects {
+		group := groupPrefix(prefix, obj.Key, depth)
+		if _, ok := groups[group]; !ok {
+			groups[group] = &DiskUsage{Prefix: group}
+		}
+		groups[group].add(obj)
+		total.add(obj)
+	}
+
+	usages := make([]*DiskUsage, 0, len(groups))
+	for _, u := range groups {
+		usages = append(usages, u)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Prefix < usages[j].Prefix
+	})
+	return usages, total, nil
+}
+
+// groupPrefix 获取key在depth层目录下所属的前缀，直接位于该层的文件归入上一层
+func groupPrefix(prefix string, key string, depth int) string {
+	rel := strings.TrimPrefix(key, prefix)
+	if strings.HasPrefix(rel, "/") {
+		prefix += "/"
+		rel = rel[1:]
+	}
+	parts := strings.Split(rel, "/")
+	// 最后一段是文件名，不作为目录参与聚合
+	dirs := parts[:len(parts)-1]
+	if depth < len(dirs) {
+		dirs = dirs[:depth]
+	}
+	if len(dirs) == 0 {
+		return prefix
+	}
+	return prefix + strings.Join(dirs, "/") + "/"
+}
+
+// PrintDu 按指定格式输出存储用量
+func PrintDu(w io.Writer, format string, usages []*DiskUsage, total *DiskUsage) error {
+	switch strings.ToLower(format) {
+	case "", "text":
+		for _, u := range append(usages, total) {
+			name := u.Prefix
+			if u == total {
+				name = "total"
+			} else if name == "" {
+				name = "/"
+			}
+			fmt.Fprintf(w, "%12s %10d  %s\n", helper.FormatBytes(u.Size), u.Count, name)
+			for _, class := range u.classNames() {
+				c := u.Classes[class]
+				fmt.Fprintf(w, "%12s %10d    - %s\n", helper.FormatBytes(c.Size), c.Count, class)
+			}
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			List  []*DiskUsage `json:"list"`
+			Total *DiskUsage   `json:"total"`
+		}{usages, total})
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"prefix", "storage_class", "count", "bytes"})
+		// 总量与text格式一致，以total作为前缀输出在最后
+		for _, u := range append(usages, total) {
+			name := u.Prefix
+			if u == total {
+				name = "total"
+			}
+			for _, class := range u.classNames() {
+				c := u.Classes[class]
+				_ = cw.Write([]string{name, class,
+					strconv.FormatInt(c.Count, 10), strconv.FormatInt(c.Size, 10)})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return errors.New(fmt.Sprintf("output format '%s' is not supported", format))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jorben/osd-tool/provider"
+	"testing"
+)
+
+func TestGroupPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		key    string
+		depth  int
+		want   string
+	}{
+		{"", "a/b/c.txt", 1, "a/"},
+		{"", "a/b/c.txt", 2, "a/b/"},
+		{"", "a/b/c.txt", 5, "a/b/"},
+		{"", "c.txt", 1, ""},
+		{"", "a/b/c.txt", 0, ""},
+		{"data", "data/a/b.txt", 1, "data/a/"},
+	}
+	for _, tt := range tests {
+		if got := groupPrefix(tt.prefix, tt.key, tt.depth); got != tt.want {
+			t.Errorf("groupPrefix(%q, %q, %d) rsp got %q, want %q", tt.prefix, tt.key, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestPrintDuCsv(t *testing.T) {
+	a := &DiskUsage{Prefix: "a/"}
+	a.add(provider.Object{Size: 10})
+	b := &DiskUsage{Prefix: "b/"}
+	b.add(provider.Object{Size: 5, StorageClass: "ARCHIVE"})
+	total := &DiskUsage{}
+	total.add(provider.Object{Size: 10})
+	total.add(provider.Object{Size: 5, StorageClass: "ARCHIVE"})
+
+	var buf bytes.Buffer
+	if err := PrintDu(&buf, "csv", []*DiskUsage{a, b}, total); err != nil {
+		t.Fatal(err)
+	}
+	want := "prefix,storage_class,count,bytes\n" +
+		"a/,STANDARD,1,10\n" +
+		"b/,ARCHIVE,1,5\n" +
+		"total,ARCHIVE,1,5\n" +
+		"total,STANDARD,1,10\n"
+	if got := buf.String(); got != want {
+		t.Errorf("PrintDu rsp got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDuListError(t *testing.T) {
+	p := newMemProvider()
+	p.objects["a/b.txt"] = []byte("b")
+	p.listErr = errors.New("list error")
+	// 列出失败时不返回部分统计结果
+	if usages, total, err := Du(p, "", 1); err == nil || usages != nil || total != nil {
+		t.Errorf("Du rsp got %v %v %v, want list error", usages, total, err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/helper"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 客户端加密相关的对象元数据
+const (
+	MetaEncryption = "osd-encryption"  // 加密算法
+	MetaKdfSalt    = "osd-kdf-salt"    // 派生密钥加密密钥使用的随机盐
+	MetaWrappedKey = "osd-wrapped-key" // 被密钥加密密钥加密的数据密钥
+	MetaNonce      = "osd-nonce"       // 分块加密的基础nonce
+	MetaPlainSize  = "osd-plain-size"  // 加密前的文件大小
+)
+
+// EncryptionAlgorithm 客户端加密使用的算法
+const EncryptionAlgorithm = "aes-256-gcm-chunked"
+
+// dataKeySize 每个对象随机生成的数据密钥长度
+const dataKeySize = 32
+
+// contentCipher 客户端加密器，上传前加密文件，下载后解密文件
+// 每个对象使用随机的数据密钥加密，数据密钥由口令和随机盐派生的密钥加密密钥加密后保存在对象元数据中
+// 派生较慢，上传时每次执行生成一个随机盐，下载时按对象的盐缓存派生结果
+type contentCipher struct {
+	secret []byte
+	salt   []byte
+	mu     sync.Mutex
+	keks   map[string][]byte // 盐到密钥加密密钥的缓存
+}
+
+// newContentCipher 按配置获取客户端加密器，未开启加密时返回nil
+func newContentCipher(cfg *config.Encryption) (*contentCipher, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	secret := []byte(cfg.Passphrase)
+	if cfg.KeyFile != "" {
+		buf, err := config.LoadKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = buf
+	}
+	if len(strings.TrimSpace(string(secret))) == 0 {
+		return nil, errors.New("encryption is enabled but key_file and passphrase are empty")
+	}
+
+	salt := make([]byte, helper.SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return &contentCipher{secret: secret, salt: salt, keks: map[string][]byte{}}, nil
+}
+
+// kek 获取盐对应的密钥加密密钥
+func (c *contentCipher) kek(salt []byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if kek, ok := c.keks[string(salt)]; ok {
+		return kek
+	}
+	kek := helper.DeriveKey(c.secret, salt)
+	c.keks[string(salt)] = kek
+	return kek
+}
+
+// encryptFile 加密文件到临时文件，返回临时文件路径和需要写入对象的元数据，调用方负责删除临时文件
+func (c *contentCipher) encryptFile(path string) (string, map[string]string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	dst, err := os.CreateTemp("", "osd-tool-*.enc")
+	if err != nil {
+		return "", nil, err
+	}
+	defer dst.Close()
+
+	key := make([]byte, dataKeySize)
+	nonce := make([]byte, helper.StreamNonceSize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		os.Remove(dst.Name())
+		return "", nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		os.Remove(dst.Name())
+		return "", nil, err
+	}
+	wrapped, err := helper.WrapKey(c.kek(c.salt), key)
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", nil, err
+	}
+	if err := helper.EncryptStream(dst, src, key, nonce); err != nil {
+		os.Remove(dst.Name())
+		return "", nil, err
+	}
+	return dst.Name(), map[string]string{
+		MetaEncryption: EncryptionAlgorithm,
+		MetaKdfSalt:    base64.StdEncoding.EncodeToString(c.salt),
+		MetaWrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		MetaNonce:      base64.StdEncoding.EncodeToString(nonce),
+		MetaPlainSize:  strconv.FormatInt(info.Size(), 10),
+	}, nil
+}
+
+// decryptFile 按对象元数据解密文件到dest
+func (c *contentCipher) decryptFile(path string, dest string, meta map[string]string) error {
+	if meta[MetaEncryption] != EncryptionAlgorithm {
+		return errors.New(fmt.Sprintf("encryption algorithm '%s' is not supported", meta[MetaEncryption]))
+	}
+	salt, err := base64.StdEncoding.DecodeString(meta[MetaKdfSalt])
+	if err != nil || len(salt) != helper.SaltSize {
+		return errors.New(fmt.Sprintf("object metadata %s is invalid", MetaKdfSalt))
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(meta[MetaWrappedKey])
+	if err != nil {
+		return err
+	}
+	key, err := helper.UnwrapKey(c.kek(salt), wrapped)
+	if err != nil {
+		return errors.New("decrypt data key failed, the key_file or passphrase may be wrong")
+	}
+	nonce, err := base64.StdEncoding.DecodeString(meta[MetaNonce])
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	// 先写到同目录的临时文件，解密成功后再替换，避免留下不完整的文件
+	dst, err := os.CreateTemp(filepath.Dir(dest), ".osd-tool-*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := helper.DecryptStream(dst, src, key, nonce); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return os.Rename(dst.Name(), dest)
+}
+
+// isEncryptedObject 判断对象是否为客户端加密上传的
+func isEncryptedObject(meta map[string]string) bool {
+	return meta[MetaEncryption] != ""
+}
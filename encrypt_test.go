@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/jorben/osd-tool/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentCipher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("hello osd-tool"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newContentCipher(&config.Encryption{Enabled: true, Passphrase: "passphrase"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, meta, err := c.encryptFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp)
+	// 每个对象使用不同的数据密钥，同一次执行的对象共用盐
+	otherTmp, other, _ := c.encryptFile(path)
+	os.Remove(otherTmp)
+	if meta[MetaWrappedKey] == other[MetaWrappedKey] || meta[MetaKdfSalt] != other[MetaKdfSalt] {
+		t.Errorf("encryptFile meta got %v and %v, want different wrapped keys with the same salt", meta, other)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		keyFile    string // 密钥文件的内容，不为空时使用密钥文件
+		modify     func(meta map[string]string)
+		wantErr    bool
+	}{
+		{"same passphrase", "passphrase", "", func(meta map[string]string) {}, false},
+		{"key file with newline", "", "passphrase\n", func(meta map[string]string) {}, false},
+		{"wrong passphrase", "other", "", func(meta map[string]string) {}, true},
+		{"without wrapped key", "passphrase", "", func(meta map[string]string) { delete(meta, MetaWrappedKey) }, true},
+		{"invalid salt", "passphrase", "", func(meta map[string]string) { meta[MetaKdfSalt] = "YWJj" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 下载时是另一次执行，使用新的加密器
+			cfg := &config.Encryption{Enabled: true, Passphrase: tt.passphrase}
+			if tt.keyFile != "" {
+				cfg.KeyFile = filepath.Join(dir, "osd.key")
+				if err := os.WriteFile(cfg.KeyFile, []byte(tt.keyFile), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d, err := newContentCipher(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := map[string]string{}
+			for k, v := range meta {
+				m[k] = v
+			}
+			tt.modify(m)
+			dest := filepath.Join(dir, tt.name+".out")
+			err = d.decryptFile(tmp, dest, m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decryptFile error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got, _ := os.ReadFile(dest); string(got) != "hello osd-tool" {
+				t.Errorf("decryptFile rsp got %q, want %q", got, "hello osd-tool")
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package helper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Unarchive 解压tgz或zip压缩包到dest，按文件头判断格式
+// 会拒绝解压到dest以外的路径、绝对路径以及指向dest以外的链接，不保留setuid等特殊权限位
+func Unarchive(src string, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return UnarchiveFile(f, dest)
+}
+
+// UnarchiveFile 从已打开的文件解压到dest，从文件头开始读取，用于解压已校验过的同一个文件句柄
+func UnarchiveFile(f *os.File, dest string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	// dest本身可能是链接（如macOS的/tmp），后续按真实路径判断是否越界
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if bytes.HasPrefix(head[:n], []byte{'P', 'K', 0x03, 0x04}) {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return unzip(f, info.Size(), root)
+	}
+	return untar(f, root)
+}
+
+// untar 解压tgz压缩包
+func untar(r io.Reader, root string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = extractDir(root, hdr.Name, hdr.FileInfo().Mode())
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractFile(root, hdr.Name, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = extractSymlink(root, hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = extractHardlink(root, hdr.Name, hdr.Linkname)
+		default:
+			// 设备文件、FIFO等不需要解压
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// unzip 解压zip压缩包
+func unzip(r io.ReaderAt, size int64, root string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = extractDir(root, f.Name, mode)
+		case mode&os.ModeSymlink != 0:
+			err = extractZipSymlink(root, f)
+		case mode.IsRegular():
+			err = extractZipFile(root, f)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractZipFile 解压zip中的文件，读取完立即关闭
+func extractZipFile(root string, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return extractFile(root, f.Name, rc, f.Mode())
+}
+
+// extractZipSymlink 解压zip中的链接，链接目标为文件内容
+func extractZipSymlink(root string, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return extractSymlink(root, f.Name, string(target))
+}
+
+// extractDir 创建目录，保证目录可写以便解压其中的文件
+func extractDir(root string, name string, mode os.FileMode) error {
+	if filepath.Clean(filepath.FromSlash(name)) == "." {
+		return nil
+	}
+	target, err := safePath(root, name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, mode.Perm()|0700)
+}
+
+// extractFile 写入文件，已存在的文件或链接先删除，避免通过链接写到其他位置
+func extractFile(root string, name string, r io.Reader, mode os.FileMode) error {
+	target, err := safePath(root, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fd, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := io.Copy(fd, r); err != nil {
+		return err
+	}
+	return fd.Close()
+}
+
+// extractSymlink 创建链接，链接目标只能是不含 .. 的相对路径，保证链接只指向所在目录之下
+func extractSymlink(root string, name string, linkname string) error {
+	target, err := safePath(root, name)
+	if err != nil {
+		return err
+	}
+	link := filepath.Clean(filepath.FromSlash(linkname))
+	if linkname == "" || filepath.IsAbs(link) || filepath.VolumeName(link) != "" || hasDotDot(link) {
+		return errors.New(fmt.Sprintf("archive entry '%s' links to '%s' outside the directory", name, linkname))
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(link, target)
+}
+
+// extractHardlink 创建硬链接，源文件需要是已解压的普通文件
+func extractHardlink(root string, name string, linkname string) error {
+	target, err := safePath(root, name)
+	if err != nil {
+		return err
+	}
+	source, err := safePath(root, linkname)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New(fmt.Sprintf("archive entry '%s' links to '%s' which is not a regular file", name, linkname))
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// safePath 获取压缩包中的文件解压后的路径，拒绝绝对路径、越出root的路径
+// 同时创建上级目录，并按上级目录的真实路径判断，避免通过已解压的链接越界
+func safePath(root string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == "." || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || hasDotDot(clean) {
+		return "", errors.New(fmt.Sprintf("archive entry '%s' is outside the directory", name))
+	}
+	parent := filepath.Join(root, filepath.Dir(clean))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	realParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(root, realParent) {
+		return "", errors.New(fmt.Sprintf("archive entry '%s' is outside the directory", name))
+	}
+	return filepath.Join(realParent, filepath.Base(clean)), nil
+}
+
+// hasDotDot 判断路径中是否有 .. 路径段
+func hasDotDot(path string) bool {
+	for _, part := range strings.Split(path, string(filepath.Separator)) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin 判断path是否为root或root之下的路径
+func isWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
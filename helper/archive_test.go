@@ -0,0 +1,222 @@
+package helper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry 测试用的压缩包条目
+type archiveEntry struct {
+	name string
+	body string
+	link string // 不为空时为软链接
+	mode os.FileMode
+	dir  bool
+}
+
+func writeTgz(t *testing.T, path string, entries []archiveEntry) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: int64(e.mode), Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.mode == 0 {
+			hdr.Mode = 0644
+		}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	gw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, path string, entries []archiveEntry) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		body := e.body
+		switch {
+		case e.dir:
+			mode |= os.ModeDir
+		case e.link != "":
+			mode |= os.ModeSymlink
+			body = e.link
+		}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnarchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		wantErr bool
+		files   map[string]string // 解压后应存在的文件及内容
+	}{
+		{
+			"regular files",
+			[]archiveEntry{
+				{name: "bin/", dir: true, mode: 0755},
+				{name: "bin/osd-tool", body: "binary", mode: 0755},
+				{name: "./README.md", body: "readme"},
+			},
+			false,
+			map[string]string{"bin/osd-tool": "binary", "README.md": "readme"},
+		},
+		{
+			"file without dir entry",
+			[]archiveEntry{{name: "a/b/c.txt", body: "c"}},
+			false,
+			map[string]string{"a/b/c.txt": "c"},
+		},
+		{"parent traversal", []archiveEntry{{name: "../evil.txt", body: "x"}}, true, nil},
+		{"nested traversal", []archiveEntry{{name: "a/../../evil.txt", body: "x"}}, true, nil},
+		{"absolute path", []archiveEntry{{name: "/tmp/evil.txt", body: "x"}}, true, nil},
+		{
+			"symlink inside",
+			[]archiveEntry{{name: "data.txt", body: "data"}, {name: "link.txt", link: "data.txt"}},
+			false,
+			map[string]string{"data.txt": "data", "link.txt": "data"},
+		},
+		{"symlink to parent", []archiveEntry{{name: "link", link: "../"}}, true, nil},
+		{"symlink absolute", []archiveEntry{{name: "link", link: "/etc"}}, true, nil},
+		{
+			"traversal after symlink",
+			[]archiveEntry{{name: "dir", link: "."}, {name: "dir/../../evil.txt", body: "x"}},
+			true,
+			nil,
+		},
+	}
+
+	for _, format := range []string{"tgz", "zip"} {
+		for _, tt := range tests {
+			t.Run(format+" "+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				src := filepath.Join(dir, "pkg."+format)
+				if format == "zip" {
+					writeZip(t, src, tt.entries)
+				} else {
+					writeTgz(t, src, tt.entries)
+				}
+				dest := filepath.Join(dir, "out")
+				err := Unarchive(src, dest)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Unarchive rsp got %v, wantErr %v", err, tt.wantErr)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+					t.Errorf("Unarchive wrote file outside dest")
+				}
+				for name, want := range tt.files {
+					got, err := os.ReadFile(filepath.Join(dest, name))
+					if err != nil || string(got) != want {
+						t.Errorf("Unarchive file %s got %q, want %q, error: %v", name, got, want, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestUnarchiveMode(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "pkg.tgz")
+	writeTgz(t, src, []archiveEntry{
+		{name: "run", body: "x", mode: 04755}, // tar中的setuid位
+		{name: "ro", body: "x", mode: 0444},
+	})
+	dest := filepath.Join(dir, "out")
+	// 已存在的文件被覆盖，不受原有权限影响
+	os.MkdirAll(dest, 0755)
+	os.WriteFile(filepath.Join(dest, "ro"), []byte("old"), 0400)
+	if err := Unarchive(src, dest); err != nil {
+		t.Fatalf("Unarchive error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want os.FileMode
+	}{
+		{"run", 0755},
+		{"ro", 0444},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := os.Lstat(filepath.Join(dest, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 受umask影响，只检查没有多出的权限位
+			if got := info.Mode(); got&^tt.want != 0 {
+				t.Errorf("Unarchive mode got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnarchiveExistingSymlink(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	dest := filepath.Join(dir, "out")
+	os.MkdirAll(outside, 0755)
+	os.MkdirAll(dest, 0755)
+	// dest中已有指向外部的链接，不能通过它写到外部
+	if err := os.Symlink(outside, filepath.Join(dest, "escape")); err != nil {
+		t.Skip("symlink is not supported")
+	}
+	if err := os.Symlink(filepath.Join(outside, "target.txt"), filepath.Join(dest, "file.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		entry   string
+		wantErr bool
+	}{
+		{"write into linked dir", "escape/evil.txt", true},
+		{"overwrite link", "file.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(dir, "pkg.tgz")
+			writeTgz(t, src, []archiveEntry{{name: tt.entry, body: "x"}})
+			if err := Unarchive(src, dest); (err != nil) != tt.wantErr {
+				t.Fatalf("Unarchive rsp got %v, wantErr %v", err, tt.wantErr)
+			}
+			if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+				t.Errorf("Unarchive wrote %d files outside dest", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package helper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 支持的压缩算法
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// compressedExts 已压缩格式的扩展名，再次压缩基本没有收益
+var compressedExts = []string{
+	".gz", ".tgz", ".zst", ".zip", ".bz2", ".xz", ".7z", ".rar", ".lz4", ".br",
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic", ".avif",
+	".mp3", ".aac", ".m4a", ".ogg", ".flac", ".mp4", ".m4v", ".mov", ".mkv", ".avi", ".webm",
+	".docx", ".xlsx", ".pptx", ".jar", ".apk", ".woff", ".woff2",
+}
+
+// compressedMagics 已压缩格式的文件头
+var compressedMagics = [][]byte{
+	{0x1f, 0x8b},                       // gzip
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{'P', 'K', 0x03, 0x04},             // zip
+	{'B', 'Z', 'h'},                    // bzip2
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, // 7z
+	{'R', 'a', 'r', '!', 0x1a, 0x07},   // rar
+	{0x04, 0x22, 0x4d, 0x18},           // lz4
+}
+
+// IsCompressedExt 判断文件扩展名是否为已压缩的格式，extra为额外指定的扩展名
+func IsCompressedExt(name string, extra []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return false
+	}
+	for _, e := range append(compressedExts, extra...) {
+		if ext == strings.ToLower(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCompressedContent 根据文件开头的内容判断是否为已压缩的格式
+func IsCompressedContent(head []byte) bool {
+	for _, magic := range compressedMagics {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	contentType := http.DetectContentType(head)
+	for _, prefix := range []string{"image/jpeg", "image/png", "image/gif", "image/webp", "video/", "audio/"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCompressedFile 根据扩展名和文件内容判断文件是否已压缩
+func IsCompressedFile(path string, extra []string) (bool, error) {
+	if IsCompressedExt(path, extra) {
+		return true, nil
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(fd, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return IsCompressedContent(head[:n]), nil
+}
+
+// IsCompressedBy 根据文件开头的内容判断是否为指定算法压缩的内容
+func IsCompressedBy(head []byte, algorithm string) bool {
+	switch algorithm {
+	case Gzip:
+		return bytes.HasPrefix(head, compressedMagics[0])
+	case Zstd:
+		return bytes.HasPrefix(head, compressedMagics[1])
+	}
+	return false
+}
+
+// CompressStream 按指定算法压缩src写入dst，level为0时使用默认压缩级别
+func CompressStream(dst io.Writer, src io.Reader, algorithm string, level int) error {
+	var w io.WriteCloser
+	var err error
+	switch algorithm {
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err = gzip.NewWriterLevel(dst, level)
+	case Zstd:
+		opts := []zstd.EOption{}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		w, err = zstd.NewWriter(dst, opts...)
+	default:
+		err = errors.New(fmt.Sprintf("compression algorithm '%s' is not supported", algorithm))
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// DecompressStream 按指定算法解压src写入dst
+func DecompressStream(dst io.Writer, src io.Reader, algorithm string) error {
+	switch algorithm {
+	case Gzip:
+		r, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(dst, r)
+		return err
+	case Zstd:
+		r, err := zstd.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(dst, r)
+		return err
+	default:
+		return errors.New(fmt.Sprintf("compression algorithm '%s' is not supported", algorithm))
+	}
+}
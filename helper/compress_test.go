@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIsCompressedExt(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		extra []string
+		want  bool
+	}{
+		{"text file", "/logs/app.log", nil, false},
+		{"no extension", "/logs/README", nil, false},
+		{"gzip", "/logs/app.log.gz", nil, true},
+		{"upper case", "/photos/IMG_001.JPG", nil, true},
+		{"extra extension", "/data/export.parquet", []string{".parquet"}, true},
+		{"extra not matched", "/data/export.csv", []string{".parquet"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsCompressedExt(tt.file, tt.extra); got != tt.want {
+				t.Errorf("IsCompressedExt rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCompressedContent(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"empty", []byte{}, false},
+		{"plain text", []byte("2023-03-09 12:00:00 INFO started"), false},
+		{"json", []byte(`{"key": "value"}`), false},
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, true},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, true},
+		{"zip", []byte("PK\x03\x04rest"), true},
+		{"png", []byte("\x89PNG\x0d\x0a\x1a\x0a"), true},
+		{"jpeg", []byte{0xff, 0xd8, 0xff, 0xe0}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsCompressedContent(tt.head); got != tt.want {
+				t.Errorf("IsCompressedContent rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressStream(t *testing.T) {
+	plain := bytes.Repeat([]byte("2023-03-09 12:00:00 INFO request finished\n"), 1000)
+	tests := []struct {
+		name      string
+		algorithm string
+		level     int
+	}{
+		{"gzip default", Gzip, 0},
+		{"gzip best", Gzip, 9},
+		{"zstd default", Zstd, 0},
+		{"zstd level 19", Zstd, 19},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := &bytes.Buffer{}
+			if err := CompressStream(compressed, bytes.NewReader(plain), tt.algorithm, tt.level); err != nil {
+				t.Fatalf("CompressStream error: %v", err)
+			}
+			if compressed.Len() >= len(plain) {
+				t.Errorf("CompressStream size got %v, want less than %v", compressed.Len(), len(plain))
+			}
+			if !IsCompressedBy(compressed.Bytes(), tt.algorithm) {
+				t.Errorf("IsCompressedBy got false, want true")
+			}
+			decompressed := &bytes.Buffer{}
+			if err := DecompressStream(decompressed, compressed, tt.algorithm); err != nil {
+				t.Fatalf("DecompressStream error: %v", err)
+			}
+			if !bytes.Equal(decompressed.Bytes(), plain) {
+				t.Errorf("DecompressStream content mismatch")
+			}
+		})
+	}
+
+	if err := CompressStream(&bytes.Buffer{}, bytes.NewReader(plain), "lzma", 0); err == nil {
+		t.Errorf("CompressStream with unsupported algorithm got nil error")
+	}
+}
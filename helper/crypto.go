@@ -0,0 +1,121 @@
+package helper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"io"
+	"strings"
+)
+
+// EncryptedPrefix 加密字符串的前缀，v2使用Argon2id派生密钥
+const EncryptedPrefix = "enc:v2:"
+
+const (
+	SaltSize   = 16 // 密钥派生的盐长度
+	aesKeySize = 32 // AES-256
+)
+
+// Argon2id 的参数，即 RFC 9106 推荐的低内存配置
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+)
+
+// DeriveKey 使用Argon2id从口令派生AES-256密钥，salt应为随机生成的SaltSize字节
+func DeriveKey(passphrase []byte, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, argon2Time, argon2Memory, argon2Threads, aesKeySize)
+}
+
+// IsEncrypted 判断字符串是否为EncryptString加密后的内容
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
+// EncryptString 使用口令派生的密钥以AES-256-GCM加密字符串，返回带前缀的base64内容
+func EncryptString(plaintext string, passphrase []byte) (string, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	gcm, err := newGcm(DeriveKey(passphrase, salt))
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	// 输出格式：salt | nonce | ciphertext
+	out := append(append(salt, nonce...), gcm.Seal(nil, nonce, []byte(plaintext), nil)...)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// DecryptString 解密EncryptString加密的字符串
+func DecryptString(value string, passphrase []byte) (string, error) {
+	if !IsEncrypted(value) {
+		return "", errors.New("value is not encrypted")
+	}
+	buf, err := base64.StdEncoding.DecodeString(value[len(EncryptedPrefix):])
+	if err != nil {
+		return "", err
+	}
+	if len(buf) < SaltSize {
+		return "", errors.New("encrypted value is too short")
+	}
+	gcm, err := newGcm(DeriveKey(passphrase, buf[:SaltSize]))
+	if err != nil {
+		return "", err
+	}
+	buf = buf[SaltSize:]
+	if len(buf) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := gcm.Open(nil, buf[:gcm.NonceSize()], buf[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("decrypt failed, the passphrase may be wrong")
+	}
+	return string(plaintext), nil
+}
+
+// WrapKey 使用kek以AES-256-GCM加密数据密钥，输出格式为 nonce | ciphertext
+func WrapKey(kek []byte, key []byte) ([]byte, error) {
+	gcm, err := newGcm(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, key, nil), nil
+}
+
+// UnwrapKey 解密WrapKey加密的数据密钥，kek错误时返回错误
+func UnwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	gcm, err := newGcm(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	key, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("unwrap key failed, the passphrase may be wrong")
+	}
+	return key, nil
+}
+
+// newGcm 使用密钥创建AES-GCM实例
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
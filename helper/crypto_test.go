@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	want := hex.EncodeToString(DeriveKey([]byte("password"), salt))
+	tests := []struct {
+		name       string
+		passphrase string
+		salt       string
+		same       bool
+	}{
+		{"same input", "password", string(salt), true},
+		{"other passphrase", "Password", string(salt), false},
+		{"other salt", "password", "fedcba9876543210", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := DeriveKey([]byte(tt.passphrase), []byte(tt.salt))
+			if len(key) != aesKeySize {
+				t.Errorf("DeriveKey rsp got %d bytes, want %d", len(key), aesKeySize)
+			}
+			if got := hex.EncodeToString(key); (got == want) != tt.same {
+				t.Errorf("DeriveKey rsp got %v, base %v, want same %v", got, want, tt.same)
+			}
+		})
+	}
+}
+
+func TestEncryptString(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{"empty string", ""},
+		{"secret id", "AKIDxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"},
+		{"unicode", "密钥"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := EncryptString(tt.plaintext, passphrase)
+			if err != nil {
+				t.Fatalf("EncryptString error: %v", err)
+			}
+			if !IsEncrypted(enc) || !strings.HasPrefix(enc, EncryptedPrefix) {
+				t.Errorf("IsEncrypted(%v) got false, want true with prefix %v", enc, EncryptedPrefix)
+			}
+			got, err := DecryptString(enc, passphrase)
+			if err != nil {
+				t.Fatalf("DecryptString error: %v", err)
+			}
+			if got != tt.plaintext {
+				t.Errorf("DecryptString rsp got %v, want %v", got, tt.plaintext)
+			}
+			if _, err := DecryptString(enc, []byte("wrong passphrase")); err == nil {
+				t.Errorf("DecryptString with wrong passphrase got nil error")
+			}
+		})
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	kek := DeriveKey([]byte("passphrase"), []byte("0123456789abcdef"))
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, err := WrapKey(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		kek     []byte
+		wrapped []byte
+		wantErr bool
+	}{
+		{"right kek", kek, wrapped, false},
+		{"wrong kek", DeriveKey([]byte("other"), []byte("0123456789abcdef")), wrapped, true},
+		{"tampered", kek, append(append([]byte{}, wrapped[:len(wrapped)-1]...), wrapped[len(wrapped)-1]^1), true},
+		{"too short", kek, wrapped[:4], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnwrapKey(tt.kek, tt.wrapped)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnwrapKey error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != string(key) {
+				t.Errorf("UnwrapKey rsp got %x, want %x", got, key)
+			}
+		})
+	}
+}
+
+func TestDecryptStringInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"not encrypted", "plaintext"},
+		{"bad base64", EncryptedPrefix + "!!!"},
+		{"too short", EncryptedPrefix + "YWJj"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecryptString(tt.value, []byte("key")); err == nil {
+				t.Errorf("DecryptString(%v) got nil error", tt.value)
+			}
+		})
+	}
+}
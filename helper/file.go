@@ -1,8 +1,14 @@
 package helper
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
 )
 
 // Copy copies from src to dest
@@ -22,3 +28,54 @@ func Copy(src string, dest string) error {
 	}
 	return nil
 }
+
+// FileMd5 计算文件内容的md5值
+func FileMd5(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FileSha256 计算文件内容的sha256值
+func FileSha256(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	return Sha256(fd)
+}
+
+// Sha256 计算r中剩余内容的sha256值
+func Sha256(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DetectContentType 获取文件的Content-Type，优先按扩展名判断，无法判断时按文件内容嗅探
+func DetectContentType(path string) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		return contentType, nil
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(fd, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
@@ -0,0 +1,42 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"html by extension", "index.html", "plain", "text/html"},
+		{"css by extension", "app.css", "", "text/css"},
+		{"png by extension", "logo.png", "", "image/png"},
+		{"html by content", "page", "<!DOCTYPE html><html></html>", "text/html"},
+		{"text by content", "README", "hello world", "text/plain"},
+		{"binary by content", "data", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := DetectContentType(path)
+			if err != nil {
+				t.Fatalf("DetectContentType error: %v", err)
+			}
+			// 忽略 ; charset=utf-8 等参数
+			if got = strings.TrimSpace(strings.Split(got, ";")[0]); got != tt.want {
+				t.Errorf("DetectContentType rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package helper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// StreamChunkSize 分块加密时每块明文的大小
+const StreamChunkSize = 64 * 1024
+
+// StreamNonceSize 分块加密的基础nonce长度
+const StreamNonceSize = 12
+
+// StreamOverhead 分块加密时每块额外增加的长度，包括长度前缀和GCM tag
+const StreamOverhead = 4 + 16
+
+// EncryptStream 以AES-256-GCM分块加密src写入dst
+// 每块格式为 4字节密文长度 | 密文，第i块的nonce为基础nonce与i异或，最后一块以附加数据标记，防止截断
+func EncryptStream(dst io.Writer, src io.Reader, key []byte, nonce []byte) error {
+	gcm, err := newStreamGcm(key, nonce)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, StreamChunkSize)
+	next := make([]byte, StreamChunkSize)
+	n, err := io.ReadFull(src, buf)
+	for counter := uint64(0); ; counter++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// 预读下一块以判断当前块是否为最后一块
+		last := err != nil
+		m := 0
+		if !last {
+			m, err = io.ReadFull(src, next)
+			last = m == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+		}
+		sealed := gcm.Seal(nil, chunkNonce(nonce, counter), buf[:n], chunkAad(last))
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+		if _, err := dst.Write(size[:]); err != nil {
+			return err
+		}
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		buf, next = next, buf
+		n = m
+	}
+}
+
+// DecryptStream 解密EncryptStream加密的内容写入dst
+func DecryptStream(dst io.Writer, src io.Reader, key []byte, nonce []byte) error {
+	gcm, err := newStreamGcm(key, nonce)
+	if err != nil {
+		return err
+	}
+	maxSize := uint32(StreamChunkSize + gcm.Overhead())
+	buf := make([]byte, maxSize)
+	var size [4]byte
+	for counter := uint64(0); ; counter++ {
+		if _, err := io.ReadFull(src, size[:]); err != nil {
+			if err == io.EOF {
+				return errors.New("encrypted stream is truncated")
+			}
+			return err
+		}
+		length := binary.BigEndian.Uint32(size[:])
+		if length > maxSize {
+			return errors.New("encrypted chunk is too large")
+		}
+		if _, err := io.ReadFull(src, buf[:length]); err != nil {
+			return err
+		}
+		// 先按非最后一块解密，失败时再按最后一块解密
+		plain, err := gcm.Open(nil, chunkNonce(nonce, counter), buf[:length], chunkAad(false))
+		last := false
+		if err != nil {
+			plain, err = gcm.Open(nil, chunkNonce(nonce, counter), buf[:length], chunkAad(true))
+			if err != nil {
+				return errors.New("decrypt failed, the key may be wrong or the content is damaged")
+			}
+			last = true
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			// 最后一块之后不应再有内容
+			if n, _ := src.Read(size[:1]); n > 0 {
+				return errors.New("unexpected data after the last encrypted chunk")
+			}
+			return nil
+		}
+	}
+}
+
+// newStreamGcm 创建分块加密使用的AES-GCM实例
+func newStreamGcm(key []byte, nonce []byte) (cipher.AEAD, error) {
+	if len(nonce) != StreamNonceSize {
+		return nil, errors.New("invalid nonce size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce 获取第counter块的nonce
+func chunkNonce(nonce []byte, counter uint64) []byte {
+	out := make([]byte, len(nonce))
+	copy(out, nonce)
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], counter)
+	for i := range c {
+		out[len(out)-8+i] ^= c[i]
+	}
+	return out
+}
+
+// chunkAad 获取块的附加数据，标记是否为最后一块
+func chunkAad(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,87 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEncryptStream(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	nonce := bytes.Repeat([]byte{1}, StreamNonceSize)
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"small", 100},
+		{"one chunk", StreamChunkSize},
+		{"one chunk and one byte", StreamChunkSize + 1},
+		{"three chunks", 3 * StreamChunkSize},
+		{"partial last chunk", 2*StreamChunkSize + 123},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := make([]byte, tt.size)
+			_, _ = rand.Read(plain)
+
+			encrypted := &bytes.Buffer{}
+			if err := EncryptStream(encrypted, bytes.NewReader(plain), key, nonce); err != nil {
+				t.Fatalf("EncryptStream error: %v", err)
+			}
+			chunks := tt.size/StreamChunkSize + 1
+			if tt.size > 0 && tt.size%StreamChunkSize == 0 {
+				chunks--
+			}
+			if want := tt.size + chunks*StreamOverhead; encrypted.Len() != want {
+				t.Errorf("EncryptStream size got %v, want %v", encrypted.Len(), want)
+			}
+
+			decrypted := &bytes.Buffer{}
+			if err := DecryptStream(decrypted, bytes.NewReader(encrypted.Bytes()), key, nonce); err != nil {
+				t.Fatalf("DecryptStream error: %v", err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plain) {
+				t.Errorf("DecryptStream content mismatch")
+			}
+		})
+	}
+}
+
+func TestDecryptStreamInvalid(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	nonce := bytes.Repeat([]byte{1}, StreamNonceSize)
+	plain := make([]byte, 2*StreamChunkSize+10)
+	encrypted := &bytes.Buffer{}
+	if err := EncryptStream(encrypted, bytes.NewReader(plain), key, nonce); err != nil {
+		t.Fatalf("EncryptStream error: %v", err)
+	}
+	data := encrypted.Bytes()
+	tampered := append([]byte{}, data...)
+	tampered[10] ^= 0xff
+	chunk := StreamChunkSize + StreamOverhead
+
+	tests := []struct {
+		name  string
+		data  []byte
+		key   []byte
+		nonce []byte
+	}{
+		{"wrong key", data, bytes.Repeat([]byte{8}, 32), nonce},
+		{"wrong nonce", data, key, bytes.Repeat([]byte{2}, StreamNonceSize)},
+		{"tampered", tampered, key, nonce},
+		{"truncated at chunk boundary", data[:chunk], key, nonce},
+		{"truncated inside chunk", data[:chunk+10], key, nonce},
+		{"trailing data", append(append([]byte{}, data...), 0), key, nonce},
+		{"empty", []byte{}, key, nonce},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := DecryptStream(&bytes.Buffer{}, bytes.NewReader(tt.data), tt.key, tt.nonce); err == nil {
+				t.Errorf("DecryptStream got nil error")
+			}
+		})
+	}
+}
@@ -1,8 +1,9 @@
 package helper
 
 import (
+	"fmt"
 	"math"
-	"strconv"
+	"regexp"
 	"strings"
 )
 
@@ -23,38 +24,206 @@ func HideSecret(secret string, count uint32) string {
 	return secret[0:int(prefix)] + mask + secret[length-int(suffix):]
 }
 
-// CompareVersion 版本号对比
+// CompareVersion 版本号对比，按 SemVer 2.0 的规则比较，可以带v前缀
 // 两版本相同时返回0，
 // version1 > version2时返回1，
 // version1 < version2时返回-1
+// 主版本部分按数字逐段比较，缺少的段视为0，兼容 1.0.2.1 这样多于三段的版本号；
+// 带有预发布标识的版本低于对应的正式版本，如 1.1.0-rc.1 < 1.1.0；+ 之后的构建信息不参与比较
+// 不是有效版本号的（如 nightly、v1.x.0）低于任何有效版本号，两个都无效时按字符串比较
 func CompareVersion(version1, version2 string) int {
-	// 将版本号按"."分割
-	v1 := strings.Split(strings.TrimLeft(strings.ToLower(version1), "v"), ".")
-	v2 := strings.Split(strings.TrimLeft(strings.ToLower(version2), "v"), ".")
-	// 判断版本号的长度
-	len1, len2 := len(v1), len(v2)
-	vlen := len1
-	if len1 < len2 {
-		vlen = len1
-	} else {
-		vlen = len2
-	}
-	// 遍历分割后的版本号字符串，从左到右依次比较
-	for i := 0; i < vlen; i++ {
-		// 将字符串转换为整数，再比较大小
-		num1, _ := strconv.Atoi(v1[i])
-		num2, _ := strconv.Atoi(v2[i])
-		if num1 > num2 {
-			return 1
-		} else if num1 < num2 {
+	valid1, valid2 := IsVersion(version1), IsVersion(version2)
+	if !valid1 || !valid2 {
+		if valid1 != valid2 {
+			if valid1 {
+				return 1
+			}
 			return -1
 		}
+		return strings.Compare(version1, version2)
 	}
-	// 如果仍相等，再判断长度
-	if len1 > len2 {
+	core1, pre1 := splitVersion(version1)
+	core2, pre2 := splitVersion(version2)
+
+	// 遍历分割后的主版本号，从左到右依次比较
+	for i := 0; i < len(core1) || i < len(core2); i++ {
+		id1, id2 := "0", "0"
+		if i < len(core1) {
+			id1 = core1[i]
+		}
+		if i < len(core2) {
+			id2 = core2[i]
+		}
+		if r := compareIdentifier(id1, id2); r != 0 {
+			return r
+		}
+	}
+
+	// 主版本相同时，正式版本高于预发布版本
+	if len(pre1) == 0 || len(pre2) == 0 {
+		return compareInt(len(pre2), len(pre1))
+	}
+	for i := 0; i < len(pre1) && i < len(pre2); i++ {
+		if r := compareIdentifier(pre1[i], pre2[i]); r != 0 {
+			return r
+		}
+	}
+	// 前面的标识都相同时，标识多的版本更高
+	return compareInt(len(pre1), len(pre2))
+}
+
+// IsVersion 判断是否为有效的版本号，主版本部分每段都必须是数字，预发布标识不能为空
+func IsVersion(version string) bool {
+	core, pre := splitVersion(version)
+	for _, id := range core {
+		if !isNumeric(id) {
+			return false
+		}
+	}
+	for _, id := range pre {
+		if id == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPrerelease 判断版本号是否为预发布版本，如 v1.1.0-rc.1
+func IsPrerelease(version string) bool {
+	_, pre := splitVersion(version)
+	return len(pre) > 0
+}
+
+// splitVersion 拆分版本号，返回主版本号和预发布标识，去掉v前缀和构建信息
+func splitVersion(version string) ([]string, []string) {
+	version = strings.TrimSpace(version)
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+	if i := strings.Index(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+	var pre []string
+	if i := strings.Index(version, "-"); i >= 0 {
+		pre = strings.Split(version[i+1:], ".")
+		version = version[:i]
+	}
+	return strings.Split(version, "."), pre
+}
+
+// compareIdentifier 比较版本号中的一段，纯数字按数值比较且低于非数字，非数字按ASCII顺序比较
+// 非数字只会出现在预发布标识中，主版本部分已由IsVersion保证为数字
+func compareIdentifier(id1, id2 string) int {
+	num1, num2 := isNumeric(id1), isNumeric(id2)
+	switch {
+	case num1 && num2:
+		// 按字符串比较数值，避免超长的数字溢出
+		id1, id2 = strings.TrimLeft(id1, "0"), strings.TrimLeft(id2, "0")
+		if r := compareInt(len(id1), len(id2)); r != 0 {
+			return r
+		}
+		return strings.Compare(id1, id2)
+	case num1:
+		return -1
+	case num2:
 		return 1
-	} else if len1 < len2 {
+	default:
+		return strings.Compare(id1, id2)
+	}
+}
+
+// isNumeric 判断字符串是否为纯数字
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compareInt 比较两个整数
+func compareInt(a, b int) int {
+	if a > b {
+		return 1
+	} else if a < b {
 		return -1
 	}
 	return 0
 }
+
+// FormatBytes 把字节数格式化为易读的大小，如 1.5 KiB
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// ExpandEnv 把字符串中的 ${NAME} 替换为环境变量的值，支持 ${NAME:-default} 形式的默认值
+// 与os.ExpandEnv不同，不处理不带花括号的 $NAME，避免误替换密钥等内容中的$字符
+func ExpandEnv(s string, lookup func(string) (string, bool)) string {
+	return envPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := envPattern.FindStringSubmatch(m)
+		if v, ok := lookup(sub[1]); ok && v != "" {
+			return v
+		}
+		return sub[3]
+	})
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?}`)
+
+// MatchPattern 判断相对路径是否匹配通配符，路径分隔符为 /
+// 支持 * ? ** 及 {a,b} 形式的通配，pattern 不含 / 时只匹配文件名
+func MatchPattern(pattern string, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = name[strings.LastIndex(name, "/")+1:]
+	}
+	re, err := regexp.Compile(globToRegexp(strings.TrimLeft(pattern, "/")))
+	if err != nil {
+		return false
+	}
+	return re.MatchString(strings.TrimLeft(name, "/"))
+}
+
+// globToRegexp 把通配符转换为正则表达式
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	inBrace := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '{':
+			inBrace = true
+			sb.WriteString("(")
+		case c == '}' && inBrace:
+			inBrace = false
+			sb.WriteString(")")
+		case c == ',' && inBrace:
+			sb.WriteString("|")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
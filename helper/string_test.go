@@ -173,3 +173,176 @@ func TestCompareVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestIsVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    bool
+	}{
+		{"release", "v1.0.3", true},
+		{"without prefix", "1.0", true},
+		{"prerelease with build", "v1.1.0-rc.1+abc", true},
+		{"four segments", "v1.0.2.1", true},
+		{"tag name", "nightly", false},
+		{"non numeric core", "v1.x.0", false},
+		{"empty", "", false},
+		{"empty prerelease identifier", "v1.0.0-rc..1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsVersion(tt.version); got != tt.want {
+				t.Errorf("IsVersion rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersionSemver(t *testing.T) {
+	tests := []struct {
+		name     string
+		version1 string
+		version2 string
+		want     int
+	}{
+		{"rc lower than release", "v1.1.0-rc1", "v1.1.0", -1},
+		{"release higher than rc", "v1.1.0", "v1.1.0-rc.1", 1},
+		{"rc higher than previous release", "v1.1.0-rc.1", "v1.0.9", 1},
+		{"alpha < alpha.1", "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"alpha.1 < alpha.beta", "1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"alpha.beta < beta", "1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"beta < beta.2", "1.0.0-beta", "1.0.0-beta.2", -1},
+		{"beta.2 < beta.11", "1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"beta.11 < rc.1", "1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"rc.1 < release", "1.0.0-rc.1", "1.0.0", -1},
+		{"rc.2 > rc.1", "v1.1.0-rc.2", "v1.1.0-rc.1", 1},
+		{"build metadata ignored", "v1.0.0+20240301", "v1.0.0+build.2", 0},
+		{"build metadata with rc", "v1.0.0-rc.1+abc", "v1.0.0-rc.1", 0},
+		{"missing patch equals zero", "v1.0", "v1.0.0", 0},
+		{"non numeric core is lowest", "v1.x.0", "v0.0.1", -1},
+		{"tag name is lowest", "nightly", "v0.0.1-alpha", -1},
+		{"empty core segment", "v1..0", "v0.1.0", -1},
+		{"empty prerelease", "v1.0.0-", "v0.1.0", -1},
+		{"both invalid", "latest", "nightly", -1},
+		{"large number", "v1.0.99999999999999999999", "v1.0.9", 1},
+		{"leading zeros", "v1.01.0", "v1.1.0", 0},
+		{"upper case prefix", "V1.2.0", "v1.2.0", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rsp := CompareVersion(tt.version1, tt.version2); rsp != tt.want {
+				t.Errorf("CompareVersion rsp got %v, want %v", rsp, tt.want)
+			}
+			// 交换参数后结果相反
+			if rsp := CompareVersion(tt.version2, tt.version1); rsp != -tt.want {
+				t.Errorf("CompareVersion reversed rsp got %v, want %v", rsp, -tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPrerelease(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"v1.0.0", false},
+		{"v1.1.0-rc.1", true},
+		{"1.0.0-alpha", true},
+		{"v1.0.0+build-1", false},
+		{"v1.0.0-beta+build-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := IsPrerelease(tt.version); got != tt.want {
+				t.Errorf("IsPrerelease rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want string
+	}{
+		{"zero", 0, "0 B"},
+		{"bytes", 1023, "1023 B"},
+		{"one KiB", 1024, "1.0 KiB"},
+		{"one and half KiB", 1536, "1.5 KiB"},
+		{"MiB", 5 * 1024 * 1024, "5.0 MiB"},
+		{"GiB", 3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatBytes(tt.size); got != tt.want {
+				t.Errorf("FormatBytes rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"SECRET_ID": "AKID123", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"no variable", "secret_id: abc", "secret_id: abc"},
+		{"replace variable", "secret_id: ${SECRET_ID}", "secret_id: AKID123"},
+		{"missing variable", "secret_id: ${MISSING}", "secret_id: "},
+		{"default value", "region: ${MISSING:-ap-guangzhou}", "region: ap-guangzhou"},
+		{"empty uses default", "region: ${EMPTY:-ap-guangzhou}", "region: ap-guangzhou"},
+		{"set ignores default", "id: ${SECRET_ID:-none}", "id: AKID123"},
+		{"dollar without brace", "key: a$SECRET_ID", "key: a$SECRET_ID"},
+		{"multiple variables", "${SECRET_ID}/${SECRET_ID}", "AKID123/AKID123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpandEnv(tt.source, lookup); got != tt.want {
+				t.Errorf("ExpandEnv rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"extension", "*.html", "index.html", true},
+		{"extension in sub dir", "*.html", "docs/guide/index.html", true},
+		{"extension not matched", "*.html", "index.htm", false},
+		{"alternatives", "*.{css,js}", "assets/app.js", true},
+		{"alternatives not matched", "*.{css,js}", "assets/app.json", false},
+		{"question mark", "v?.txt", "v1.txt", true},
+		{"single level", "assets/*", "assets/app.js", true},
+		{"single level not nested", "assets/*", "assets/img/logo.png", false},
+		{"double star", "assets/**", "assets/img/logo.png", true},
+		{"double star middle", "archive/**/*.log", "archive/2023/03/app.log", true},
+		{"double star zero dir", "archive/**/*.log", "archive/app.log", true},
+		{"leading slash", "/assets/*", "assets/app.js", true},
+		{"dot is literal", "*.html", "indexxhtml", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchPattern(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("MatchPattern rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
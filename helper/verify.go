@@ -0,0 +1,49 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseChecksums 解析 sha256sum 格式的校验文件，每行为 "<hex>  <文件名>"，返回文件名到校验值的映射
+// 文件名前的 * 表示二进制模式，解析时去掉
+func ParseChecksums(content []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != 64 {
+			return nil, errors.New(fmt.Sprintf("checksums line %d is invalid", n))
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// VerifySignature 使用ed25519公钥校验签名，公钥和签名均为base64编码
+func VerifySignature(publicKey string, message []byte, signature string) error {
+	pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("public key is invalid, it should be a base64 encoded ed25519 public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("signature is invalid, it should be a base64 encoded ed25519 signature")
+	}
+	if !ed25519.Verify(pub, message, sig) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksums(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"text mode", sum + "  osd-tool_linux_amd64.tgz\n", map[string]string{"osd-tool_linux_amd64.tgz": sum}, false},
+		{"binary mode", sum + " *osd-tool_linux_amd64.tgz\n", map[string]string{"osd-tool_linux_amd64.tgz": sum}, false},
+		{"upper case", strings.ToUpper(sum) + "  a.tgz\n\n# comment\n", map[string]string{"a.tgz": sum}, false},
+		{"multiple", sum + "  a.tgz\n" + sum + "  b.zip", map[string]string{"a.tgz": sum, "b.zip": sum}, false},
+		{"short checksum", "abcd  a.tgz", nil, true},
+		{"missing name", sum, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChecksums([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChecksums error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseChecksums rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	message := []byte("checksums")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, message))
+	key := base64.StdEncoding.EncodeToString(pub)
+
+	tests := []struct {
+		name      string
+		key       string
+		message   []byte
+		signature string
+		wantErr   bool
+	}{
+		{"valid", key, message, sig, false},
+		{"valid with newline", key + "\n", message, sig + "\n", false},
+		{"tampered message", key, []byte("checksums!"), sig, true},
+		{"other key", base64.StdEncoding.EncodeToString(otherPub), message, sig, true},
+		{"invalid key", "not-base64", message, sig, true},
+		{"invalid signature", key, message, "c2hvcnQ=", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifySignature(tt.key, tt.message, tt.signature); (err != nil) != tt.wantErr {
+				t.Errorf("VerifySignature rsp got %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/provider"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 初始化时可选的密钥来源
+const (
+	CredentialsConfig  = "config"  // 写入配置文件
+	CredentialsEnv     = "env"     // 环境变量
+	CredentialsFile    = "file"    // 凭证文件
+	CredentialsProcess = "process" // 外部命令
+)
+
+// credentialsLabels 密钥来源的说明
+var credentialsLabels = map[string]string{
+	CredentialsConfig:  "写入配置文件",
+	CredentialsEnv:     "从环境变量获取",
+	CredentialsFile:    "写入凭证文件 ~/.osd-tool/credentials",
+	CredentialsProcess: "通过外部命令获取临时密钥",
+}
+
+// storageRegions 各存储类型的常用地域
+var storageRegions = map[string][]string{
+	provider.COS: {
+		"ap-beijing", "ap-shanghai", "ap-guangzhou", "ap-chengdu", "ap-chongqing", "ap-nanjing", "ap-hongkong",
+		"ap-singapore", "ap-tokyo", "ap-seoul", "ap-bangkok", "na-siliconvalley", "na-ashburn", "eu-frankfurt",
+	},
+	provider.OSS: {
+		"cn-hangzhou", "cn-shanghai", "cn-beijing", "cn-shenzhen", "cn-qingdao", "cn-zhangjiakou", "cn-chengdu",
+		"cn-hongkong", "ap-southeast-1", "ap-northeast-1", "us-west-1", "us-east-1", "eu-central-1",
+	},
+}
+
+// InitOptions 初始化配置文件的选项，交互模式下作为各问题的默认值
+type InitOptions struct {
+	Storage           string
+	Bucket            string
+	Region            string
+	Endpoint          string
+	Credentials       string // 密钥来源，取值为 config、env、file、process
+	SecretId          string
+	SecretKey         string
+	CredentialProcess string
+	Uploads           []config.Path
+	Downloads         []config.Path
+	Test              bool // 是否测试连接
+}
+
+// errInputClosed 交互输入已结束
+var errInputClosed = errors.New("input is closed before the wizard is finished")
+
+// prompter 终端交互，逐行读取输入
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	eof bool
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out}
+}
+
+// ask 提问并读取一行输入，输入为空时使用默认值
+func (p *prompter) ask(question string, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil {
+		p.eof = true
+		fmt.Fprintln(p.out)
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+// require 提问直到输入不为空
+func (p *prompter) require(question string, def string) (string, error) {
+	for {
+		if value := p.ask(question, def); value != "" {
+			return value, nil
+		}
+		if p.eof {
+			return "", errInputClosed
+		}
+		fmt.Fprintln(p.out, "不能为空，请重新输入")
+	}
+}
+
+// choose 列出选项并读取选择，可以输入序号或直接输入取值，labels为选项的说明
+func (p *prompter) choose(question string, options []string, labels map[string]string, def string) string {
+	fmt.Fprintln(p.out, question+":")
+	for i, option := range options {
+		if label, ok := labels[option]; ok {
+			fmt.Fprintf(p.out, "  %d) %s - %s\n", i+1, option, label)
+		} else {
+			fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+		}
+	}
+	value := p.ask("请输入序号或取值", def)
+	if n, err := strconv.Atoi(value); err == nil && n >= 1 && n <= len(options) {
+		return options[n-1]
+	}
+	return value
+}
+
+// confirm 提问是否确认
+func (p *prompter) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	value := strings.ToLower(p.ask(fmt.Sprintf("%s (%s)", question, hint), ""))
+	if value == "" {
+		return def
+	}
+	return value == "y" || value == "yes"
+}
+
+// secret 读取密钥，终端中输入时不回显
+func (p *prompter) secret(question string, def string) string {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return p.ask(question, def)
+	}
+	fmt.Fprintf(p.out, "%s: ", question)
+	buf, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(p.out)
+	if err != nil || len(buf) == 0 {
+		return def
+	}
+	return strings.TrimSpace(string(buf))
+}
+
+// runInitWizard 交互式获取初始化选项，opt中已有的值作为默认值
+func runInitWizard(p *prompter, opt *InitOptions) error {
+	var err error
+	storage := opt.Storage
+	if storage == "" {
+		storage = provider.COS
+	}
+	opt.Storage = strings.ToLower(p.choose("选择存储类型", []string{provider.COS, provider.OSS},
+		map[string]string{provider.COS: "腾讯云COS", provider.OSS: "阿里云OSS"}, storage))
+	if _, ok := storageRegions[opt.Storage]; !ok {
+		return errors.New(fmt.Sprintf("storage '%s' is not supported, use cos or oss", opt.Storage))
+	}
+	if opt.Bucket, err = p.require("存储桶名称", opt.Bucket); err != nil {
+		return err
+	}
+	if opt.Region = p.choose("选择存储桶的地域，也可以直接输入列表以外的地域", storageRegions[opt.Storage],
+		nil, opt.Region); opt.Region == "" {
+		if opt.Region, err = p.require("地域", ""); err != nil {
+			return err
+		}
+	}
+	opt.Endpoint = p.ask("自定义访问域名，不填时使用默认域名", opt.Endpoint)
+
+	credentials := opt.Credentials
+	if credentials == "" {
+		credentials = CredentialsConfig
+	}
+	opt.Credentials = p.choose("选择密钥来源",
+		[]string{CredentialsConfig, CredentialsEnv, CredentialsFile, CredentialsProcess}, credentialsLabels, credentials)
+	switch opt.Credentials {
+	case CredentialsConfig, CredentialsFile:
+		if opt.SecretId, err = p.require("secret_id", opt.SecretId); err != nil {
+			return err
+		}
+		for opt.SecretKey = p.secret("secret_key", opt.SecretKey); opt.SecretKey == ""; {
+			if p.eof {
+				return errInputClosed
+			}
+			fmt.Fprintln(p.out, "不能为空，请重新输入")
+			opt.SecretKey = p.secret("secret_key", "")
+		}
+	case CredentialsProcess:
+		if opt.CredentialProcess, err = p.require("获取密钥的命令", opt.CredentialProcess); err != nil {
+			return err
+		}
+	case CredentialsEnv:
+		fmt.Fprintln(p.out, "执行时请设置环境变量 OSD_SECRET_ID、OSD_SECRET_KEY")
+	default:
+		return errors.New(fmt.Sprintf("credentials source '%s' is not supported", opt.Credentials))
+	}
+
+	for !p.eof && p.confirm("添加上传目录", len(opt.Uploads) == 0) {
+		source, err := p.require("  本地目录", "")
+		if err != nil {
+			return err
+		}
+		opt.Uploads = append(opt.Uploads, config.Path{Source: source, Dest: p.ask("  对象存储中的路径", "/")})
+	}
+	for !p.eof && p.confirm("添加下载目录", false) {
+		source, err := p.require("  对象存储中的路径", "")
+		if err != nil {
+			return err
+		}
+		dest, err := p.require("  本地目录", "")
+		if err != nil {
+			return err
+		}
+		opt.Downloads = append(opt.Downloads, config.Path{Source: source, Dest: dest})
+	}
+	opt.Test = p.confirm("是否测试连接", true)
+	return nil
+}
+
+// validateInitOptions 检查初始化选项的存储类型、存储桶、地域及密钥来源，交互与非交互模式共用
+func validateInitOptions(opt *InitOptions) error {
+	if opt.Storage == "" {
+		return errors.New("storage is required, use cos or oss")
+	}
+	if _, ok := storageRegions[strings.ToLower(opt.Storage)]; !ok {
+		return errors.New(fmt.Sprintf("storage '%s' is not supported, use cos or oss", opt.Storage))
+	}
+	if opt.Bucket == "" {
+		return errors.New("bucket is required")
+	}
+	if opt.Region == "" && opt.Endpoint == "" {
+		return errors.New("region is required when endpoint is not set")
+	}
+	switch opt.Credentials {
+	case CredentialsConfig, CredentialsFile, "":
+		if opt.SecretId == "" || opt.SecretKey == "" {
+			return errors.New("secret_id and secret_key are required when credentials source is config or file")
+		}
+	case CredentialsProcess:
+		if opt.CredentialProcess == "" {
+			return errors.New("credential_process is required when credentials source is process")
+		}
+	case CredentialsEnv:
+	default:
+		return errors.New(fmt.Sprintf("credentials source '%s' is not supported, use config, env, file or process",
+			opt.Credentials))
+	}
+	return nil
+}
+
+// buildInitConfig 检查初始化选项并生成配置
+func buildInitConfig(opt *InitOptions) (*config.TransferConfig, error) {
+	if err := validateInitOptions(opt); err != nil {
+		return nil, err
+	}
+	cfg := &config.TransferConfig{Storage: strings.ToLower(opt.Storage)}
+	cfg.Osd = config.Osd{Bucket: opt.Bucket, Region: opt.Region, Endpoint: opt.Endpoint, Timeout: 300}
+	switch opt.Credentials {
+	case CredentialsConfig, "":
+		cfg.Osd.SecretId, cfg.Osd.SecretKey = opt.SecretId, opt.SecretKey
+	case CredentialsProcess:
+		cfg.Osd.CredentialProcess = opt.CredentialProcess
+	}
+	cfg.Upload.Ignore = []string{".git", ".idea", ".DS_Store"}
+	cfg.Upload.List = opt.Uploads
+	if len(cfg.Upload.List) == 0 {
+		cfg.Upload.List = []config.Path{{}}
+	}
+	cfg.Download.List = opt.Downloads
+	if len(cfg.Download.List) == 0 {
+		cfg.Download.List = []config.Path{{}}
+	}
+	return cfg, nil
+}
+
+// testConnectivity 通过列出存储桶中的对象测试存储配置能否正常访问，
+// 存储桶不存在、无权限等错误均视为失败，错误信息中附带可能的原因
+func testConnectivity(cfg *config.TransferConfig) error {
+	// 在副本上获取环境变量、凭证文件中的密钥，避免写入配置文件
+	resolved := *cfg
+	if err := resolved.ResolveCredentials(); err != nil {
+		return err
+	}
+	profile, err := resolved.GetProfile("")
+	if err != nil {
+		return err
+	}
+	p, err := newProvider(profile)
+	if err != nil {
+		return err
+	}
+	if _, err := p.ListPage("", 1); err != nil {
+		if hint := (&doctor{profile: profile}).hint(err); hint != "" {
+			return errors.New(fmt.Sprintf("%s, %s", err.Error(), hint))
+		}
+		return err
+	}
+	return nil
+}
+
+// parsePathPairs 解析 source=dest 形式的目录映射
+func parsePathPairs(values []string) ([]config.Path, error) {
+	var list []config.Path
+	for _, value := range values {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, errors.New(fmt.Sprintf("path '%s' is invalid, use source=dest", value))
+		}
+		list = append(list, config.Path{Source: pair[0], Dest: pair[1]})
+	}
+	return list, nil
+}
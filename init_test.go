@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/jorben/osd-tool/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunInitWizard(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    InitOptions
+		wantErr string
+	}{
+		{
+			"config credentials with upload",
+			"1\nbucket-1250000000\n1\n\n\nid\nkey\ny\n/data\n/backup\nn\n\nn\n",
+			InitOptions{Storage: "cos", Bucket: "bucket-1250000000", Region: "ap-beijing",
+				Credentials: CredentialsConfig, SecretId: "id", SecretKey: "key",
+				Uploads: []config.Path{{Source: "/data", Dest: "/backup"}}},
+			"",
+		},
+		{
+			"empty secret key asked again",
+			"oss\nbucket\ncn-test\noss.example.com\nfile\nid\n\nkey\nn\n\n\n",
+			InitOptions{Storage: "oss", Bucket: "bucket", Region: "cn-test", Endpoint: "oss.example.com",
+				Credentials: CredentialsFile, SecretId: "id", SecretKey: "key", Test: true},
+			"",
+		},
+		{
+			"process credentials",
+			"2\nbucket\n1\n\n4\n/usr/bin/get-key\nn\n\nn\n",
+			InitOptions{Storage: "oss", Bucket: "bucket", Region: "cn-hangzhou",
+				Credentials: CredentialsProcess, CredentialProcess: "/usr/bin/get-key"},
+			"",
+		},
+		{"unsupported storage", "s3\n", InitOptions{}, "storage 's3' is not supported"},
+		{"unsupported credentials", "1\nbucket\n1\n\nvault\n", InitOptions{}, "credentials source 'vault'"},
+		{"input closed", "1\n", InitOptions{}, errInputClosed.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := &InitOptions{}
+			err := runInitWizard(newPrompter(strings.NewReader(tt.input), io.Discard), opt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("runInitWizard rsp got %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*opt, tt.want) {
+				t.Errorf("runInitWizard rsp got %+v, want %+v", *opt, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildInitConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     InitOptions
+		wantErr string
+	}{
+		{"no flags", InitOptions{}, "storage is required"},
+		{"unsupported storage", InitOptions{Storage: "s3"}, "storage 's3' is not supported"},
+		{"missing bucket", InitOptions{Storage: "cos"}, "bucket is required"},
+		{"missing region", InitOptions{Storage: "cos", Bucket: "b"}, "region is required"},
+		{"endpoint without region", InitOptions{Storage: "cos", Bucket: "b", Endpoint: "cos.example.com",
+			Credentials: CredentialsEnv}, ""},
+		{"unknown credentials", InitOptions{Storage: "cos", Bucket: "b", Region: "r", Credentials: "vault"},
+			"credentials source 'vault' is not supported"},
+		{"missing secret", InitOptions{Storage: "cos", Bucket: "b", Region: "r", SecretId: "id"},
+			"secret_id and secret_key are required"},
+		{"missing secret in file", InitOptions{Storage: "cos", Bucket: "b", Region: "r", Credentials: CredentialsFile},
+			"secret_id and secret_key are required"},
+		{"missing process", InitOptions{Storage: "oss", Bucket: "b", Region: "r", Credentials: CredentialsProcess},
+			"credential_process is required"},
+		{"config credentials", InitOptions{Storage: "COS", Bucket: "b", Region: "r", SecretId: "id", SecretKey: "key"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := buildInitConfig(&tt.opt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("buildInitConfig rsp got %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Storage != strings.ToLower(tt.opt.Storage) || cfg.Osd.Bucket != tt.opt.Bucket ||
+				cfg.Osd.SecretKey != tt.opt.SecretKey {
+				t.Errorf("buildInitConfig rsp got %+v, want options %+v", cfg, tt.opt)
+			}
+		})
+	}
+	// 从环境变量、凭证文件获取密钥时不写入配置文件
+	cfg, err := buildInitConfig(&InitOptions{Storage: "cos", Bucket: "b", Region: "r", Credentials: CredentialsFile,
+		SecretId: "id", SecretKey: "key"})
+	if err != nil || cfg.Osd.SecretId != "" || cfg.Osd.SecretKey != "" {
+		t.Errorf("buildInitConfig rsp got %v %v, want no secret in config", cfg, err)
+	}
+}
+
+func TestTestConnectivity(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"bucket listed", http.StatusOK, "<ListBucketResult><Name>test-1250000000</Name></ListBucketResult>", ""},
+		{"no such bucket", http.StatusNotFound, "<Error><Code>NoSuchBucket</Code></Error>", "存储桶不存在"},
+		{"access denied", http.StatusForbidden, "<Error><Code>AccessDenied</Code></Error>", "没有该操作的权限"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer ts.Close()
+			cfg := &config.TransferConfig{Storage: "cos", Osd: config.Osd{SecretId: "id", SecretKey: "key",
+				Bucket: "test-1250000000", Endpoint: ts.URL, Timeout: 10}}
+			err := testConnectivity(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("testConnectivity error got %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("testConnectivity error got %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
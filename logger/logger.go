@@ -0,0 +1,320 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Level 日志级别，取值与 log/slog 一致
+type Level int
+
+// 支持的日志级别
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// String 获取日志级别的名称
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLevel 解析日志级别名称，不区分大小写，支持 debug、info、warn、error
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, errors.New(fmt.Sprintf("log level '%s' is not supported, use debug, info, warn or error", s))
+	}
+}
+
+// 支持的日志格式
+const (
+	FormatText = "text" // key=value 格式，同 slog.TextHandler
+	FormatJson = "json" // 每行一个JSON对象，同 slog.JSONHandler
+)
+
+// badKey 参数个数不成对时缺少key的值使用的key，同 slog
+const badKey = "!BADKEY"
+
+// output 日志输出，同一个Logger派生出的Logger共用
+type output struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+}
+
+// Logger 结构化日志，每条日志包含时间、级别、消息及 key、value 成对的属性
+type Logger struct {
+	out   *output
+	attrs []interface{}
+}
+
+// New 获取Logger实例，format为空时使用text格式
+func New(w io.Writer, level Level, format string) (*Logger, error) {
+	switch format {
+	case "", FormatText, FormatJson:
+	default:
+		return nil, errors.New(fmt.Sprintf("log format '%s' is not supported, use %s or %s", format, FormatText, FormatJson))
+	}
+	return &Logger{out: &output{w: w, level: level, json: format == FormatJson}}, nil
+}
+
+// With 获取附加了属性的Logger，之后的每条日志都带有这些属性
+func (l *Logger) With(args ...interface{}) *Logger {
+	attrs := make([]interface{}, 0, len(l.attrs)+len(args))
+	attrs = append(append(attrs, l.attrs...), args...)
+	return &Logger{out: l.out, attrs: attrs}
+}
+
+// Output 获取日志的输出
+func (l *Logger) Output() io.Writer {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return l.out.w
+}
+
+// SetOutput 设置日志的输出，同一个Logger派生出的Logger同时生效
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w = w
+}
+
+// Enabled 判断指定级别的日志是否会输出
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+// Debug 输出调试日志
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args)
+}
+
+// Info 输出一般日志
+func (l *Logger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args)
+}
+
+// Warn 输出警告日志
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args)
+}
+
+// Error 输出错误日志
+func (l *Logger) Error(msg string, args ...interface{}) {
+	l.log(LevelError, msg, args)
+}
+
+// log 格式化并输出一条日志，args为 key、value 成对的属性
+func (l *Logger) log(level Level, msg string, args []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	var buf bytes.Buffer
+	now := time.Now()
+	if l.out.json {
+		buf.WriteByte('{')
+		writeJson(&buf, "time", now.Format(time.RFC3339Nano))
+		buf.WriteByte(',')
+		writeJson(&buf, "level", level.String())
+		buf.WriteByte(',')
+		writeJson(&buf, "msg", msg)
+		eachAttr(l.attrs, args, func(key string, value interface{}) {
+			buf.WriteByte(',')
+			writeJson(&buf, key, value)
+		})
+		buf.WriteString("}\n")
+	} else {
+		writeText(&buf, "time", now.Format(time.RFC3339Nano))
+		buf.WriteByte(' ')
+		writeText(&buf, "level", level.String())
+		buf.WriteByte(' ')
+		writeText(&buf, "msg", msg)
+		eachAttr(l.attrs, args, func(key string, value interface{}) {
+			buf.WriteByte(' ')
+			writeText(&buf, key, value)
+		})
+		buf.WriteByte('\n')
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(buf.Bytes())
+}
+
+// eachAttr 依次回调 key、value 成对的属性，key不是字符串时作为值处理，同 slog
+func eachAttr(attrs []interface{}, args []interface{}, fn func(key string, value interface{})) {
+	for _, list := range [][]interface{}{attrs, args} {
+		for i := 0; i < len(list); i++ {
+			key, ok := list[i].(string)
+			if !ok || i+1 >= len(list) {
+				fn(badKey, list[i])
+				continue
+			}
+			fn(key, list[i+1])
+			i++
+		}
+	}
+}
+
+// stringValue 获取属性值的字符串形式
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeText 按 key=value 格式输出属性，包含空格、引号、等号等字符时加引号
+func writeText(buf *bytes.Buffer, key string, value interface{}) {
+	buf.WriteString(quoteText(key))
+	buf.WriteByte('=')
+	buf.WriteString(quoteText(stringValue(value)))
+}
+
+// quoteText 字符串为空或包含需要转义的字符时加引号
+func quoteText(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// writeJson 按JSON格式输出属性，数字和布尔值保持原类型，时长等其他类型输出为字符串
+func writeJson(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	switch value.(type) {
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		if v, err := json.Marshal(value); err == nil {
+			buf.Write(v)
+			return
+		}
+	}
+	v, _ := json.Marshal(stringValue(value))
+	buf.Write(v)
+}
+
+// std 默认的Logger，未设置时输出到标准错误，级别为info
+var std atomic.Value
+
+func init() {
+	l, _ := New(os.Stderr, LevelInfo, FormatText)
+	std.Store(l)
+}
+
+// Default 获取默认的Logger
+func Default() *Logger {
+	return std.Load().(*Logger)
+}
+
+// SetDefault 设置默认的Logger
+func SetDefault(l *Logger) {
+	std.Store(l)
+}
+
+// Debug 使用默认Logger输出调试日志
+func Debug(msg string, args ...interface{}) {
+	Default().log(LevelDebug, msg, args)
+}
+
+// Info 使用默认Logger输出一般日志
+func Info(msg string, args ...interface{}) {
+	Default().log(LevelInfo, msg, args)
+}
+
+// Warn 使用默认Logger输出警告日志
+func Warn(msg string, args ...interface{}) {
+	Default().log(LevelWarn, msg, args)
+}
+
+// Error 使用默认Logger输出错误日志
+func Error(msg string, args ...interface{}) {
+	Default().log(LevelError, msg, args)
+}
+
+// Options 日志选项
+type Options struct {
+	Level      string // 日志级别，为空时为info
+	Format     string // 日志格式，text 或 json，为空时为text
+	File       string // 日志文件路径，为空时输出到标准错误
+	MaxSize    int64  // 单个日志文件的最大字节数，超过后轮转，为0时不轮转
+	MaxBackups int    // 轮转后保留的历史日志文件数
+}
+
+// Setup 按选项创建Logger并设置为默认Logger，返回的Closer用于关闭日志文件
+func Setup(opt Options) (io.Closer, error) {
+	level, err := ParseLevel(opt.Level)
+	if err != nil {
+		return nil, err
+	}
+	var w io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+	if opt.File != "" {
+		rw, err := NewRotateWriter(opt.File, opt.MaxSize, opt.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = rw, rw
+	}
+	l, err := New(w, level, opt.Format)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+	SetDefault(l)
+	return closer, nil
+}
+
+// nopCloser 输出到标准错误时无需关闭
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
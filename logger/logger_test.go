@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   string
+		want    Level
+		wantErr bool
+	}{
+		{"empty", "", LevelInfo, false},
+		{"debug", "debug", LevelDebug, false},
+		{"upper case", "WARN", LevelWarn, false},
+		{"warning", "warning", LevelWarn, false},
+		{"error", "error", LevelError, false},
+		{"unknown", "trace", LevelInfo, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// timePattern 日志中的时间字段，比较时替换掉
+var timePattern = regexp.MustCompile(`time=\S+ |"time":"[^"]+",`)
+
+func TestLoggerText(t *testing.T) {
+	tests := []struct {
+		name  string
+		level Level
+		log   func(l *Logger)
+		want  string
+	}{
+		{
+			"attrs",
+			LevelInfo,
+			func(l *Logger) { l.Info("upload success", "key", "a/b.txt", "size", 10) },
+			"level=INFO msg=\"upload success\" key=a/b.txt size=10\n",
+		},
+		{
+			"quote value",
+			LevelInfo,
+			func(l *Logger) { l.Error("put error", "file", "my file.txt", "error", errors.New(`bad "key"`)) },
+			"level=ERROR msg=\"put error\" file=\"my file.txt\" error=\"bad \\\"key\\\"\"\n",
+		},
+		{
+			"empty and duration",
+			LevelInfo,
+			func(l *Logger) { l.Warn("slow", "key", "", "cost", 1500*time.Millisecond) },
+			"level=WARN msg=slow key=\"\" cost=1.5s\n",
+		},
+		{
+			"bad key",
+			LevelInfo,
+			func(l *Logger) { l.Info("odd", "key") },
+			"level=INFO msg=odd !BADKEY=key\n",
+		},
+		{
+			"with attrs",
+			LevelInfo,
+			func(l *Logger) { l.With("job", "backup").Info("done", "count", 2) },
+			"level=INFO msg=done job=backup count=2\n",
+		},
+		{
+			"below level",
+			LevelInfo,
+			func(l *Logger) { l.Debug("into dir", "dir", "/data") },
+			"",
+		},
+		{
+			"debug enabled",
+			LevelDebug,
+			func(l *Logger) { l.Debug("into dir", "dir", "/data") },
+			"level=DEBUG msg=\"into dir\" dir=/data\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := New(&buf, tt.level, FormatText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.log(l)
+			if got := timePattern.ReplaceAllString(buf.String(), ""); got != tt.want {
+				t.Errorf("Logger rsp got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggerJson(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, LevelInfo, FormatJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.With("storage", "cos").Error("put error", "key", "a.txt", "size", 10, "ok", false, "error", errors.New("denied"))
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Logger json rsp got %q, error: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"level": "ERROR", "msg": "put error", "storage": "cos", "key": "a.txt", "size": float64(10), "ok": false, "error": "denied",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Logger json %s got %v, want %v", k, got[k], v)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, got["time"].(string)); err != nil {
+		t.Errorf("Logger json time got %v, error: %v", got["time"], err)
+	}
+	if !strings.HasSuffix(buf.String(), "}\n") {
+		t.Errorf("Logger json rsp got %q, want one line", buf.String())
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, LevelInfo, "xml"); err == nil {
+		t.Errorf("New rsp got nil error, want error")
+	}
+}
@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotateWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxBackups int
+		writes     []string
+		want       map[string]string // 写入后各文件的内容，空字符串表示文件不存在
+	}{
+		{
+			"no rotate",
+			0,
+			2,
+			[]string{"aaaa\n", "bbbb\n", "cccc\n"},
+			map[string]string{"app.log": "aaaa\nbbbb\ncccc\n", "app.log.1": ""},
+		},
+		{
+			"rotate keeps backups",
+			10,
+			2,
+			[]string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"},
+			map[string]string{"app.log": "gggg\n", "app.log.1": "eeee\nffff\n", "app.log.2": "cccc\ndddd\n", "app.log.3": ""},
+		},
+		{
+			"rotate without backups",
+			10,
+			0,
+			[]string{"aaaa\n", "bbbb\n", "cccc\n"},
+			map[string]string{"app.log": "cccc\n", "app.log.1": ""},
+		},
+		{
+			"line larger than max size",
+			4,
+			1,
+			[]string{"aaaaaaaa\n", "bbbbbbbb\n"},
+			map[string]string{"app.log": "bbbbbbbb\n", "app.log.1": "aaaaaaaa\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := NewRotateWriter(filepath.Join(dir, "app.log"), tt.maxSize, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.writes {
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w.Close()
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if want == "" {
+					if err == nil {
+						t.Errorf("RotateWriter file %s got %q, want not exist", name, got)
+					}
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("RotateWriter file %s got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotateWriterAppend(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "app.log")
+	for _, s := range []string{"first\n", "second\n"} {
+		w, err := NewRotateWriter(path, 10, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(s))
+		w.Close()
+	}
+	// 已有文件的大小计入轮转判断
+	got, _ := os.ReadFile(path)
+	backup, _ := os.ReadFile(path + ".1")
+	if string(got) != "second\n" || string(backup) != "first\n" {
+		t.Errorf("RotateWriter rsp got %q and %q, want %q and %q", got, backup, "second\n", "first\n")
+	}
+}
+
+func TestSetup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "osd-tool.log")
+	old := Default()
+	defer SetDefault(old)
+	closer, err := Setup(Options{Level: "warn", Format: FormatJson, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Info("upload success", "key", "a.txt")
+	Warn("source is empty", "from", "/data")
+	closer.Close()
+
+	got, _ := os.ReadFile(path)
+	if strings.Count(string(got), "\n") != 1 || !strings.Contains(string(got), `"msg":"source is empty"`) {
+		t.Errorf("Setup rsp got %q, want only the warn line", got)
+	}
+	if _, err := Setup(Options{Level: "verbose"}); err == nil {
+		t.Errorf("Setup rsp got nil error, want error")
+	}
+}
@@ -1,27 +1,42 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/logger"
+	"github.com/jorben/osd-tool/provider"
 	conf "github.com/ldigit/config"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 const Version = "v1.0.3"
 const RepoName = "jorben/osd-tool"
 const BinName = "osd-tool_{os}_{arch}"
 const PackageName = "osd-tool_{os}_{arch}.tgz"
+const ChecksumsName = "checksums.txt"     // 版本发布的sha256校验文件
+const SignatureName = "checksums.txt.sig" // 校验文件的ed25519签名
+
+// configErr 加载配置文件时的错误
+var configErr error
 
 // loadConfig 加载配置项
-func loadConfig(path string) *config.TransferConfig {
-	cfg := &config.TransferConfig{}
-	if err := conf.LoadAndDecode(path, cfg); err != nil {
-		return nil
+func loadConfig(path string) (*config.TransferConfig, error) {
+	cfg, err := config.Load(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New(fmt.Sprintf("config file '%s' is not found, please check the --config path or run init", path))
+	}
+	if err != nil {
+		return nil, err
 	}
 	// 处理Path中带有~的情况，替换为真实绝对路径
 	homeDir, _ := os.UserHomeDir()
@@ -35,72 +50,667 @@ func loadConfig(path string) *config.TransferConfig {
 			cfg.Download.List[i].Dest = strings.Replace(p.Dest, "~", homeDir, 1)
 		}
 	}
-	return cfg
+	return cfg, nil
 }
 
-// doMakeConfig 创建模版配置文件
-func doMakeConfig(path string) error {
+// doMakeConfig 初始化配置文件，默认通过交互向导获取配置，--non-interactive时使用命令行参数
+func doMakeConfig(ctx *cli.Context, path string) error {
+	uploads, err := parsePathPairs(ctx.StringSlice("upload"))
+	if err != nil {
+		return err
+	}
+	downloads, err := parsePathPairs(ctx.StringSlice("download"))
+	if err != nil {
+		return err
+	}
+	opt := &InitOptions{
+		Storage: ctx.String("storage"), Bucket: ctx.String("bucket"), Region: ctx.String("region"),
+		Endpoint: ctx.String("endpoint"), Credentials: ctx.String("credentials"),
+		SecretId: ctx.String("secret-id"), SecretKey: ctx.String("secret-key"),
+		CredentialProcess: ctx.String("credential-process"),
+		Uploads:           uploads, Downloads: downloads, Test: ctx.Bool("test"),
+	}
+	_, statErr := os.Stat(path)
+	exists := !os.IsNotExist(statErr)
+
+	interactive := !ctx.Bool("non-interactive")
+	var p *prompter
+	if interactive {
+		p = newPrompter(os.Stdin, ctx.App.Writer)
+		if exists && !p.confirm(fmt.Sprintf("%s 已存在，是否覆盖（原文件备份为 %s.bak）", path, path), false) {
+			return nil
+		}
+		if err := runInitWizard(p, opt); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := buildInitConfig(opt)
+	if err != nil {
+		return err
+	}
+	if opt.Credentials == CredentialsFile {
+		err := config.SaveCredential("", config.DefaultProfile,
+			config.Credential{SecretId: opt.SecretId, SecretKey: opt.SecretKey})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.App.Writer, "Credentials are saved to %s\n", config.CredentialsFilePath(""))
+	}
+	if opt.Test {
+		if err := testConnectivity(cfg); err != nil {
+			fmt.Fprintf(ctx.App.Writer, "Connectivity test failed: %s\n", err.Error())
+			if !interactive || !p.confirm("仍然写入配置文件", true) {
+				return err
+			}
+		} else {
+			fmt.Fprintln(ctx.App.Writer, "Connectivity test passed")
+		}
+	}
+
 	// 检查文件是否存在，存在则进行备份
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
+	if exists {
 		if err := os.Rename(path, path+".bak"); err != nil {
-			log.Printf("Error while backup config - %s", err.Error())
+			logger.Error("backup config error", "file", path, "error", err)
 			return err
 		}
 	}
-	buf := config.GetConfigDemo()
-	dest, err := os.Create(path)
+	buf, err := config.RenderConfig(cfg)
 	if err != nil {
 		return err
 	}
-	defer dest.Close()
-	if _, err := io.WriteString(dest, string(buf)); err != nil {
+	if err := os.WriteFile(path, buf, 0600); err != nil {
 		return err
 	}
-	fmt.Printf("Configuration file initialization completed, look at %s\n", path)
+	fmt.Fprintf(ctx.App.Writer, "Configuration file initialization completed, look at %s\n", path)
 	return nil
 }
 
-// doUpload 执行上传
-func doUpload(ctx *cli.Context) error {
+// getConfig 获取已加载的全局配置
+func getConfig() (*config.TransferConfig, error) {
+	if configErr != nil {
+		return nil, configErr
+	}
 	raw := conf.GetGlobalConfig()
 	if raw == nil {
-		return errors.New("configuration is empty, please check the config file path")
+		return nil, errors.New("configuration is empty, please check the config file path")
+	}
+	return raw.(*config.TransferConfig), nil
+}
+
+// doUpload 执行上传
+func doUpload(ctx *cli.Context) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
 	}
-	cfg := raw.(*config.TransferConfig)
 	transfer, err := NewTransfer(cfg)
 	if err != nil {
 		return err
 	}
-	return transfer.Upload()
+	transfer.Progress = ctx.String("progress")
+	if transfer.Report, err = transferReport(ctx, cfg, "upload"); err != nil {
+		return err
+	}
+	if transfer.Metrics, err = startMetrics(ctx, "upload"); err != nil {
+		return err
+	}
+	return finishMetrics(transfer.Metrics, saveReport(transfer.Report, transfer.Upload()))
 }
 
 // doDownload 执行下载
 func doDownload(ctx *cli.Context) error {
-	raw := conf.GetGlobalConfig()
-	if raw == nil {
-		return errors.New("configuration is empty, please check the config file path")
+	cfg, err := getConfig()
+	if err != nil {
+		return err
 	}
-	cfg := raw.(*config.TransferConfig)
 	transfer, err := NewTransfer(cfg)
 	if err != nil {
 		return err
 	}
-	return transfer.Download()
+	transfer.Progress = ctx.String("progress")
+	if transfer.Report, err = transferReport(ctx, cfg, "download"); err != nil {
+		return err
+	}
+	if transfer.Metrics, err = startMetrics(ctx, "download"); err != nil {
+		return err
+	}
+	return finishMetrics(transfer.Metrics, saveReport(transfer.Report, transfer.Download()))
+}
+
+// transferFlags 传输指令的进度、报告、指标参数
+func transferFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name: "progress",
+			Usage: "传输进度的显示方式，支持 auto、live、plain、none，auto 时标准输出为终端则实时刷新，" +
+				"否则每10秒输出一行汇总",
+			Value: ProgressAuto,
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "传输报告的路径，{time} 替换为开始时间，覆盖配置中的 report.path",
+		},
+		&cli.StringFlag{
+			Name:  "report-format",
+			Usage: "传输报告的格式，支持 json、csv，不指定时按扩展名判断",
+		},
+		&cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "传输期间提供 Prometheus 指标的http监听地址，如 :9464，通过 /metrics 抓取",
+		},
+		&cli.StringFlag{
+			Name:  "metrics-textfile",
+			Usage: "传输结束时写入 Prometheus 指标的文件，供 node_exporter 的 textfile collector 采集，扩展名应为 .prom",
+		},
+	}
+}
+
+// transferReport 按参数和配置获取传输报告，未指定报告路径时返回nil
+func transferReport(ctx *cli.Context, cfg *config.TransferConfig, command string) (*TransferReport, error) {
+	path, format := "", ""
+	if cfg.Report != nil {
+		path, format = cfg.Report.Path, cfg.Report.Format
+	}
+	if ctx.IsSet("report") {
+		path = ctx.String("report")
+	}
+	if ctx.IsSet("report-format") {
+		format = ctx.String("report-format")
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return NewTransferReport(command, cfg, path, format)
+}
+
+// saveReport 写入传输报告，传输失败时也写入，返回传输的错误，传输成功时返回写入报告的错误
+func saveReport(report *TransferReport, err error) error {
+	if report == nil {
+		return err
+	}
+	if e := report.Save(); e != nil {
+		logger.Error("save report error", "file", report.Path(), "error", e)
+		if err == nil {
+			err = e
+		}
+		return err
+	}
+	if report.format == config.ReportCsv {
+		logger.Info("report saved", "file", report.Path(), "meta", report.MetaPath())
+	} else {
+		logger.Info("report saved", "file", report.Path())
+	}
+	return err
+}
+
+// startMetrics 按参数开始记录传输指标，未指定监听地址和文件时返回nil
+func startMetrics(ctx *cli.Context, command string) (*TransferMetrics, error) {
+	addr, textfile := ctx.String("metrics-addr"), ctx.String("metrics-textfile")
+	if addr == "" && textfile == "" {
+		return nil, nil
+	}
+	m := NewTransferMetrics(command, addr, textfile)
+	if err := m.Start(); err != nil {
+		return nil, errors.New(fmt.Sprintf("listen metrics address '%s' error: %v", addr, err))
+	}
+	return m, nil
+}
+
+// finishMetrics 结束记录传输指标，返回传输的错误，传输成功时返回写入指标文件的错误
+func finishMetrics(m *TransferMetrics, err error) error {
+	if e := m.Finish(err); e != nil {
+		logger.Error("write metrics textfile error", "file", m.textfile, "error", e)
+		if err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// doRun 执行指定名称的任务
+func doRun(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("job name is required, usage: run <job...>")
+	}
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	// 先检查所有任务是否存在，避免执行到一半才失败
+	var jobs []config.Job
+	for _, name := range ctx.Args().Slice() {
+		job, err := cfg.GetJob(name)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, *job)
+	}
+	transfer, err := NewTransfer(cfg)
+	if err != nil {
+		return err
+	}
+	transfer.Progress = ctx.String("progress")
+	if transfer.Report, err = transferReport(ctx, cfg, "run "+strings.Join(ctx.Args().Slice(), " ")); err != nil {
+		return err
+	}
+	if transfer.Metrics, err = startMetrics(ctx, "run"); err != nil {
+		return err
+	}
+	return finishMetrics(transfer.Metrics, saveReport(transfer.Report, transfer.RunJobs(jobs)))
+}
+
+// doJobs 列出配置的任务
+func doJobs(ctx *cli.Context) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(ctx.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDIRECTION\tSOURCE\tDEST\tPROFILE\tCONCURRENCY\tDELETE")
+	for _, job := range cfg.Jobs {
+		concurrency := job.Concurrency
+		if concurrency <= 0 {
+			concurrency = DefaultConcurrency
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%t\n",
+			job.Name, job.Direction, job.Source, job.Dest, job.Profile, concurrency, job.Delete)
+	}
+	return w.Flush()
+}
+
+// doDu 统计云端对象存储的用量
+func doDu(ctx *cli.Context) error {
+	depth := ctx.Int("depth")
+	if depth < 0 {
+		return errors.New(fmt.Sprintf("depth must not be negative, got %d", depth))
+	}
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	transfer, err := NewTransfer(cfg)
+	if err != nil {
+		return err
+	}
+	p, err := transfer.GetProvider("")
+	if err != nil {
+		return err
+	}
+	usages, total, err := Du(p, ctx.Args().First(), depth)
+	if err != nil {
+		return err
+	}
+	return PrintDu(ctx.App.Writer, ctx.String("format"), usages, total)
+}
+
+// doDiff 对比本地目录与云端对象存储的差异
+func doDiff(ctx *cli.Context) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	transfer, err := NewTransfer(cfg)
+	if err != nil {
+		return err
+	}
+	opt, err := ParseDiffOptions(ctx.String("compare"))
+	if err != nil {
+		return err
+	}
+
+	var list []DiffEntry
+	diff := func(job config.Job) error {
+		entries, err := transfer.Diff(job, opt)
+		if err != nil {
+			return err
+		}
+		list = append(list, entries...)
+		return nil
+	}
+
+	// 指定了临时路径时只对比该路径，按上传方向处理
+	if ctx.IsSet("local") || ctx.IsSet("remote") {
+		job := config.Job{Direction: config.DirectionUpload, Source: ctx.String("local"), Dest: ctx.String("remote"),
+			Ignore: cfg.Upload.Ignore, Compression: cfg.Compression}
+		if err := diff(job); err != nil {
+			return err
+		}
+		return PrintDiff(ctx.App.Writer, ctx.String("format"), list)
+	}
+
+	direction := ctx.String("direction")
+	if direction == "" || direction == config.DirectionUpload {
+		for _, job := range cfg.UploadJobs() {
+			if err := diff(job); err != nil {
+				return err
+			}
+		}
+	}
+	if direction == "" || direction == config.DirectionDownload {
+		for _, job := range cfg.DownloadJobs() {
+			if err := diff(job); err != nil {
+				return err
+			}
+		}
+	}
+	return PrintDiff(ctx.App.Writer, ctx.String("format"), list)
+}
+
+// doPresign 生成对象的预签名链接
+func doPresign(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("key is required, usage: presign <key>")
+	}
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	transfer, err := NewTransfer(cfg)
+	if err != nil {
+		return err
+	}
+
+	p, err := transfer.GetProvider("")
+	if err != nil {
+		return err
+	}
+
+	// 单个对象直接输出链接
+	if !ctx.Bool("recursive") {
+		u, err := Presign(p, ctx.Args().First(), ctx.String("method"), ctx.Duration("expires"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(ctx.App.Writer, u.Url)
+		return nil
+	}
+
+	list, err := PresignPrefix(p, ctx.Args().First(), ctx.String("method"), ctx.Duration("expires"))
+	if err != nil {
+		return err
+	}
+	var w io.Writer = ctx.App.Writer
+	if output := ctx.String("output"); output != "" {
+		fd, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		w = fd
+	}
+	return WritePresignCsv(w, list)
+}
+
+// doStat 查看对象的详细信息
+func doStat(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("key is required, usage: stat <key>")
+	}
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	transfer, err := NewTransfer(cfg)
+	if err != nil {
+		return err
+	}
+	p, err := transfer.GetProvider("")
+	if err != nil {
+		return err
+	}
+	// SSE-C加密的对象需要提供密钥才能查看
+	obj, err := p.Head(strings.TrimLeft(ctx.Args().First(), "/"),
+		&provider.GetOptions{Encryption: cfg.ServerSideEncryption})
+	if err != nil {
+		return err
+	}
+	PrintStat(ctx.App.Writer, obj)
+	return nil
+}
+
+// doRestore 对归档对象发起取回，未指定前缀时处理配置中的download list
+func doRestore(ctx *cli.Context) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	transfer, err := NewTransfer(cfg)
+	if err != nil {
+		return err
+	}
+
+	// 命令行参数覆盖配置文件中的取回配置
+	restore := config.Restore{}
+	if cfg.Restore != nil {
+		restore = *cfg.Restore
+	}
+	restore.Enabled = true
+	if ctx.IsSet("days") {
+		restore.Days = ctx.Int("days")
+	}
+	if ctx.IsSet("tier") {
+		restore.Tier = ctx.String("tier")
+	}
+	if ctx.IsSet("wait") {
+		restore.Wait = ctx.Bool("wait")
+	}
+	if ctx.IsSet("interval") {
+		restore.Interval = int(ctx.Duration("interval").Seconds())
+	}
+	if ctx.IsSet("timeout") {
+		restore.Timeout = int(ctx.Duration("timeout").Seconds())
+	}
+
+	targets := []config.Path{}
+	for _, prefix := range ctx.Args().Slice() {
+		targets = append(targets, config.Path{Source: prefix})
+	}
+	if len(targets) == 0 {
+		targets = cfg.Download.List
+	}
+	var entries []*RestoreEntry
+	for _, dir := range targets {
+		list, err := transfer.RestorePrefix(dir.Profile, dir.Source, &restore)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, list...)
+	}
+	return PrintRestoreReport(ctx.App.Writer, ctx.String("format"), entries)
+}
+
+// doConfigValidate 校验配置文件，输出发现的问题，有错误时返回失败
+func doConfigValidate(ctx *cli.Context) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	issues := cfg.Validate()
+	errCount := 0
+	for _, issue := range issues {
+		if issue.Level == config.LevelError {
+			errCount++
+		}
+		fmt.Fprintln(ctx.App.Writer, issue.String())
+	}
+	if errCount > 0 {
+		return errors.New(fmt.Sprintf("config is invalid, %d errors, %d warnings", errCount, len(issues)-errCount))
+	}
+	fmt.Fprintf(ctx.App.Writer, "config is valid, %d warnings\n", len(issues))
+	return nil
+}
+
+// doDoctor 诊断当前存储配置的网络、密钥和读写权限，有检查失败时返回失败
+func doDoctor(ctx *cli.Context) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	profile, err := cfg.GetProfile("")
+	if err != nil {
+		return err
+	}
+	failed := PrintDoctorReport(ctx.App.Writer, RunDoctor(profile, ctx.String("prefix")))
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("doctor found %d failed checks", failed))
+	}
+	return nil
+}
+
+// upgradeOptions 获取升级选项，命令行参数覆盖配置文件中的升级配置，配置文件不存在时不影响从GitHub、镜像或本地升级
+func upgradeOptions(ctx *cli.Context) (UpgradeOptions, error) {
+	opt := UpgradeOptions{Version: ctx.String("version"), Channel: ctx.String("channel"),
+		InsecureSkipVerify: ctx.Bool("insecure-skip-verify")}
+	cfg, cfgErr := getConfig()
+	if cfgErr == nil && cfg.Upgrade != nil {
+		opt.Source, opt.Proxy = cfg.Upgrade.Source, cfg.Upgrade.Proxy
+		opt.Timeout = time.Duration(cfg.Upgrade.Timeout) * time.Second
+	}
+	if ctx.IsSet("source") {
+		opt.Source = ctx.String("source")
+	}
+	if ctx.IsSet("proxy") {
+		opt.Proxy = ctx.String("proxy")
+	}
+	if ctx.IsSet("timeout") {
+		opt.Timeout = ctx.Duration("timeout")
+	}
+	if strings.HasPrefix(opt.Source, SourceBucket) {
+		if cfgErr != nil {
+			return opt, cfgErr
+		}
+		transfer, err := NewTransfer(cfg)
+		if err != nil {
+			return opt, err
+		}
+		if opt.Provider, err = transfer.GetProvider(""); err != nil {
+			return opt, err
+		}
+	}
+	return opt, nil
+}
+
+// doSecretSet 加密保存密钥到配置文件
+func doSecretSet(ctx *cli.Context, path string) error {
+	field := ctx.Args().First()
+	if field == "" {
+		return errors.New("field is required, usage: secret set <field>")
+	}
+	// 未指定密钥文件时使用配置文件中的key_file，与加载配置时解密使用的密钥保持一致
+	keyFile := ctx.String("key-file")
+	if keyFile == "" {
+		var err error
+		if keyFile, err = config.ReadKeyFile(path); err != nil {
+			return err
+		}
+	}
+	passphrase, err := config.LoadPassphrase(keyFile, true)
+	if err != nil {
+		return err
+	}
+
+	// 未通过参数指定时从终端读取，避免密钥出现在命令历史中
+	value := ctx.String("value")
+	if !ctx.IsSet("value") && term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "Enter %s: ", field)
+		buf, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		value = string(buf)
+	} else if !ctx.IsSet("value") {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		value = strings.TrimRight(line, "\r\n")
+	}
+
+	if err := config.SetSecret(path, ctx.String("profile"), field, value, passphrase, ctx.String("key-file")); err != nil {
+		return err
+	}
+	fmt.Printf("Secret %s has been encrypted and saved to %s\n", field, path)
+	return nil
 }
 
 // doUpgrade 执行当前程序的版本升级
 func doUpgrade(ctx *cli.Context) error {
-	// 初始化实例，获取最新版本信息
-	updater, err := NewUpdater(RepoName, BinName, PackageName)
+	if ctx.Bool("rollback") {
+		if err := Rollback(); err != nil {
+			return err
+		}
+		fmt.Println("Rolled back to the previous version, please restart.")
+		return nil
+	}
+	opt, err := upgradeOptions(ctx)
 	if err != nil {
 		return err
 	}
 
-	// 判断是否最新版本
-	if updater.IsLatest(Version) {
+	// 初始化实例，获取目标版本信息
+	updater, err := NewUpdater(RepoName, BinName, PackageName, opt)
+	if err != nil {
+		return err
+	}
+	if ctx.Bool("list") {
+		releases, err := updater.Releases()
+		if err != nil {
+			return err
+		}
+		return PrintReleases(ctx.App.Writer, releases, Version)
+	}
+	if err := updater.Resolve(); err != nil {
+		return err
+	}
+
+	// 只检查时不执行任何安装
+	cmp := helper.CompareVersion(updater.Version(), Version)
+	if ctx.Bool("check") {
+		switch {
+		case updater.IsLocal():
+			fmt.Printf("Local package %s (%s) can be installed, its version is not compared with the current version %s.\n",
+				opt.Source, updater.Version(), Version)
+		case cmp > 0:
+			fmt.Printf("New version %s is available, current version is %s.\n", updater.Version(), Version)
+		case cmp == 0:
+			fmt.Printf("Already running version %s.\n", Version)
+		case opt.Version != "":
+			fmt.Printf("Version %s is lower than the current version %s, upgrade --version %s will downgrade.\n",
+				updater.Version(), Version, updater.Version())
+		default:
+			fmt.Printf("Already running the latest version %s.\n", Version)
+		}
+		if !updater.IsLocal() && cmp != 0 && !updater.HasChecksums() {
+			fmt.Printf("Version %s has no %s, it can only be installed with --insecure-skip-verify.\n",
+				updater.Version(), ChecksumsName)
+		}
+		return nil
+	}
+
+	// 本地安装包无法比较版本，直接安装
+	if updater.IsLocal() {
+		if err := updater.Upgrade(); err != nil {
+			return err
+		}
+		fmt.Println("Version upgrade finished, please restart.")
+		return nil
+	}
+
+	// 判断是否需要升级，指定版本时允许降级
+	version := opt.Version
+	if version == "" && updater.IsLatest(Version) {
 		fmt.Println("Already running the latest version.")
 		return nil
 	}
+	if cmp == 0 {
+		fmt.Printf("Already running version %s.\n", Version)
+		return nil
+	}
+	if cmp < 0 {
+		fmt.Printf("Downgrading from %s to %s.\n", Version, updater.Version())
+	}
 
 	// 执行升级，失败时候自动回滚
 	if err := updater.Upgrade(); err != nil {
@@ -115,26 +725,309 @@ func main() {
 
 	// 配置文件路径，从参数获取
 	var configPath string
+	// 强制使用的存储配置名称，从参数获取
+	var profile string
+	// 日志选项，从参数获取
+	var logOpt logger.Options
+	var logMaxSize int64
+	// 日志文件，退出前关闭
+	var logFile io.Closer
 	// 支持的指令
 	commends := []*cli.Command{
 		{
 			Name:    "upload",
 			Aliases: []string{"u"},
 			Usage:   "把配置的本地目录上传到云端对象存储中",
+			Flags:   transferFlags(),
 			Action:  doUpload,
 		},
 		{
 			Name:    "download",
 			Aliases: []string{"d"},
 			Usage:   "按配置从云端对象存储中下载文件到本地",
+			Flags:   transferFlags(),
 			Action:  doDownload,
 		},
+		{
+			Name:      "run",
+			Usage:     "执行配置中指定名称的任务",
+			ArgsUsage: "<job...>",
+			Flags:     transferFlags(),
+			Action:    doRun,
+		},
+		{
+			Name:   "jobs",
+			Usage:  "列出配置中的任务",
+			Action: doJobs,
+		},
+		{
+			Name:      "du",
+			Usage:     "统计云端对象存储指定前缀下的文件数量和容量",
+			ArgsUsage: "[prefix]",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "depth",
+					Usage: "按目录层级聚合的深度",
+					Value: 1,
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "输出格式，支持 text、json、csv",
+					Value: "text",
+				},
+			},
+			Action: doDu,
+		},
+		{
+			Name:  "diff",
+			Usage: "对比本地目录与云端对象存储的差异，默认对比配置中的upload list和download list",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "direction",
+					Usage: "只对比指定方向的配置，支持 upload、download",
+				},
+				&cli.StringFlag{
+					Name:  "local",
+					Usage: "临时指定要对比的本地路径",
+				},
+				&cli.StringFlag{
+					Name:  "remote",
+					Usage: "临时指定要对比的云端路径",
+				},
+				&cli.StringFlag{
+					Name:  "compare",
+					Usage: "对比方式，逗号分隔，支持 size、checksum、mtime",
+					Value: "size",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "输出格式，支持 json、csv",
+					Value: "json",
+				},
+			},
+			Action: doDiff,
+		},
+		{
+			Name:      "stat",
+			Usage:     "查看对象的大小、存储类型、加密方式等详细信息",
+			ArgsUsage: "<key>",
+			Action:    doStat,
+		},
+		{
+			Name:      "presign",
+			Usage:     "生成对象的临时访问链接，可对整个前缀批量生成",
+			ArgsUsage: "<key>",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "expires",
+					Usage: "链接有效期，如 30m、1h、24h",
+					Value: time.Hour,
+				},
+				&cli.StringFlag{
+					Name:  "method",
+					Usage: "链接的请求方法，支持 GET、PUT",
+					Value: "GET",
+				},
+				&cli.BoolFlag{
+					Name:  "recursive",
+					Usage: "把key作为前缀，为其下的所有对象生成链接并以csv格式输出",
+				},
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "批量生成时写入的csv文件路径，默认输出到终端",
+				},
+			},
+			Action: doPresign,
+		},
+		{
+			Name:      "restore",
+			Usage:     "对归档、深度归档的对象发起取回，未指定前缀时处理配置中的download list",
+			ArgsUsage: "[prefix...]",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "days",
+					Usage: "取回后可读的天数",
+					Value: DefaultRestoreDays,
+				},
+				&cli.StringFlag{
+					Name:  "tier",
+					Usage: "取回模式，支持 Expedited、Standard、Bulk",
+				},
+				&cli.BoolFlag{
+					Name:  "wait",
+					Usage: "等待全部对象取回完成",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "等待时的轮询间隔",
+					Value: DefaultRestoreInterval * time.Second,
+				},
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "最长等待时间",
+					Value: DefaultRestoreTimeout * time.Second,
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "输出格式，支持 text、json",
+					Value: "text",
+				},
+			},
+			Action: doRestore,
+		},
+		{
+			Name:   "doctor",
+			Usage:  "诊断存储配置，检查域名解析、TLS、密钥及列出、上传、下载、删除权限",
+			Action: doDoctor,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "prefix",
+					Usage: "读写检查使用的临时对象所在的目录，如 /syncTest，需要有该目录的写入权限",
+				},
+			},
+		},
+		{
+			Name:   "upgrade",
+			Usage:  "升级当前工具版本，可以指定版本、发布渠道，或回滚到升级前的版本",
+			Action: doUpgrade,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "version",
+					Usage: "升级到指定的版本，如 v1.0.3，可以低于当前版本",
+				},
+				&cli.StringFlag{
+					Name:  "channel",
+					Usage: "发布渠道，stable 为正式版本，prerelease 包含预发布版本",
+					Value: ChannelStable,
+				},
+				&cli.BoolFlag{
+					Name:  "list",
+					Usage: "列出已发布的版本",
+				},
+				&cli.BoolFlag{
+					Name:  "check",
+					Usage: "只检查是否有新版本，不升级",
+				},
+				&cli.BoolFlag{
+					Name:  "rollback",
+					Usage: "回滚到升级前的版本",
+				},
+				&cli.BoolFlag{
+					Name: "insecure-skip-verify",
+					Usage: "目标版本没有发布校验文件 checksums.txt 时跳过校验，用于降级到 v1.0.3 及之前的版本，" +
+						"发布了校验文件的版本仍然校验",
+				},
+				&cli.StringFlag{
+					Name: "source",
+					Usage: "升级来源，默认为GitHub，可以是与GitHub API格式相同的镜像地址、" +
+						"osd://前缀（对象存储中按版本号存放的目录）或本地安装包路径",
+				},
+				&cli.StringFlag{
+					Name:  "proxy",
+					Usage: "http代理，如 http://127.0.0.1:3128，默认使用环境变量 HTTPS_PROXY、HTTP_PROXY",
+				},
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "http请求的超时时间，包含下载安装包的时间",
+					Value: DefaultUpgradeTimeout,
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "管理配置文件",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "validate",
+					Usage:  "校验配置文件，检查未知配置项、存储配置、路径及任务等",
+					Action: doConfigValidate,
+				},
+			},
+		},
+		{
+			Name:  "secret",
+			Usage: "管理配置文件中加密保存的密钥",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "set",
+					Usage:     "加密保存密钥，支持 secret_id、secret_key、session_token",
+					ArgsUsage: "<field>",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "profile",
+							Usage: "写入指定名称的存储配置，默认写入osd配置",
+						},
+						&cli.StringFlag{
+							Name:  "value",
+							Usage: "密钥内容，不指定时从终端输入",
+						},
+						&cli.StringFlag{
+							Name:  "key-file",
+							Usage: "加密使用的密钥文件，会写入配置的 key_file，不指定时使用配置中的 key_file 或口令",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						return doSecretSet(cCtx, configPath)
+					},
+				},
+			},
+		},
 		{
 			Name:    "init",
 			Aliases: []string{"i"},
-			Usage:   "按模版初始化配置文件",
+			Usage:   "通过交互向导初始化配置文件，加上--non-interactive时使用命令行参数生成",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "non-interactive",
+					Usage: "不进行交互，按命令行参数生成配置文件，用于脚本",
+				},
+				&cli.StringFlag{
+					Name:  "storage",
+					Usage: "存储类型，支持 cos、oss",
+				},
+				&cli.StringFlag{
+					Name:  "bucket",
+					Usage: "存储桶名称",
+				},
+				&cli.StringFlag{
+					Name:  "region",
+					Usage: "存储桶的地域",
+				},
+				&cli.StringFlag{
+					Name:  "endpoint",
+					Usage: "自定义访问域名",
+				},
+				&cli.StringFlag{
+					Name:  "credentials",
+					Usage: "密钥来源，支持 config、env、file、process",
+				},
+				&cli.StringFlag{
+					Name:  "secret-id",
+					Usage: "密钥ID，密钥来源为config或file时使用",
+				},
+				&cli.StringFlag{
+					Name:  "secret-key",
+					Usage: "密钥Key，密钥来源为config或file时使用",
+				},
+				&cli.StringFlag{
+					Name:  "credential-process",
+					Usage: "获取临时密钥的外部命令，密钥来源为process时使用",
+				},
+				&cli.StringSliceFlag{
+					Name:  "upload",
+					Usage: "上传目录，格式为 本地目录=对象存储路径，可指定多次",
+				},
+				&cli.StringSliceFlag{
+					Name:  "download",
+					Usage: "下载目录，格式为 对象存储路径=本地目录，可指定多次",
+				},
+				&cli.BoolFlag{
+					Name:  "test",
+					Usage: "写入前列出存储桶中的对象，测试存储配置能否正常访问",
+				},
+			},
 			Action: func(cCtx *cli.Context) error {
-				return doMakeConfig(configPath)
+				return doMakeConfig(cCtx, configPath)
 			},
 		},
 	}
@@ -149,9 +1042,43 @@ func main() {
 			Destination: &configPath,
 			Value:       "config.yaml",
 		},
+		&cli.StringFlag{
+			Name:        "profile",
+			Usage:       "使用指定名称的存储配置，覆盖配置文件中各目录指定的配置",
+			Destination: &profile,
+		},
+		&cli.StringFlag{
+			Name:        "log-level",
+			Usage:       "日志级别，支持 debug、info、warn、error，debug 时输出跳过的文件和遍历的目录",
+			Destination: &logOpt.Level,
+			Value:       "info",
+		},
+		&cli.StringFlag{
+			Name:        "log-format",
+			Usage:       "日志格式，支持 text、json，json 为每行一个JSON对象，便于日志系统采集",
+			Destination: &logOpt.Format,
+			Value:       logger.FormatText,
+		},
+		&cli.StringFlag{
+			Name:        "log-file",
+			Usage:       "日志文件路径，为空时输出到标准错误",
+			Destination: &logOpt.File,
+		},
+		&cli.Int64Flag{
+			Name:        "log-max-size",
+			Usage:       "单个日志文件的最大容量，单位MB，超过后轮转为 <log-file>.1，为0时不轮转",
+			Destination: &logMaxSize,
+			Value:       100,
+		},
+		&cli.IntFlag{
+			Name:        "log-max-backups",
+			Usage:       "日志文件轮转后保留的历史文件数",
+			Destination: &logOpt.MaxBackups,
+			Value:       5,
+		},
 		&cli.BoolFlag{
 			Name:               "upgrade",
-			Usage:              "检查和升级当前工具版本",
+			Usage:              "升级到最新的正式版本，同 upgrade 指令",
 			DisableDefaultText: true,
 			Action: func(cCtx *cli.Context, b bool) error {
 				err := doUpgrade(cCtx)
@@ -170,10 +1097,23 @@ func main() {
 		Flags:          flags,
 		Commands:       commends,
 		Before: func(cCtx *cli.Context) error {
-			// 初始化配置内容，存储到全局变量中
-			if cfg := loadConfig(configPath); cfg != nil {
-				conf.SetGlobalConfig(cfg)
+			// 先初始化日志，之后的日志按参数输出
+			logOpt.MaxSize = logMaxSize * 1024 * 1024
+			closer, err := logger.Setup(logOpt)
+			if err != nil {
+				return err
+			}
+			logFile = closer
+			// 初始化配置内容，存储到全局变量中，加载失败时由需要配置的指令返回错误
+			cfg, err := loadConfig(configPath)
+			if err != nil {
+				configErr = err
+				return nil
+			}
+			if profile != "" {
+				cfg.UseProfile(profile)
 			}
+			conf.SetGlobalConfig(cfg)
 			return nil
 		},
 		CommandNotFound: func(cCtx *cli.Context, command string) {
@@ -181,7 +1121,11 @@ func main() {
 		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	err := app.Run(os.Args)
+	if logFile != nil {
+		logFile.Close()
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jorben/osd-tool/logger"
+	"github.com/jorben/osd-tool/metrics"
+	"github.com/jorben/osd-tool/provider"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransferMetrics 传输的 Prometheus 指标，运行期间通过http提供抓取，结束时可写入 textfile collector 目录
+type TransferMetrics struct {
+	registry *metrics.Registry
+	command  string
+	addr     string
+	textfile string
+	server   *http.Server
+	listener net.Listener
+
+	bytes     *metrics.Counter
+	files     *metrics.Counter
+	failures  *metrics.Counter
+	duration  *metrics.Histogram
+	requests  *metrics.Histogram
+	errors    *metrics.Counter
+	retries   *metrics.Counter
+	startTime *metrics.Gauge
+	endTime   *metrics.Gauge
+	success   *metrics.Gauge
+}
+
+// NewTransferMetrics 获取TransferMetrics实例，addr为http监听地址，textfile为结束时写入的文件，均可为空
+func NewTransferMetrics(command string, addr string, textfile string) *TransferMetrics {
+	r := metrics.NewRegistry()
+	m := &TransferMetrics{registry: r, command: command, addr: addr, textfile: textfile}
+	r.NewGauge("osd_tool_build_info", "Version of osd-tool.", "version").Set(1, Version)
+	m.startTime = r.NewGauge("osd_tool_run_start_time_seconds", "Unix time the run started.", "command")
+	m.endTime = r.NewGauge("osd_tool_run_end_time_seconds", "Unix time the run finished.", "command")
+	m.success = r.NewGauge("osd_tool_run_success", "Whether the last run finished without error.", "command")
+	m.bytes = r.NewCounter("osd_tool_transfer_bytes_total", "Bytes of files transferred successfully.",
+		"direction")
+	m.files = r.NewCounter("osd_tool_transfer_files_total", "Files processed by result.", "direction", "result")
+	m.failures = r.NewCounter("osd_tool_transfer_failures_total", "Files failed to transfer or delete by error class.",
+		"direction", "class")
+	m.duration = r.NewHistogram("osd_tool_transfer_duration_seconds", "Time to transfer a single file.", nil,
+		"direction")
+	m.requests = r.NewHistogram("osd_tool_provider_request_duration_seconds",
+		"Latency of object storage operations.", nil, "storage", "operation", "result")
+	m.errors = r.NewCounter("osd_tool_provider_request_errors_total", "Failed object storage operations by error class.",
+		"storage", "operation", "class")
+	m.retries = r.NewCounter("osd_tool_provider_retries_total", "Retried object storage operations.",
+		"storage", "operation")
+	return m
+}
+
+// Start 记录开始时间，指定了监听地址时启动http服务，地址无法监听时返回错误
+func (m *TransferMetrics) Start() error {
+	if m == nil {
+		return nil
+	}
+	m.startTime.Set(float64(time.Now().Unix()), m.command)
+	provider.RetryHook = m.Retry
+	if m.addr == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry.Handler())
+	m.listener = l
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := m.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server error", "addr", l.Addr().String(), "error", err)
+		}
+	}()
+	logger.Info("metrics server listening", "addr", "http://"+l.Addr().String()+"/metrics")
+	return nil
+}
+
+// Finish 记录结束时间和执行结果，写入textfile并关闭http服务，返回写入textfile的错误
+func (m *TransferMetrics) Finish(runErr error) error {
+	if m == nil {
+		return nil
+	}
+	provider.RetryHook = nil
+	m.endTime.Set(float64(time.Now().Unix()), m.command)
+	success := 0.0
+	if runErr == nil {
+		success = 1
+	}
+	m.success.Set(success, m.command)
+
+	var err error
+	if m.textfile != "" {
+		err = m.registry.WriteFile(m.textfile)
+	}
+	if m.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = m.server.Shutdown(ctx)
+	}
+	return err
+}
+
+// File 记录一个文件的处理结果，result为报告中的处理结果，duration为0时不记录耗时
+func (m *TransferMetrics) File(direction string, result string, size int64, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.files.Inc(direction, result)
+	if err != nil {
+		m.failures.Inc(direction, provider.ErrorClass(err))
+		return
+	}
+	if result == ReportUploaded || result == ReportDownloaded {
+		m.bytes.Add(float64(size), direction)
+		m.duration.Observe(duration.Seconds(), direction)
+	}
+}
+
+// Request 记录一次对象存储操作的耗时及错误
+func (m *TransferMetrics) Request(storage string, operation string, begin time.Time, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	// 对象已在取回中属于正常的状态
+	if err != nil && !errors.Is(err, provider.ErrRestoreInProgress) {
+		result = "error"
+		m.errors.Inc(storage, operation, provider.ErrorClass(err))
+	}
+	m.requests.Observe(time.Since(begin).Seconds(), storage, operation, result)
+}
+
+// Retry 记录一次对象存储操作的重试
+func (m *TransferMetrics) Retry(storage string, operation string) {
+	if m == nil {
+		return
+	}
+	m.retries.Inc(storage, operation)
+}
+
+// Provider 获取记录请求指标的Provider，为nil时原样返回
+func (m *TransferMetrics) Provider(p provider.Provider, storage string) provider.Provider {
+	if m == nil {
+		return p
+	}
+	return &metricsProvider{p: p, storage: storage, metrics: m}
+}
+
+// metricsProvider 记录每次操作耗时及错误的Provider
+type metricsProvider struct {
+	p       provider.Provider
+	storage string
+	metrics *TransferMetrics
+}
+
+func (s *metricsProvider) PutFile(key string, filepath string, opt *provider.PutOptions) error {
+	begin := time.Now()
+	err := s.p.PutFile(key, filepath, opt)
+	s.metrics.Request(s.storage, "PutFile", begin, err)
+	return err
+}
+
+func (s *metricsProvider) GetFile(key string, filepath string, opt *provider.GetOptions) error {
+	begin := time.Now()
+	err := s.p.GetFile(key, filepath, opt)
+	s.metrics.Request(s.storage, "GetFile", begin, err)
+	return err
+}
+
+func (s *metricsProvider) Head(key string, opt *provider.GetOptions) (*provider.Object, error) {
+	begin := time.Now()
+	obj, err := s.p.Head(key, opt)
+	s.metrics.Request(s.storage, "Head", begin, err)
+	return obj, err
+}
+
+func (s *metricsProvider) Delete(key string) error {
+	begin := time.Now()
+	err := s.p.Delete(key)
+	s.metrics.Request(s.storage, "Delete", begin, err)
+	return err
+}
+
+func (s *metricsProvider) List(prefix string, marker string) ([]provider.Object, error) {
+	begin := time.Now()
+	list, err := s.p.List(prefix, marker)
+	s.metrics.Request(s.storage, "List", begin, err)
+	return list, err
+}
+
+func (s *metricsProvider) ListPage(prefix string, maxKeys int) ([]provider.Object, error) {
+	begin := time.Now()
+	list, err := s.p.ListPage(prefix, maxKeys)
+	s.metrics.Request(s.storage, "ListPage", begin, err)
+	return list, err
+}
+
+func (s *metricsProvider) Presign(key string, method string, expires time.Duration) (string, error) {
+	begin := time.Now()
+	u, err := s.p.Presign(key, method, expires)
+	s.metrics.Request(s.storage, "Presign", begin, err)
+	return u, err
+}
+
+func (s *metricsProvider) Restore(key string, opt *provider.RestoreOptions) error {
+	begin := time.Now()
+	err := s.p.Restore(key, opt)
+	s.metrics.Request(s.storage, "Restore", begin, err)
+	return err
+}
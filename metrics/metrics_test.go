@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name    string
+		collect func(r *Registry)
+		want    string
+	}{
+		{
+			"counter",
+			func(r *Registry) {
+				c := r.NewCounter("osd_files_total", "Files processed.", "direction", "result")
+				c.Inc("upload", "uploaded")
+				c.Add(2, "upload", "uploaded")
+				c.Inc("download", "failed")
+			},
+			"# HELP osd_files_total Files processed.\n" +
+				"# TYPE osd_files_total counter\n" +
+				"osd_files_total{direction=\"download\",result=\"failed\"} 1\n" +
+				"osd_files_total{direction=\"upload\",result=\"uploaded\"} 3\n",
+		},
+		{
+			"gauge without labels",
+			func(r *Registry) {
+				r.NewGauge("osd_run_success", "Whether the run succeeded.").Set(1)
+			},
+			"# HELP osd_run_success Whether the run succeeded.\n" +
+				"# TYPE osd_run_success gauge\n" +
+				"osd_run_success 1\n",
+		},
+		{
+			"escape",
+			func(r *Registry) {
+				r.NewCounter("osd_errors_total", "Errors with \\ and\nnewline.", "error").Inc("a \"quoted\"\nline\\")
+			},
+			"# HELP osd_errors_total Errors with \\\\ and\\nnewline.\n" +
+				"# TYPE osd_errors_total counter\n" +
+				"osd_errors_total{error=\"a \\\"quoted\\\"\\nline\\\\\"} 1\n",
+		},
+		{
+			"histogram",
+			func(r *Registry) {
+				h := r.NewHistogram("osd_duration_seconds", "Request latency.", []float64{0.1, 1}, "operation")
+				h.Observe(0.05, "PutFile")
+				h.Observe(0.5, "PutFile")
+				h.Observe(3, "PutFile")
+			},
+			"# HELP osd_duration_seconds Request latency.\n" +
+				"# TYPE osd_duration_seconds histogram\n" +
+				"osd_duration_seconds_bucket{operation=\"PutFile\",le=\"0.1\"} 1\n" +
+				"osd_duration_seconds_bucket{operation=\"PutFile\",le=\"1\"} 2\n" +
+				"osd_duration_seconds_bucket{operation=\"PutFile\",le=\"+Inf\"} 3\n" +
+				"osd_duration_seconds_sum{operation=\"PutFile\"} 3.55\n" +
+				"osd_duration_seconds_count{operation=\"PutFile\"} 3\n",
+		},
+		{
+			"no series",
+			func(r *Registry) {
+				r.NewCounter("osd_retries_total", "Retries.", "operation")
+			},
+			"# HELP osd_retries_total Retries.\n" +
+				"# TYPE osd_retries_total counter\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.collect(r)
+			var buf bytes.Buffer
+			if err := r.WriteText(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("WriteText rsp got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(r *Registry)
+	}{
+		{"duplicate name", func(r *Registry) { r.NewCounter("a", "a"); r.NewGauge("a", "a") }},
+		{"reserved label", func(r *Registry) { r.NewHistogram("b", "b", nil, "le") }},
+		{"label count", func(r *Registry) { r.NewCounter("c", "c", "x").Inc() }},
+		{"negative counter", func(r *Registry) { r.NewCounter("d", "d").Add(-1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s rsp got no panic, want panic", tt.name)
+				}
+			}()
+			tt.fn(NewRegistry())
+		})
+	}
+}
+
+func TestHandlerAndWriteFile(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("osd_bytes_total", "Bytes.").Add(1024)
+	want := "osd_bytes_total 1024\n"
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Header().Get("Content-Type") != ContentType || !strings.HasSuffix(rec.Body.String(), want) {
+		t.Errorf("Handler rsp got %q %q, want %q", rec.Header().Get("Content-Type"), rec.Body.String(), want)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "textfile", "osd-tool.prom")
+	if err := r.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.HasSuffix(string(content), want) {
+		t.Errorf("WriteFile rsp got %q, want %q", content, want)
+	}
+	// 不留下临时文件
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("WriteFile left %d files, want 1", len(entries))
+	}
+}
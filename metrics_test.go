@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/provider"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubProvider 测试用的Provider，只实现用到的方法
+type stubProvider struct {
+	provider.Provider
+	err error
+}
+
+func (s *stubProvider) Delete(key string) error {
+	return s.err
+}
+
+func (s *stubProvider) Restore(key string, opt *provider.RestoreOptions) error {
+	return s.err
+}
+
+func TestMetricsProvider(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		operation string
+		call      func(p provider.Provider) error
+		want      string
+	}{
+		{"success", nil, "Delete", func(p provider.Provider) error { return p.Delete("a.txt") },
+			`osd_tool_provider_request_duration_seconds_count{storage="cos",operation="Delete",result="success"} 1`},
+		{"error", os.ErrDeadlineExceeded, "Delete", func(p provider.Provider) error { return p.Delete("a.txt") },
+			`osd_tool_provider_request_errors_total{storage="cos",operation="Delete",class="timeout"} 1`},
+		{"restore in progress", provider.ErrRestoreInProgress, "Restore",
+			func(p provider.Provider) error { return p.Restore("a.txt", nil) },
+			`osd_tool_provider_request_duration_seconds_count{storage="cos",operation="Restore",result="success"} 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewTransferMetrics("upload", "", "")
+			if err := tt.call(m.Provider(&stubProvider{err: tt.err}, provider.COS)); err != tt.err {
+				t.Errorf("%s rsp got %v, want %v", tt.operation, err, tt.err)
+			}
+			var buf strings.Builder
+			_ = m.registry.WriteText(&buf)
+			if !strings.Contains(buf.String(), tt.want+"\n") {
+				t.Errorf("WriteText rsp got\n%s\nwant contains %s", buf.String(), tt.want)
+			}
+		})
+	}
+	// 未开启指标时不包装
+	var m *TransferMetrics
+	p := &stubProvider{}
+	if got := m.Provider(p, provider.COS); got != p {
+		t.Errorf("Provider rsp got %v, want %v", got, p)
+	}
+}
+
+func TestTransferMetricsRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "osd-tool.prom")
+	m := NewTransferMetrics("upload", "127.0.0.1:0", path)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	m.File("upload", ReportUploaded, 1024, time.Second, nil)
+	m.File("upload", ReportFailed, 10, time.Second, os.ErrNotExist)
+	m.File("upload", ReportSkipped, 0, 0, nil)
+	provider.RetryHook(provider.COS, "List")
+
+	rsp, err := http.Get("http://" + m.listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	for _, want := range []string{
+		`osd_tool_transfer_bytes_total{direction="upload"} 1024`,
+		`osd_tool_transfer_files_total{direction="upload",result="failed"} 1`,
+		`osd_tool_transfer_files_total{direction="upload",result="skipped"} 1`,
+		`osd_tool_transfer_failures_total{direction="upload",class="other"} 1`,
+		`osd_tool_transfer_duration_seconds_count{direction="upload"} 1`,
+		`osd_tool_provider_retries_total{storage="cos",operation="List"} 1`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics rsp got\n%s\nwant contains %s", body, want)
+		}
+	}
+
+	if err := m.Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	if provider.RetryHook != nil {
+		t.Errorf("Finish RetryHook got %p, want nil", provider.RetryHook)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), "osd_tool_run_success{command=\"upload\"} 1\n") {
+		t.Errorf("Finish textfile got\n%s\nwant run success", content)
+	}
+	if _, err := http.Get("http://" + m.listener.Addr().String() + "/metrics"); err == nil {
+		t.Errorf("Finish server got running, want closed")
+	}
+}
+
+func TestTransferMetricsFailedFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		putErr  error
+		want    string
+		wantErr bool
+	}{
+		{"all uploaded", nil, `osd_tool_run_success{command="upload"} 1`, false},
+		{"upload failed", os.ErrPermission, `osd_tool_run_success{command="upload"} 0`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			for _, name := range []string{"a.txt", "b.txt"} {
+				if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := newMemProvider()
+			p.putErr = tt.putErr
+			transfer := newMemTransfer(p)
+			path := filepath.Join(t.TempDir(), "osd-tool.prom")
+			transfer.Metrics = NewTransferMetrics("upload", "", path)
+			if err := transfer.Metrics.Start(); err != nil {
+				t.Fatal(err)
+			}
+			// 文件上传失败时任务返回错误，指标和退出码都体现失败
+			err := transfer.RunJobs([]config.Job{{Name: "a", Direction: config.DirectionUpload, Source: src,
+				Dest: "/backup"}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RunJobs error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := finishMetrics(transfer.Metrics, err); (err != nil) != tt.wantErr {
+				t.Errorf("finishMetrics error got %v, wantErr %v", err, tt.wantErr)
+			}
+			content, _ := os.ReadFile(path)
+			if !strings.Contains(string(content), tt.want+"\n") {
+				t.Errorf("Finish textfile got\n%s\nwant contains %s", content, tt.want)
+			}
+		})
+	}
+}
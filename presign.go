@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/provider"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PresignedUrl 预签名链接
+type PresignedUrl struct {
+	Key       string
+	Method    string
+	ExpiresAt time.Time
+	Url       string
+}
+
+// Presign 为单个对象生成预签名链接
+func Presign(p provider.Provider, key string, method string, expires time.Duration) (*PresignedUrl, error) {
+	method = strings.ToUpper(method)
+	if method != http.MethodGet && method != http.MethodPut {
+		return nil, errors.New(fmt.Sprintf("presign method '%s' is not supported", method))
+	}
+	key = strings.TrimLeft(key, "/")
+	u, err := p.Presign(key, method, expires)
+	if err != nil {
+		return nil, err
+	}
+	return &PresignedUrl{Key: key, Method: method, ExpiresAt: time.Now().Add(expires), Url: u}, nil
+}
+
+// PresignPrefix 为前缀下的所有对象批量生成预签名链接
+func PresignPrefix(p provider.Provider, prefix string, method string, expires time.Duration) ([]*PresignedUrl, error) {
+	objects, err := p.List(strings.TrimLeft(prefix, "/"), "")
+	if err != nil {
+		return nil, err
+	}
+	var list []*PresignedUrl
+	for _, obj := range objects {
+		// 目录不需要签名
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		u, err := Presign(p, obj.Key, method, expires)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// WritePresignCsv 把预签名链接以csv格式输出
+func WritePresignCsv(w io.Writer, list []*PresignedUrl) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"key", "method", "expires_at", "url"})
+	for _, u := range list {
+		_ = cw.Write([]string{u.Key, u.Method, u.ExpiresAt.Format(time.RFC3339), u.Url})
+	}
+	cw.Flush()
+	return cw.Error()
+}
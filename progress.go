@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/logger"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 传输进度的显示方式
+const (
+	ProgressAuto  = "auto"  // 标准输出为终端时实时刷新，否则定期输出汇总行
+	ProgressLive  = "live"  // 实时刷新总体进度和每个协程当前传输的文件
+	ProgressPlain = "plain" // 定期输出一行汇总
+	ProgressNone  = "none"  // 不显示进度
+)
+
+const (
+	liveInterval  = 500 * time.Millisecond // 实时刷新的间隔
+	plainInterval = 10 * time.Second       // 输出汇总行的间隔
+	rateWindow    = 10 * time.Second       // 计算当前速度的时间窗口
+)
+
+// transferProgress 一次传输任务的总体进度，传输中按已传输的字节数计入已完成的容量，文件在传输完成时计入已完成的数量
+// 为nil时所有方法不做任何处理
+type transferProgress struct {
+	mu          sync.Mutex
+	w           io.Writer
+	live        bool
+	action      string
+	start       time.Time
+	files       int64 // 已加入传输队列的文件数
+	filesDone   int64
+	filesFailed int64
+	bytes       int64 // 已加入传输队列的文件总容量
+	bytesDone   int64 // 已传输的容量，包含传输中的文件已传输的部分和传输失败的文件
+	scanning    bool  // 是否还在遍历文件，遍历完成前总数还会增加
+	slots       []progressSlot
+	samples     []progressSample
+	lines       int // 实时刷新时上次输出的行数
+	stop        chan struct{}
+	stopped     chan struct{}
+	logOutput   io.Writer // 实时刷新时被替换前的日志输出，结束时恢复
+}
+
+// progressSlot 一个协程当前传输的文件，name为空表示空闲
+type progressSlot struct {
+	name string
+	size int64
+	done int64 // 已传输的字节数，不超过size
+}
+
+// progressSample 某个时间点已完成的容量，用于计算当前速度
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// newProgress 按显示方式开始显示传输进度，action为 upload 或 download
+func newProgress(mode string, action string) (*transferProgress, error) {
+	live := false
+	switch mode {
+	case "", ProgressAuto:
+		live = term.IsTerminal(int(os.Stdout.Fd()))
+	case ProgressLive:
+		live = true
+	case ProgressPlain:
+	case ProgressNone:
+		return nil, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("progress mode '%s' is not supported, use %s, %s, %s or %s",
+			mode, ProgressAuto, ProgressLive, ProgressPlain, ProgressNone))
+	}
+	p := startProgress(os.Stdout, live, action)
+	// 日志与进度输出到同一个终端时，先清除进度再输出日志，避免日志与进度交错
+	if live {
+		if out := logger.Default().Output(); out == os.Stderr && term.IsTerminal(int(os.Stderr.Fd())) {
+			p.logOutput = out
+			logger.Default().SetOutput(&progressLogWriter{p: p, w: out})
+		}
+	}
+	return p, nil
+}
+
+// startProgress 开始定期输出进度到w
+func startProgress(w io.Writer, live bool, action string) *transferProgress {
+	p := &transferProgress{
+		w:        w,
+		live:     live,
+		action:   action,
+		start:    time.Now(),
+		scanning: true,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	p.samples = []progressSample{{at: p.start}}
+	go p.loop()
+	return p
+}
+
+// loop 定期刷新进度，直到Stop
+func (p *transferProgress) loop() {
+	defer close(p.stopped)
+	interval := plainInterval
+	if p.live {
+		interval = liveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			p.sample(now)
+			if p.live {
+				p.clear()
+				p.draw()
+			} else {
+				fmt.Fprintln(p.w, p.summary(now))
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Add 文件加入传输队列
+func (p *transferProgress) Add(size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files++
+	p.bytes += size
+}
+
+// ScanDone 文件遍历完成，之后总数不再增加
+func (p *transferProgress) ScanDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scanning = false
+}
+
+// Begin 开始传输文件，返回传输所在的位置，传输中传给Counter，完成时传给Done
+func (p *transferProgress) Begin(name string, size int64) int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, s := range p.slots {
+		if s.name == "" {
+			p.slots[i] = progressSlot{name: name, size: size}
+			return i
+		}
+	}
+	p.slots = append(p.slots, progressSlot{name: name, size: size})
+	return len(p.slots) - 1
+}
+
+// Counter 获取传输中回调新传输字节数的函数，为nil时返回nil
+func (p *transferProgress) Counter(slot int) func(n int64) {
+	if p == nil {
+		return nil
+	}
+	return func(n int64) {
+		p.transferred(slot, n)
+	}
+}
+
+// transferred 计入传输中的文件新传输的字节数，重试或压缩、加密导致传输的字节数与文件大小不同时，最多计入文件的大小
+func (p *transferProgress) transferred(slot int, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if slot >= len(p.slots) {
+		return
+	}
+	s := &p.slots[slot]
+	if rest := s.size - s.done; n > rest {
+		n = rest
+	}
+	if n <= 0 {
+		return
+	}
+	s.done += n
+	p.bytesDone += n
+}
+
+// Done 文件传输结束，计入传输中未计入的容量，err不为nil时计为失败
+func (p *transferProgress) Done(slot int, size int64, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if slot < len(p.slots) {
+		size -= p.slots[slot].done
+		p.slots[slot] = progressSlot{}
+	}
+	p.filesDone++
+	if err != nil {
+		p.filesFailed++
+	}
+	if size > 0 {
+		p.bytesDone += size
+	}
+}
+
+// Stop 停止刷新并输出最终的汇总
+func (p *transferProgress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+	if p.logOutput != nil {
+		logger.Default().SetOutput(p.logOutput)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live {
+		p.clear()
+	}
+	elapsed := time.Since(p.start)
+	rate := float64(0)
+	if elapsed > 0 {
+		rate = float64(p.bytesDone) / elapsed.Seconds()
+	}
+	fmt.Fprintf(p.w, "%s finished: files %d/%d%s, %s in %s, avg %s/s\n", p.action, p.filesDone, p.files,
+		p.failedText(), helper.FormatBytes(p.bytesDone), elapsed.Round(time.Second), helper.FormatBytes(int64(rate)))
+}
+
+// sample 记录当前已完成的容量，只保留时间窗口内的记录
+func (p *transferProgress) sample(now time.Time) {
+	p.samples = append(p.samples, progressSample{at: now, bytes: p.bytesDone})
+	i := 0
+	for i < len(p.samples)-2 && now.Sub(p.samples[i+1].at) >= rateWindow {
+		i++
+	}
+	p.samples = p.samples[i:]
+}
+
+// rate 时间窗口内的平均速度，单位字节每秒
+func (p *transferProgress) rate() float64 {
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	seconds := last.at.Sub(first.at).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / seconds
+}
+
+// summary 获取一行汇总，包含文件数、容量、百分比、当前速度和预计剩余时间
+func (p *transferProgress) summary(now time.Time) string {
+	more := ""
+	if p.scanning {
+		more = "+"
+	}
+	percent := float64(100)
+	if p.bytes > 0 {
+		percent = float64(p.bytesDone) * 100 / float64(p.bytes)
+	}
+	rate := p.rate()
+	eta := "-"
+	if rate > 0 && !p.scanning {
+		eta = time.Duration(float64(p.bytes-p.bytesDone) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s: files %d/%d%s%s, %s/%s%s (%.1f%%), %s/s, elapsed %s, ETA %s", p.action,
+		p.filesDone, p.files, more, p.failedText(), helper.FormatBytes(p.bytesDone), helper.FormatBytes(p.bytes), more,
+		percent, helper.FormatBytes(int64(rate)), now.Sub(p.start).Round(time.Second), eta)
+}
+
+// failedText 有失败的文件时输出失败数
+func (p *transferProgress) failedText() string {
+	if p.filesFailed == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d failed)", p.filesFailed)
+}
+
+// draw 输出汇总行和每个协程当前传输的文件，每行按终端宽度截断，避免换行后无法清除
+func (p *transferProgress) draw() {
+	width := 0
+	if f, ok := p.w.(*os.File); ok {
+		width, _, _ = term.GetSize(int(f.Fd()))
+	}
+	lines := []string{p.summary(time.Now())}
+	for i, s := range p.slots {
+		if s.name == "" {
+			continue
+		}
+		if s.size > 0 {
+			lines = append(lines, fmt.Sprintf("  [%d] %s %d%%", i+1, s.name, s.done*100/s.size))
+		} else {
+			lines = append(lines, fmt.Sprintf("  [%d] %s", i+1, s.name))
+		}
+	}
+	for _, line := range lines {
+		if width > 0 {
+			line = truncateWidth(line, width-1)
+		}
+		fmt.Fprintln(p.w, line)
+	}
+	p.lines = len(lines)
+}
+
+// clear 清除上次输出的进度
+func (p *transferProgress) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.w, "\x1b[%dA\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+// progressLogWriter 实时刷新进度时的日志输出，先清除进度，输出日志后再重新输出进度
+type progressLogWriter struct {
+	p *transferProgress
+	w io.Writer
+}
+
+func (lw *progressLogWriter) Write(b []byte) (int, error) {
+	lw.p.mu.Lock()
+	defer lw.p.mu.Unlock()
+	lines := lw.p.lines
+	lw.p.clear()
+	n, err := lw.w.Write(b)
+	if lines > 0 {
+		lw.p.draw()
+	}
+	return n, err
+}
+
+// truncateWidth 按终端显示宽度截断字符串，中日韩等宽字符按2个宽度计算
+func truncateWidth(s string, width int) string {
+	n := 0
+	for i, r := range s {
+		w := 1
+		if r >= 0x1100 {
+			w = 2
+		}
+		if n+w > width {
+			return s[:i]
+		}
+		n += w
+	}
+	return s
+}
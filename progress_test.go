@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressSummary(t *testing.T) {
+	start := time.Date(2024, 3, 19, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		progress *transferProgress
+		want     string
+	}{
+		{
+			"scanning",
+			&transferProgress{action: "upload", files: 10, filesDone: 2, bytes: 4096, bytesDone: 1024, scanning: true,
+				samples: []progressSample{{start, 0}, {start.Add(10 * time.Second), 1024}}},
+			"upload: files 2/10+, 1.0 KiB/4.0 KiB+ (25.0%), 102 B/s, elapsed 10s, ETA -",
+		},
+		{
+			"eta",
+			&transferProgress{action: "download", files: 10, filesDone: 5, filesFailed: 1, bytes: 4096, bytesDone: 2048,
+				samples: []progressSample{{start, 0}, {start.Add(10 * time.Second), 2048}}},
+			"download: files 5/10 (1 failed), 2.0 KiB/4.0 KiB (50.0%), 204 B/s, elapsed 10s, ETA 10s",
+		},
+		{
+			"no progress",
+			&transferProgress{action: "upload", samples: []progressSample{{start, 0}, {start.Add(10 * time.Second), 0}}},
+			"upload: files 0/0, 0 B/0 B (100.0%), 0 B/s, elapsed 10s, ETA -",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.progress.start = start
+			if got := tt.progress.summary(start.Add(10 * time.Second)); got != tt.want {
+				t.Errorf("summary rsp got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressPlain(t *testing.T) {
+	var buf bytes.Buffer
+	p := startProgress(&buf, false, "upload")
+	p.Add(100)
+	p.Add(200)
+	first := p.Begin("a.txt", 100)
+	second := p.Begin("b.txt", 200)
+	p.Done(first, 100, nil)
+	// 空闲的位置被下一个文件使用
+	if got := p.Begin("c.txt", 0); got != first {
+		t.Errorf("Begin rsp got %d, want %d", got, first)
+	}
+	p.Done(second, 200, errors.New("denied"))
+	p.ScanDone()
+	p.Stop()
+	if got := buf.String(); !strings.HasPrefix(got, "upload finished: files 2/2 (1 failed), 300 B in ") {
+		t.Errorf("Stop rsp got %q", got)
+	}
+}
+
+func TestProgressCounter(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int64
+		want   int64
+	}{
+		{"partial", []int64{30, 20}, 50},
+		{"capped by size", []int64{60, 60}, 100},
+		{"ignore negative", []int64{-10, 10}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &transferProgress{}
+			p.Add(100)
+			slot := p.Begin("a.txt", 100)
+			count := p.Counter(slot)
+			for _, n := range tt.counts {
+				count(n)
+			}
+			if p.bytesDone != tt.want {
+				t.Errorf("Counter rsp got %d, want %d", p.bytesDone, tt.want)
+			}
+			// 完成时只计入传输中未计入的部分
+			p.Done(slot, 100, nil)
+			if p.bytesDone != 100 {
+				t.Errorf("Done rsp got %d, want 100", p.bytesDone)
+			}
+		})
+	}
+}
+
+func TestProgressNone(t *testing.T) {
+	p, err := newProgress(ProgressNone, "upload")
+	if err != nil || p != nil {
+		t.Fatalf("newProgress rsp got %v, %v, want nil", p, err)
+	}
+	// 不显示进度时调用不做任何处理
+	p.Add(1)
+	p.Done(p.Begin("a.txt", 1), 1, nil)
+	if p.Counter(0) != nil {
+		t.Errorf("Counter rsp got func, want nil")
+	}
+	p.Stop()
+	if _, err := newProgress("bar", "upload"); err == nil {
+		t.Errorf("newProgress rsp got nil error, want error")
+	}
+}
+
+func TestTruncateWidth(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		width int
+		want  string
+	}{
+		{"short", "abc", 10, "abc"},
+		{"ascii", "abcdef", 4, "abcd"},
+		{"wide", "照片/a.jpg", 5, "照片/"},
+		{"wide boundary", "照片", 3, "照"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateWidth(tt.s, tt.width); got != tt.want {
+				t.Errorf("truncateWidth rsp got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
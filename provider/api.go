@@ -1,11 +1,321 @@
 package provider
 
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/jorben/osd-tool/config"
+	"github.com/tencentyun/cos-go-sdk-v5"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const COS = "cos" // cos 名称
 const OSS = "oss" // oss 名称
 
+// 服务端加密方式
+const (
+	SseManaged  = "sse"   // 对象存储托管密钥，即SSE-COS、SSE-OSS
+	SseKms      = "kms"   // KMS密钥
+	SseCustomer = "sse-c" // 客户提供的密钥
+)
+
+// 存储类型，上传时转换为各厂商对应的名称
+const (
+	StorageStandard    = "STANDARD"     // 标准存储
+	StorageIA          = "IA"           // 低频存储
+	StorageArchive     = "ARCHIVE"      // 归档存储
+	StorageDeepArchive = "DEEP_ARCHIVE" // 深度归档存储
+)
+
+const MultipartThreshold = 64 * 1024 * 1024 // 超过该大小的文件使用分片上传
+const PartSize = 16 * 1024 * 1024           // 分片上传的分片大小
+
 // A Provider describes an interface for providing files
 type Provider interface {
-	PutFile(key string, filepath string) error
-	GetFile(key string, filepath string) error
-	List(prefix string, marker string) []string
+	PutFile(key string, filepath string, opt *PutOptions) error
+	GetFile(key string, filepath string, opt *GetOptions) error
+	Head(key string, opt *GetOptions) (*Object, error)
+	Delete(key string) error
+	List(prefix string, marker string) ([]Object, error)
+	ListPage(prefix string, maxKeys int) ([]Object, error)
+	Presign(key string, method string, expires time.Duration) (string, error)
+	Restore(key string, opt *RestoreOptions) error
+}
+
+// Object 对象存储中的对象信息
+type Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	StorageClass string
+	Meta         map[string]string // 自定义元数据，key为小写且不带厂商前缀，仅Head时返回
+	Encryption   string            // 服务端加密算法，仅Head时返回
+	KmsKeyId     string            // KMS密钥ID，仅Head时返回
+	// ContentEncoding 对象的Content-Encoding，仅Head时返回
+	ContentEncoding string
+	// Restore 归档对象的取回状态，即 x-cos-restore、x-oss-restore 头部，仅Head时返回
+	Restore string
+}
+
+// PutOptions 上传选项
+type PutOptions struct {
+	Meta       map[string]string            // 自定义元数据
+	Encryption *config.ServerSideEncryption // 服务端加密
+	// ContentEncoding 对象的Content-Encoding，如 gzip
+	ContentEncoding    string
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	Tags               map[string]string // 对象标签
+	Acl                string            // 对象的访问权限，如 private、public-read
+	StorageClass       string            // 存储类型，取值为 STANDARD、IA、ARCHIVE、DEEP_ARCHIVE 或厂商的存储类型名称
+	Progress           func(n int64)     // 上传过程中回调新传输的字节数，用于显示进度，请求重试时会重复计入
+}
+
+// GetOptions 下载选项
+type GetOptions struct {
+	Encryption *config.ServerSideEncryption // 服务端加密，仅SSE-C需要在下载时提供密钥
+	// AcceptEncoding 显式指定Accept-Encoding，指定后按原样获取压缩的内容，不再由http客户端自动解压
+	AcceptEncoding string
+	Progress       func(n int64) // 下载过程中回调新传输的字节数，用于显示进度，请求重试时会重复计入
+}
+
+// RestoreOptions 归档对象的取回选项
+type RestoreOptions struct {
+	Days int    // 取回后可读的天数
+	Tier string // 取回模式 Expedited、Standard、Bulk，为空时使用对象存储的默认模式
+}
+
+// 归档对象的取回状态
+const (
+	RestoreNone     = "none"     // 未取回
+	RestoreOngoing  = "ongoing"  // 取回中
+	RestoreRestored = "restored" // 已取回，可以下载
+)
+
+// ErrRestoreInProgress 对象已在取回中
+var ErrRestoreInProgress = errors.New("restore is already in progress")
+
+// RetryHook 请求失败后重试时调用，storage为 cos、oss，operation为重试的操作，用于统计重试次数
+// 包含cos SDK内部的重试及List出错后的重试
+var RetryHook func(storage string, operation string)
+
+// retried 记录一次重试
+func retried(storage string, operation string) {
+	if RetryHook != nil {
+		RetryHook(storage, operation)
+	}
+}
+
+// RestoreStatus 解析Head返回的取回状态，如 ongoing-request="false", expiry-date="..."
+func RestoreStatus(restore string) string {
+	switch {
+	case restore == "":
+		return RestoreNone
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return RestoreOngoing
+	default:
+		return RestoreRestored
+	}
+}
+
+// NormalizeStorageClass 把厂商的存储类型名称转换为 STANDARD、IA、ARCHIVE、DEEP_ARCHIVE
+func NormalizeStorageClass(class string) string {
+	for _, classes := range []map[string]string{cosStorageClasses, ossStorageClasses} {
+		for k, v := range classes {
+			if strings.EqualFold(v, class) {
+				return k
+			}
+		}
+	}
+	if strings.EqualFold(class, "DeepColdArchive") {
+		return StorageDeepArchive
+	}
+	return strings.ToUpper(class)
+}
+
+// IsArchived 判断存储类型是否为需要取回才能下载的归档、深度归档
+func IsArchived(class string) bool {
+	class = NormalizeStorageClass(class)
+	return class == StorageArchive || class == StorageDeepArchive
+}
+
+// Endpoint 获取存储配置实际访问的域名，未指定endpoint时使用各厂商的默认域名
+// cos为存储桶域名，oss为地域域名，请求时由sdk在域名前加上存储桶名称
+func Endpoint(cfg *config.Profile) string {
+	if cfg.Endpoint != "" {
+		return cfg.Endpoint
+	}
+	if strings.ToLower(cfg.Storage) == OSS {
+		return fmt.Sprintf("https://oss-%s.aliyuncs.com", cfg.Region)
+	}
+	return fmt.Sprintf("https://%s.cos.%s.myqcloud.com", cfg.Bucket, cfg.Region)
+}
+
+// BucketURL 获取请求存储桶时使用的地址
+func BucketURL(cfg *config.Profile) (*url.URL, error) {
+	u, err := url.Parse(Endpoint(cfg))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New(fmt.Sprintf("endpoint '%s' is invalid, it should be like https://host", Endpoint(cfg)))
+	}
+	if strings.ToLower(cfg.Storage) == OSS {
+		u.Host = cfg.Bucket + "." + u.Host
+	}
+	return u, nil
+}
+
+// ErrorCode 获取对象存储返回的http状态码和错误码，不是对象存储返回的错误时均为空值
+func ErrorCode(err error) (int, string) {
+	if e, ok := cos.IsCOSError(err); ok {
+		if e.Response != nil {
+			return e.Response.StatusCode, e.Code
+		}
+		return 0, e.Code
+	}
+	if e, ok := err.(oss.ServiceError); ok {
+		return e.StatusCode, e.Code
+	}
+	return 0, ""
+}
+
+// IsNotFound 判断错误是否为对象不存在
+func IsNotFound(err error) bool {
+	if e, ok := cos.IsCOSError(err); ok {
+		return e.Response != nil && e.Response.StatusCode == http.StatusNotFound
+	}
+	if e, ok := err.(oss.ServiceError); ok {
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// 错误分类，用于按类别统计失败次数
+const (
+	ClassAuth         = "auth"          // 密钥错误或签名不匹配
+	ClassAccessDenied = "access_denied" // 没有权限
+	ClassNotFound     = "not_found"     // 对象或存储桶不存在
+	ClassThrottled    = "throttled"     // 请求过于频繁
+	ClassServer       = "server"        // 对象存储服务端错误
+	ClassClient       = "client"        // 其他请求错误
+	ClassTimeout      = "timeout"       // 请求超时
+	ClassNetwork      = "network"       // 网络错误，如连接失败、域名解析失败
+	ClassLocal        = "local"         // 本地文件读写错误
+	ClassOther        = "other"         // 无法分类的错误
+)
+
+// ErrorClass 获取错误的分类，err为nil时为空
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	if status, code := ErrorCode(err); status != 0 || code != "" {
+		switch {
+		case code == "InvalidAccessKeyId" || code == "SignatureDoesNotMatch" || code == "InvalidSecretId" ||
+			code == "SecurityTokenExpired" || code == "InvalidSecurityToken" || status == http.StatusUnauthorized:
+			return ClassAuth
+		case status == http.StatusForbidden:
+			return ClassAccessDenied
+		case status == http.StatusNotFound:
+			return ClassNotFound
+		case status == http.StatusTooManyRequests || code == "SlowDown" || code == "RequestLimitExceeded":
+			return ClassThrottled
+		case status >= http.StatusInternalServerError:
+			return ClassServer
+		default:
+			return ClassClient
+		}
+	}
+	// fs.PathError 也实现了 net.Error，需要先判断
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return ClassLocal
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ClassTimeout
+		}
+		return ClassNetwork
+	}
+	return ClassOther
+}
+
+// customerKey 获取SSE-C的密钥及其md5，均为base64编码
+func customerKey(sse *config.ServerSideEncryption) (string, string, error) {
+	key, err := base64.StdEncoding.DecodeString(sse.CustomerKey)
+	if err != nil || len(key) != 32 {
+		return "", "", errors.New("sse-c customer_key must be a base64 encoded 32 bytes key")
+	}
+	sum := md5.Sum(key)
+	return sse.CustomerKey, base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// vendorStorageClass 把存储类型转换为厂商的名称，classes为存储类型到厂商名称的映射
+func vendorStorageClass(class string, classes map[string]string) (string, error) {
+	if class == "" {
+		return "", nil
+	}
+	if v, ok := classes[strings.ToUpper(class)]; ok {
+		return v, nil
+	}
+	for _, v := range classes {
+		if strings.EqualFold(v, class) {
+			return v, nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("storage class '%s' is not supported", class))
+}
+
+// checkEncryption 检查服务端加密方式是否支持
+func checkEncryption(sse *config.ServerSideEncryption, supported ...string) error {
+	if sse == nil || sse.Mode == "" {
+		return nil
+	}
+	for _, mode := range supported {
+		if sse.Mode == mode {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("server side encryption mode '%s' is not supported", sse.Mode))
+}
+
+// headerObject 从Head请求的响应头解析对象信息，vendor为厂商的头部前缀，如 X-Cos-
+func headerObject(key string, header http.Header, vendor string) *Object {
+	obj := &Object{
+		Key:          key,
+		ETag:         strings.Trim(header.Get("ETag"), "\""),
+		StorageClass: header.Get(vendor + "Storage-Class"),
+		Meta:         map[string]string{},
+	}
+	obj.Encryption = header.Get(vendor + "Server-Side-Encryption")
+	if obj.Encryption == "" && header.Get(vendor+"Server-Side-Encryption-Customer-Algorithm") != "" {
+		obj.Encryption = "SSE-C " + header.Get(vendor+"Server-Side-Encryption-Customer-Algorithm")
+	}
+	obj.KmsKeyId = header.Get(vendor + "Server-Side-Encryption-Cos-Kms-Key-Id")
+	if obj.KmsKeyId == "" {
+		obj.KmsKeyId = header.Get(vendor + "Server-Side-Encryption-Key-Id")
+	}
+	obj.ContentEncoding = header.Get("Content-Encoding")
+	obj.Restore = header.Get(vendor + "Restore")
+	obj.Size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	obj.LastModified, _ = http.ParseTime(header.Get("Last-Modified"))
+	metaPrefix := vendor + "Meta-"
+	for k := range header {
+		if strings.HasPrefix(k, metaPrefix) {
+			obj.Meta[strings.ToLower(strings.TrimPrefix(k, metaPrefix))] = header.Get(k)
+		}
+	}
+	return obj
 }
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/tencentyun/cos-go-sdk-v5"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// timeoutError 测试用的超时错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClass(t *testing.T) {
+	cosError := func(status int, code string) error {
+		return &cos.ErrorResponse{Response: &http.Response{StatusCode: status}, Code: code}
+	}
+	_, pathErr := os.Open("/not/exists/osd-tool")
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"cos signature", cosError(http.StatusForbidden, "SignatureDoesNotMatch"), ClassAuth},
+		{"cos access denied", cosError(http.StatusForbidden, "AccessDenied"), ClassAccessDenied},
+		{"cos not found", cosError(http.StatusNotFound, "NoSuchKey"), ClassNotFound},
+		{"cos slow down", cosError(http.StatusServiceUnavailable, "SlowDown"), ClassThrottled},
+		{"oss server", oss.ServiceError{StatusCode: http.StatusInternalServerError, Code: "InternalError"}, ClassServer},
+		{"oss bad request", oss.ServiceError{StatusCode: http.StatusBadRequest, Code: "InvalidArgument"}, ClassClient},
+		{"timeout", &url.Error{Op: "Put", URL: "https://example.com", Err: timeoutError{}}, ClassTimeout},
+		{"network", fmt.Errorf("put: %w", &url.Error{Op: "Put", URL: "https://example.com", Err: errors.New("refused")}),
+			ClassNetwork},
+		{"local", pathErr, ClassLocal},
+		{"other", errors.New("unknown"), ClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorClass(tt.err); got != tt.want {
+				t.Errorf("ErrorClass rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/logger"
+	"io"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// refreshAhead 凭证在过期前多久刷新
+const refreshAhead = 5 * time.Minute
+
+// Credentials 访问凭证，临时凭证带有SessionToken和过期时间
+type Credentials struct {
+	SecretId     string
+	SecretKey    string
+	SessionToken string
+	Expiration   time.Time
+}
+
+// CredentialProvider 凭证提供者，每次请求前获取凭证，可在过期前自动刷新
+type CredentialProvider interface {
+	Retrieve() (*Credentials, error)
+}
+
+// NewCredentialProvider 按配置获取凭证提供者
+// 配置了credential_process时执行外部命令获取，配置了credential_endpoint时从HTTP地址获取，否则使用固定密钥
+func NewCredentialProvider(cfg *config.Profile) CredentialProvider {
+	switch {
+	case cfg.CredentialProcess != "":
+		return &refreshingCredentials{fetch: func() (*Credentials, error) {
+			return processCredentials(cfg.CredentialProcess)
+		}}
+	case cfg.CredentialEndpoint != "":
+		return &refreshingCredentials{fetch: func() (*Credentials, error) {
+			return endpointCredentials(cfg.CredentialEndpoint)
+		}}
+	default:
+		return &staticCredentials{Credentials{
+			SecretId:     cfg.SecretId,
+			SecretKey:    cfg.SecretKey,
+			SessionToken: cfg.SessionToken,
+		}}
+	}
+}
+
+// staticCredentials 固定的凭证
+type staticCredentials struct {
+	creds Credentials
+}
+
+func (s *staticCredentials) Retrieve() (*Credentials, error) {
+	return &s.creds, nil
+}
+
+// refreshingCredentials 缓存凭证，在过期前重新获取
+type refreshingCredentials struct {
+	fetch func() (*Credentials, error)
+	creds *Credentials
+	mu    sync.Mutex
+}
+
+func (s *refreshingCredentials) Retrieve() (*Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creds != nil && (s.creds.Expiration.IsZero() || time.Now().Add(refreshAhead).Before(s.creds.Expiration)) {
+		return s.creds, nil
+	}
+	creds, err := s.fetch()
+	if err != nil {
+		// 刷新失败但旧凭证未过期时继续使用
+		if s.creds != nil && time.Now().Before(s.creds.Expiration) {
+			logger.Warn("refresh credentials error, using cached credentials",
+				"expiration", s.creds.Expiration, "error", err)
+			return s.creds, nil
+		}
+		return nil, err
+	}
+	s.creds = creds
+	return creds, nil
+}
+
+// credentialOutput 外部命令和HTTP地址返回的凭证格式，兼容腾讯云、阿里云和AWS的字段名称
+type credentialOutput struct {
+	SecretId        string `json:"SecretId"`
+	TmpSecretId     string `json:"TmpSecretId"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretKey       string `json:"SecretKey"`
+	TmpSecretKey    string `json:"TmpSecretKey"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	SecurityToken   string `json:"SecurityToken"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+	ExpiredTime     int64  `json:"ExpiredTime"`
+}
+
+// parseCredentials 解析JSON格式的凭证
+func parseCredentials(buf []byte) (*Credentials, error) {
+	out := credentialOutput{}
+	if err := json.Unmarshal(buf, &out); err != nil {
+		return nil, err
+	}
+	creds := &Credentials{
+		SecretId:     firstNonEmpty(out.SecretId, out.TmpSecretId, out.AccessKeyId),
+		SecretKey:    firstNonEmpty(out.SecretKey, out.TmpSecretKey, out.AccessKeySecret, out.SecretAccessKey),
+		SessionToken: firstNonEmpty(out.SessionToken, out.SecurityToken, out.Token),
+	}
+	if creds.SecretId == "" || creds.SecretKey == "" {
+		return nil, errors.New("credentials output missing secret id or secret key")
+	}
+	if out.Expiration != "" {
+		expiration, err := time.Parse(time.RFC3339, out.Expiration)
+		if err != nil {
+			return nil, err
+		}
+		creds.Expiration = expiration
+	} else if out.ExpiredTime > 0 {
+		creds.Expiration = time.Unix(out.ExpiredTime, 0)
+	}
+	return creds, nil
+}
+
+// processCredentials 执行外部命令，从标准输出读取凭证
+func processCredentials(command string) (*Credentials, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	buf, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("credential_process error:%s, stderr:%s", err.Error(), stderr.String()))
+	}
+	return parseCredentials(buf)
+}
+
+// endpointCredentials 从HTTP地址获取凭证
+func endpointCredentials(endpoint string) (*Credentials, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("credential_endpoint response status:%s", resp.Status))
+	}
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseCredentials(buf)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"errors"
+	"github.com/jorben/osd-tool/config"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCredentials(t *testing.T) {
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		output  string
+		want    Credentials
+		wantErr bool
+	}{
+		{"qcloud", `{"TmpSecretId":"id","TmpSecretKey":"key","Token":"token","ExpiredTime":1893553445}`,
+			Credentials{SecretId: "id", SecretKey: "key", SessionToken: "token", Expiration: expiration}, false},
+		{"aliyun", `{"AccessKeyId":"id","AccessKeySecret":"key","SecurityToken":"token","Expiration":"2030-01-02T03:04:05Z"}`,
+			Credentials{SecretId: "id", SecretKey: "key", SessionToken: "token", Expiration: expiration}, false},
+		{"aws", `{"AccessKeyId":"id","SecretAccessKey":"key","SessionToken":"token"}`,
+			Credentials{SecretId: "id", SecretKey: "key", SessionToken: "token"}, false},
+		{"prefer SecretId", `{"SecretId":"a","TmpSecretId":"b","SecretKey":"key"}`,
+			Credentials{SecretId: "a", SecretKey: "key"}, false},
+		{"missing secret key", `{"SecretId":"id"}`, Credentials{}, true},
+		{"invalid expiration", `{"SecretId":"id","SecretKey":"key","Expiration":"tomorrow"}`, Credentials{}, true},
+		{"invalid json", `SecretId=id`, Credentials{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCredentials([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCredentials error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.SecretId != tt.want.SecretId || got.SecretKey != tt.want.SecretKey ||
+				got.SessionToken != tt.want.SessionToken || !got.Expiration.Equal(tt.want.Expiration) {
+				t.Errorf("parseCredentials rsp got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential_process test uses sh")
+	}
+	tests := []struct {
+		name    string
+		command string
+		wantErr string
+	}{
+		{"success", `echo '{"SecretId":"id","SecretKey":"key"}'`, ""},
+		{"exit with stderr", `echo "token expired" >&2; exit 1`, "stderr:token expired"},
+		{"invalid output", `echo not json`, "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := processCredentials(tt.command)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("processCredentials error got %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || creds.SecretId != "id" || creds.SecretKey != "key" {
+				t.Errorf("processCredentials rsp got %+v %v, want id and key", creds, err)
+			}
+		})
+	}
+}
+
+func TestEndpointCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"success", http.StatusOK, `{"AccessKeyId":"id","AccessKeySecret":"key"}`, ""},
+		{"error status", http.StatusForbidden, `{}`, "403 Forbidden"},
+		{"missing key", http.StatusOK, `{"AccessKeyId":"id"}`, "missing secret id or secret key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer ts.Close()
+			creds, err := endpointCredentials(ts.URL)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("endpointCredentials error got %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || creds.SecretId != "id" || creds.SecretKey != "key" {
+				t.Errorf("endpointCredentials rsp got %+v %v, want id and key", creds, err)
+			}
+		})
+	}
+}
+
+func TestRefreshingCredentials(t *testing.T) {
+	fetchErr := errors.New("fetch error")
+	tests := []struct {
+		name       string
+		expiration time.Duration // 首次获取的凭证距离过期的时间，为0时不过期
+		refreshErr error         // 再次获取时的错误
+		wantFetch  int
+		wantErr    bool
+	}{
+		{"no expiration cached", 0, nil, 1, false},
+		{"valid cached", time.Hour, nil, 1, false},
+		{"refresh before expiration", refreshAhead - time.Minute, nil, 2, false},
+		{"refresh error uses cached", refreshAhead - time.Minute, fetchErr, 2, false},
+		{"refresh error after expiration", -time.Minute, fetchErr, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetched := 0
+			p := &refreshingCredentials{fetch: func() (*Credentials, error) {
+				fetched++
+				if fetched == 1 {
+					creds := &Credentials{SecretId: "id", SecretKey: "key"}
+					if tt.expiration != 0 {
+						creds.Expiration = time.Now().Add(tt.expiration)
+					}
+					return creds, nil
+				}
+				if tt.refreshErr != nil {
+					return nil, tt.refreshErr
+				}
+				return &Credentials{SecretId: "new", SecretKey: "key", Expiration: time.Now().Add(time.Hour)}, nil
+			}}
+			if _, err := p.Retrieve(); err != nil {
+				t.Fatal(err)
+			}
+			creds, err := p.Retrieve()
+			if (err != nil) != tt.wantErr || fetched != tt.wantFetch {
+				t.Errorf("Retrieve rsp got %+v %v, fetched %d, want fetched %d, wantErr %v",
+					creds, err, fetched, tt.wantFetch, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewCredentialProvider(t *testing.T) {
+	p := NewCredentialProvider(&config.Profile{Osd: config.Osd{SecretId: "id", SecretKey: "key", SessionToken: "token"}})
+	creds, err := p.Retrieve()
+	if err != nil || creds.SecretId != "id" || creds.SecretKey != "key" || creds.SessionToken != "token" {
+		t.Errorf("Retrieve rsp got %+v %v, want static credentials", creds, err)
+	}
+	if _, ok := NewCredentialProvider(&config.Profile{Osd: config.Osd{CredentialProcess: "true"}}).(*refreshingCredentials); !ok {
+		t.Errorf("NewCredentialProvider rsp got static, want refreshing for credential_process")
+	}
+}
+
+func TestOssCredentialsError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential_process test uses sh")
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	s := NewAliyunOss(&config.Profile{Storage: OSS, Osd: config.Osd{Bucket: "bucket", Endpoint: ts.URL,
+		CredentialProcess: "exit 1"}})
+	// 获取凭证失败时返回错误，不以空凭证发出请求
+	if _, err := s.Head("a.txt", nil); err == nil || !strings.Contains(err.Error(), "retrieve credentials error") {
+		t.Errorf("Head error got %v, want retrieve credentials error", err)
+	}
+	if err := s.Delete("a.txt"); err == nil {
+		t.Errorf("Delete error got nil, want retrieve credentials error")
+	}
+	if requests != 0 {
+		t.Errorf("requests got %d, want 0", requests)
+	}
+}
@@ -1,79 +1,336 @@
 package provider
 
 import (
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/jorben/osd-tool/config"
-	"log"
+	"github.com/jorben/osd-tool/logger"
+	"os"
 	"strings"
 	"time"
 )
 
 type AliyunOss struct {
-	ossBucket *oss.Bucket
+	ossBucket   *oss.Bucket
+	credentials CredentialProvider
 }
 
 // NewAliyunOss 实例化ossImpl
-func NewAliyunOss(cfg *config.TransferConfig) *AliyunOss {
+func NewAliyunOss(cfg *config.Profile) *AliyunOss {
 
-	client, err := oss.New(
-		fmt.Sprintf("https://oss-%s.aliyuncs.com", cfg.Osd.Region), cfg.Osd.SecretId, cfg.Osd.SecretKey)
+	// 未指定endpoint时使用默认的地域域名
+	credentials := NewCredentialProvider(cfg)
+	client, err := oss.New(Endpoint(cfg), cfg.SecretId, cfg.SecretKey,
+		oss.SetCredentialsProvider(&ossCredentialsProvider{credentials: credentials}))
 	if err != nil {
-		log.Fatalln("new oss error:", err.Error())
+		logger.Error("new oss error", "storage", OSS, "error", err)
+		os.Exit(1)
 	}
 
-	bucket, err := client.Bucket(cfg.Osd.Bucket)
+	bucket, err := client.Bucket(cfg.Bucket)
 	if err != nil {
-		log.Fatalln("new bucket error:", err.Error())
+		logger.Error("new bucket error", "storage", OSS, "bucket", cfg.Bucket, "error", err)
+		os.Exit(1)
 	}
 
 	return &AliyunOss{
-		ossBucket: bucket,
+		ossBucket:   bucket,
+		credentials: credentials,
 	}
 }
 
-func (s *AliyunOss) GetFile(key string, filepath string) error {
+// checkCredentials 请求前获取凭证，oss SDK的凭证接口无法返回错误，获取失败时会以空凭证发出请求，
+// 因此先获取一次，失败时直接返回错误，成功后SDK签名时使用缓存的凭证
+func (s *AliyunOss) checkCredentials() error {
+	if _, err := s.credentials.Retrieve(); err != nil {
+		return errors.New(fmt.Sprintf("retrieve credentials error, %s", err.Error()))
+	}
+	return nil
+}
+
+// ossCredentialsProvider 每次请求前获取最新的凭证，支持临时密钥过期后刷新
+type ossCredentialsProvider struct {
+	credentials CredentialProvider
+}
 
-	err := s.ossBucket.GetObjectToFile(key, filepath, nil)
+func (p *ossCredentialsProvider) GetCredentials() oss.Credentials {
+	creds, err := p.credentials.Retrieve()
 	if err != nil {
-		log.Printf("GetObjectToFile error, file:%s, error:%s", key, err.Error())
+		logger.Error("Retrieve credentials error", "storage", OSS, "error", err)
+		return &ossCredentials{}
+	}
+	return &ossCredentials{*creds}
+}
+
+// ossCredentials 实现oss.Credentials接口
+type ossCredentials struct {
+	Credentials
+}
+
+func (c *ossCredentials) GetAccessKeyID() string {
+	return c.SecretId
+}
+
+func (c *ossCredentials) GetAccessKeySecret() string {
+	return c.SecretKey
+}
+
+func (c *ossCredentials) GetSecurityToken() string {
+	return c.SessionToken
+}
+
+func (s *AliyunOss) GetFile(key string, filepath string, opt *GetOptions) error {
+	if opt != nil {
+		if err := checkEncryption(opt.Encryption, SseManaged, SseKms); err != nil {
+			logger.Error("GetObjectToFile error", "storage", OSS, "file", key, "error", err)
+			return err
+		}
+	}
+
+	if err := s.checkCredentials(); err != nil {
+		logger.Error("GetObjectToFile error", "storage", OSS, "file", key, "error", err)
+		return err
+	}
+	var options []oss.Option
+	if opt != nil && opt.AcceptEncoding != "" {
+		options = append(options, oss.AcceptEncoding(opt.AcceptEncoding))
+	}
+	if opt != nil && opt.Progress != nil {
+		options = append(options, oss.Progress(ossProgress(opt.Progress)))
+	}
+	err := s.ossBucket.GetObjectToFile(key, filepath, options...)
+	if err != nil {
+		logger.Error("GetObjectToFile error", "storage", OSS, "file", key, "error", err)
 	}
 	return err
 }
 
-func (s *AliyunOss) PutFile(key string, filepath string) error {
-	err := s.ossBucket.PutObjectFromFile(key, filepath, nil)
+func (s *AliyunOss) PutFile(key string, filepath string, opt *PutOptions) error {
+	options, err := ossPutOptions(opt)
+	if err == nil {
+		err = s.checkCredentials()
+	}
+	if err != nil {
+		logger.Error("PutObjectFromFile error", "storage", OSS, "file", filepath, "error", err)
+		return err
+	}
+	info, err := os.Stat(filepath)
+	if err != nil {
+		logger.Error("PutObjectFromFile error", "storage", OSS, "file", filepath, "error", err)
+		return err
+	}
+
+	// 大文件使用分片上传
+	if info.Size() >= MultipartThreshold {
+		err = s.ossBucket.UploadFile(key, filepath, PartSize, options...)
+		if err != nil {
+			logger.Error("UploadFile error", "storage", OSS, "file", filepath, "error", err)
+		}
+		return err
+	}
+
+	err = s.ossBucket.PutObjectFromFile(key, filepath, options...)
+	if err != nil {
+		logger.Error("PutObjectFromFile error", "storage", OSS, "file", filepath, "error", err)
+	}
+	return err
+}
+
+// ossStorageClasses oss的存储类型名称
+var ossStorageClasses = map[string]string{
+	StorageStandard:    string(oss.StorageStandard),
+	StorageIA:          string(oss.StorageIA),
+	StorageArchive:     string(oss.StorageArchive),
+	StorageDeepArchive: string(oss.StorageColdArchive),
+}
+
+// ossPutOptions 把上传选项转换为oss的请求参数，oss不支持SSE-C
+func ossPutOptions(opt *PutOptions) ([]oss.Option, error) {
+	if opt == nil {
+		return nil, nil
+	}
+	var options []oss.Option
+	for k, v := range opt.Meta {
+		options = append(options, oss.Meta(k, v))
+	}
+	if opt.ContentEncoding != "" {
+		options = append(options, oss.ContentEncoding(opt.ContentEncoding))
+	}
+	if opt.ContentType != "" {
+		options = append(options, oss.ContentType(opt.ContentType))
+	}
+	if opt.CacheControl != "" {
+		options = append(options, oss.CacheControl(opt.CacheControl))
+	}
+	if opt.ContentDisposition != "" {
+		options = append(options, oss.ContentDisposition(opt.ContentDisposition))
+	}
+	if opt.Acl != "" {
+		options = append(options, oss.ObjectACL(oss.ACLType(opt.Acl)))
+	}
+	storageClass, err := vendorStorageClass(opt.StorageClass, ossStorageClasses)
+	if err != nil {
+		return nil, err
+	}
+	if storageClass != "" {
+		options = append(options, oss.ObjectStorageClass(oss.StorageClassType(storageClass)))
+	}
+	if len(opt.Tags) > 0 {
+		tagging := oss.Tagging{}
+		for k, v := range opt.Tags {
+			tagging.Tags = append(tagging.Tags, oss.Tag{Key: k, Value: v})
+		}
+		options = append(options, oss.SetTagging(tagging))
+	}
+
+	if err := checkEncryption(opt.Encryption, SseManaged, SseKms); err != nil {
+		return nil, err
+	}
+	if opt.Encryption != nil {
+		switch opt.Encryption.Mode {
+		case SseManaged:
+			options = append(options, oss.ServerSideEncryption("AES256"))
+		case SseKms:
+			options = append(options, oss.ServerSideEncryption("KMS"))
+			if opt.Encryption.KmsKeyId != "" {
+				options = append(options, oss.ServerSideEncryptionKeyID(opt.Encryption.KmsKeyId))
+			}
+		}
+	}
+	if opt.Progress != nil {
+		options = append(options, oss.Progress(ossProgress(opt.Progress)))
+	}
+	return options, nil
+}
+
+// ossProgress 把oss的传输进度事件转换为新传输字节数的回调
+type ossProgress func(n int64)
+
+func (f ossProgress) ProgressChanged(event *oss.ProgressEvent) {
+	if event.EventType == oss.TransferDataEvent && event.RwBytes > 0 {
+		f(event.RwBytes)
+	}
+}
+
+func (s *AliyunOss) Head(key string, opt *GetOptions) (*Object, error) {
+	if err := s.checkCredentials(); err != nil {
+		logger.Error("GetObjectDetailedMeta error", "storage", OSS, "file", key, "error", err)
+		return nil, err
+	}
+	header, err := s.ossBucket.GetObjectDetailedMeta(key)
 	if err != nil {
-		log.Printf("PutObjectFromFile error, file:%s, error:%s", filepath, err.Error())
+		logger.Error("GetObjectDetailedMeta error", "storage", OSS, "file", key, "error", err)
+		return nil, err
+	}
+	return headerObject(key, header, "X-Oss-"), nil
+}
+
+func (s *AliyunOss) Delete(key string) error {
+	err := s.checkCredentials()
+	if err == nil {
+		err = s.ossBucket.DeleteObject(key)
+	}
+	if err != nil {
+		logger.Error("DeleteObject error", "storage", OSS, "file", key, "error", err)
 	}
 	return err
 }
 
-func (s *AliyunOss) List(prefix string, marker string) (list []string) {
+// List 列出前缀下marker之后的全部对象，每页出错时重试，仍失败时返回错误，不返回部分结果
+func (s *AliyunOss) List(prefix string, marker string) ([]Object, error) {
+	var list []Object
 	prefix = strings.TrimLeft(prefix, "/")
 	m := oss.Marker(marker)
 	i := 0
 	maxRetry := 3
 	isTruncated := true
 	for isTruncated {
-		v, err := s.ossBucket.ListObjects(oss.MaxKeys(10), m, oss.Prefix(prefix))
+		var v oss.ListObjectsResult
+		err := s.checkCredentials()
+		if err == nil {
+			v, err = s.ossBucket.ListObjects(oss.MaxKeys(10), m, oss.Prefix(prefix))
+		}
 		if err != nil {
 			if i < maxRetry {
 				i++
+				retried(OSS, "List")
 				time.Sleep(time.Second)
 				continue
 			} else {
-				log.Printf("ListObjects error:%s", err.Error())
-				return list
+				logger.Error("ListObjects error", "storage", OSS, "error", err)
+				return nil, err
 			}
 		}
 		for _, c := range v.Objects {
-			list = append(list, c.Key)
+			list = append(list, Object{
+				Key:          c.Key,
+				Size:         c.Size,
+				ETag:         strings.Trim(c.ETag, "\""),
+				LastModified: c.LastModified,
+				StorageClass: c.StorageClass,
+			})
 		}
 		// 获取成功 重置重试次数
 		i = 0
 		m = oss.Marker(v.NextMarker)
 		isTruncated = v.IsTruncated
 	}
-	return list
+	return list, nil
+}
+
+// ListPage 获取前缀下的第一页对象，不重试，出错时返回错误
+func (s *AliyunOss) ListPage(prefix string, maxKeys int) ([]Object, error) {
+	if err := s.checkCredentials(); err != nil {
+		logger.Error("ListObjects error", "storage", OSS, "error", err)
+		return nil, err
+	}
+	v, err := s.ossBucket.ListObjects(oss.MaxKeys(maxKeys), oss.Prefix(strings.TrimLeft(prefix, "/")))
+	if err != nil {
+		logger.Error("ListObjects error", "storage", OSS, "error", err)
+		return nil, err
+	}
+	var list []Object
+	for _, c := range v.Objects {
+		list = append(list, Object{
+			Key:          c.Key,
+			Size:         c.Size,
+			ETag:         strings.Trim(c.ETag, "\""),
+			LastModified: c.LastModified,
+			StorageClass: c.StorageClass,
+		})
+	}
+	return list, nil
+}
+
+func (s *AliyunOss) Presign(key string, method string, expires time.Duration) (string, error) {
+	if err := s.checkCredentials(); err != nil {
+		logger.Error("SignURL error", "storage", OSS, "file", key, "error", err)
+		return "", err
+	}
+	u, err := s.ossBucket.SignURL(key, oss.HTTPMethod(strings.ToUpper(method)), int64(expires.Seconds()))
+	if err != nil {
+		logger.Error("SignURL error", "storage", OSS, "file", key, "error", err)
+	}
+	return u, err
+}
+
+func (s *AliyunOss) Restore(key string, opt *RestoreOptions) error {
+	// 归档类型不支持指定取回模式，未指定时不带JobParameters
+	restoreConfig := oss.RestoreConfiguration{Days: int32(opt.Days), Tier: opt.Tier}
+	buf, err := xml.Marshal(restoreConfig)
+	if err != nil {
+		return err
+	}
+	if err = s.checkCredentials(); err == nil {
+		err = s.ossBucket.RestoreObjectXML(key, string(buf))
+	}
+	if e, ok := err.(oss.ServiceError); ok && e.Code == "RestoreAlreadyInProgress" {
+		return ErrRestoreInProgress
+	}
+	if err != nil {
+		logger.Error("RestoreObjectXML error", "storage", OSS, "file", key, "error", err)
+	}
+	return err
 }
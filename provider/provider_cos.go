@@ -2,61 +2,251 @@ package provider
 
 import (
 	"context"
-	"fmt"
 	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/logger"
 	"github.com/tencentyun/cos-go-sdk-v5"
-	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 // QcloudCos
 type QcloudCos struct {
-	cosClient *cos.Client
+	cosClient   *cos.Client
+	credentials CredentialProvider
 }
 
 // NewQcloudCos 实例化cosImpl
-func NewQcloudCos(cfg *config.TransferConfig) *QcloudCos {
+func NewQcloudCos(cfg *config.Profile) *QcloudCos {
 
-	u, _ := url.Parse(fmt.Sprintf(
-		"https://%s.cos.%s.myqcloud.com",
-		cfg.Osd.Bucket,
-		cfg.Osd.Region,
-	))
+	// 未指定endpoint时使用默认的存储桶域名
+	u, _ := url.Parse(Endpoint(cfg))
+	credentials := NewCredentialProvider(cfg)
 
 	return &QcloudCos{
 		cosClient: cos.NewClient(
 			&cos.BaseURL{BucketURL: u},
 			&http.Client{
-				Timeout: time.Second * time.Duration(cfg.Osd.Timeout),
-				Transport: &cos.AuthorizationTransport{
-					SecretID:  cfg.Osd.SecretId,
-					SecretKey: cfg.Osd.SecretKey,
-				},
+				Timeout:   time.Second * time.Duration(cfg.Timeout),
+				Transport: &cosCredentialTransport{credentials: credentials},
 			},
 		),
+		credentials: credentials,
 	}
 }
 
-func (s *QcloudCos) GetFile(key string, filepath string) error {
-	_, err := s.cosClient.Object.GetToFile(context.Background(), key, filepath, nil)
+// cosCredentialTransport 每次请求前获取最新的凭证进行签名，支持临时密钥过期后刷新
+type cosCredentialTransport struct {
+	credentials CredentialProvider
+}
+
+func (t *cosCredentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if a, ok := req.Context().Value(cosAttemptsKey{}).(*cosAttempts); ok {
+		a.send(req)
+	}
+	creds, err := t.credentials.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	transport := &cos.AuthorizationTransport{
+		SecretID:     creds.SecretId,
+		SecretKey:    creds.SecretKey,
+		SessionToken: creds.SessionToken,
+	}
+	return transport.RoundTrip(req)
+}
+
+// cosAttemptsKey 在context中保存cosAttempts的键
+type cosAttemptsKey struct{}
+
+// cosAttempts 一次操作已发出的请求，SDK出错后会重新发出相同的请求，据此统计SDK内部的重试
+type cosAttempts struct {
+	mu        sync.Mutex
+	operation string
+	sent      map[string]bool
+}
+
+// operationContext 获取记录operation已发出请求的context，每次调用SDK时使用新的context
+func operationContext(operation string) context.Context {
+	return context.WithValue(context.Background(), cosAttemptsKey{},
+		&cosAttempts{operation: operation, sent: map[string]bool{}})
+}
+
+// send 记录一次请求，方法和地址相同的请求已发出过时记为一次重试，分片上传的各分片地址不同，不会误记
+func (a *cosAttempts) send(req *http.Request) {
+	id := req.Method + " " + req.URL.String()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sent[id] {
+		retried(COS, a.operation)
+		return
+	}
+	a.sent[id] = true
+}
+
+func (s *QcloudCos) GetFile(key string, filepath string, opt *GetOptions) error {
+	getOpt := &cos.ObjectGetOptions{}
+	if opt != nil && opt.Encryption != nil && opt.Encryption.Mode == SseCustomer {
+		key, keyMd5, err := customerKey(opt.Encryption)
+		if err != nil {
+			return err
+		}
+		getOpt.XCosSSECustomerAglo, getOpt.XCosSSECustomerKey, getOpt.XCosSSECustomerKeyMD5 = "AES256", key, keyMd5
+	}
+	if opt != nil && opt.AcceptEncoding != "" {
+		getOpt.XOptionHeader = &http.Header{}
+		getOpt.XOptionHeader.Set("Accept-Encoding", opt.AcceptEncoding)
+	}
+	if opt != nil && opt.Progress != nil {
+		getOpt.Listener = cosProgress(opt.Progress)
+	}
+	_, err := s.cosClient.Object.GetToFile(operationContext("GetFile"), key, filepath, getOpt)
 	if err != nil {
-		log.Printf("GetToFile error, file:%s, error:%s", key, err.Error())
+		logger.Error("GetToFile error", "storage", COS, "file", key, "error", err)
 	}
 	return err
 }
 
-func (s *QcloudCos) PutFile(key string, filepath string) error {
-	_, err := s.cosClient.Object.PutFromFile(context.Background(), key, filepath, nil)
+func (s *QcloudCos) PutFile(key string, filepath string, opt *PutOptions) error {
+	putOpt, err := cosPutOptions(opt)
+	if err != nil {
+		logger.Error("PutFromFile error", "storage", COS, "file", filepath, "error", err)
+		return err
+	}
+	info, err := os.Stat(filepath)
+	if err != nil {
+		logger.Error("PutFromFile error", "storage", COS, "file", filepath, "error", err)
+		return err
+	}
+
+	// 大文件使用分片上传
+	if info.Size() >= MultipartThreshold {
+		_, _, err = s.cosClient.Object.Upload(operationContext("PutFile"), key, filepath, &cos.MultiUploadOptions{
+			OptIni: &cos.InitiateMultipartUploadOptions{
+				ACLHeaderOptions:       putOpt.ACLHeaderOptions,
+				ObjectPutHeaderOptions: putOpt.ObjectPutHeaderOptions,
+			},
+			PartSize: PartSize / 1024 / 1024,
+		})
+		if err != nil {
+			logger.Error("Upload error", "storage", COS, "file", filepath, "error", err)
+		}
+		return err
+	}
+
+	_, err = s.cosClient.Object.PutFromFile(operationContext("PutFile"), key, filepath, putOpt)
+	if err != nil {
+		logger.Error("PutFromFile error", "storage", COS, "file", filepath, "error", err)
+	}
+	return err
+}
+
+// cosStorageClasses cos的存储类型名称
+var cosStorageClasses = map[string]string{
+	StorageStandard:    "STANDARD",
+	StorageIA:          "STANDARD_IA",
+	StorageArchive:     "ARCHIVE",
+	StorageDeepArchive: "DEEP_ARCHIVE",
+}
+
+// cosPutOptions 把上传选项转换为cos的请求参数
+func cosPutOptions(opt *PutOptions) (*cos.ObjectPutOptions, error) {
+	header := &cos.ObjectPutHeaderOptions{XOptionHeader: &http.Header{}}
+	putOpt := &cos.ObjectPutOptions{ObjectPutHeaderOptions: header, ACLHeaderOptions: &cos.ACLHeaderOptions{}}
+	if opt == nil {
+		return putOpt, nil
+	}
+	if len(opt.Meta) > 0 {
+		meta := http.Header{}
+		for k, v := range opt.Meta {
+			meta.Set("x-cos-meta-"+k, v)
+		}
+		header.XCosMetaXXX = &meta
+	}
+	header.ContentEncoding = opt.ContentEncoding
+	header.ContentType = opt.ContentType
+	header.CacheControl = opt.CacheControl
+	header.ContentDisposition = opt.ContentDisposition
+	putOpt.XCosACL = opt.Acl
+	storageClass, err := vendorStorageClass(opt.StorageClass, cosStorageClasses)
+	if err != nil {
+		return nil, err
+	}
+	header.XCosStorageClass = storageClass
+	if opt.Progress != nil {
+		header.Listener = cosProgress(opt.Progress)
+	}
+	if len(opt.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range opt.Tags {
+			tags.Set(k, v)
+		}
+		header.XOptionHeader.Set("x-cos-tagging", tags.Encode())
+	}
+
+	if err := checkEncryption(opt.Encryption, SseManaged, SseKms, SseCustomer); err != nil {
+		return nil, err
+	}
+	if opt.Encryption != nil {
+		switch opt.Encryption.Mode {
+		case SseManaged:
+			header.XCosServerSideEncryption = "AES256"
+		case SseKms:
+			header.XCosServerSideEncryption = "cos/kms"
+			if opt.Encryption.KmsKeyId != "" {
+				header.XOptionHeader.Set("x-cos-server-side-encryption-cos-kms-key-id", opt.Encryption.KmsKeyId)
+			}
+		case SseCustomer:
+			key, keyMd5, err := customerKey(opt.Encryption)
+			if err != nil {
+				return nil, err
+			}
+			header.XCosSSECustomerAglo, header.XCosSSECustomerKey, header.XCosSSECustomerKeyMD5 = "AES256", key, keyMd5
+		}
+	}
+	return putOpt, nil
+}
+
+// cosProgress 把cos的传输进度事件转换为新传输字节数的回调
+type cosProgress func(n int64)
+
+func (f cosProgress) ProgressChangedCallback(event *cos.ProgressEvent) {
+	if event.EventType == cos.ProgressDataEvent && event.RWBytes > 0 {
+		f(event.RWBytes)
+	}
+}
+
+func (s *QcloudCos) Head(key string, opt *GetOptions) (*Object, error) {
+	headOpt := &cos.ObjectHeadOptions{}
+	if opt != nil && opt.Encryption != nil && opt.Encryption.Mode == SseCustomer {
+		key, keyMd5, err := customerKey(opt.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		headOpt.XCosSSECustomerAglo, headOpt.XCosSSECustomerKey, headOpt.XCosSSECustomerKeyMD5 = "AES256", key, keyMd5
+	}
+	resp, err := s.cosClient.Object.Head(operationContext("Head"), key, headOpt)
+	if err != nil {
+		logger.Error("Head error", "storage", COS, "file", key, "error", err)
+		return nil, err
+	}
+	return headerObject(key, resp.Header, "X-Cos-"), nil
+}
+
+func (s *QcloudCos) Delete(key string) error {
+	_, err := s.cosClient.Object.Delete(operationContext("Delete"), key)
 	if err != nil {
-		log.Printf("PutFromFile error, file:%s, error:%s", filepath, err.Error())
+		logger.Error("Delete error", "storage", COS, "file", key, "error", err)
 	}
 	return err
 }
 
-func (s *QcloudCos) List(prefix string, marker string) (list []string) {
+// List 列出前缀下marker之后的全部对象，每页出错时重试，仍失败时返回错误，不返回部分结果
+func (s *QcloudCos) List(prefix string, marker string) ([]Object, error) {
+	var list []Object
 	prefix = strings.TrimLeft(prefix, "/")
 	i := 0
 	maxRetry := 3
@@ -67,27 +257,95 @@ func (s *QcloudCos) List(prefix string, marker string) (list []string) {
 			Marker:       marker,
 			EncodingType: "url", // url编码
 		}
-		v, _, err := s.cosClient.Bucket.Get(context.Background(), opt)
+		v, _, err := s.cosClient.Bucket.Get(operationContext("List"), opt)
 		if err != nil {
 			if i < maxRetry {
 				i++
+				retried(COS, "List")
 				time.Sleep(time.Second)
 				continue
 			} else {
-				log.Printf("Get Bucket error:%s", err.Error())
-				return list
+				logger.Error("Get Bucket error", "storage", COS, "error", err)
+				return nil, err
 			}
 		}
 
 		for _, c := range v.Contents {
 			source, _ := cos.DecodeURIComponent(c.Key)
-			list = append(list, source)
+			modified, _ := time.Parse(time.RFC3339, c.LastModified)
+			list = append(list, Object{
+				Key:          source,
+				Size:         c.Size,
+				ETag:         strings.Trim(c.ETag, "\""),
+				LastModified: modified,
+				StorageClass: c.StorageClass,
+			})
 		}
 
 		// 获取成功重置重试次数
 		i = 0
-		marker = v.Marker
+		marker, _ = cos.DecodeURIComponent(v.NextMarker)
 		isTruncated = v.IsTruncated
 	}
-	return list
+	return list, nil
+}
+
+// ListPage 获取前缀下的第一页对象，不重试，出错时返回错误
+func (s *QcloudCos) ListPage(prefix string, maxKeys int) ([]Object, error) {
+	v, _, err := s.cosClient.Bucket.Get(operationContext("ListPage"), &cos.BucketGetOptions{
+		Prefix:       strings.TrimLeft(prefix, "/"),
+		MaxKeys:      maxKeys,
+		EncodingType: "url",
+	})
+	if err != nil {
+		logger.Error("Get Bucket error", "storage", COS, "error", err)
+		return nil, err
+	}
+	var list []Object
+	for _, c := range v.Contents {
+		source, _ := cos.DecodeURIComponent(c.Key)
+		modified, _ := time.Parse(time.RFC3339, c.LastModified)
+		list = append(list, Object{
+			Key:          source,
+			Size:         c.Size,
+			ETag:         strings.Trim(c.ETag, "\""),
+			LastModified: modified,
+			StorageClass: c.StorageClass,
+		})
+	}
+	return list, nil
+}
+
+func (s *QcloudCos) Presign(key string, method string, expires time.Duration) (string, error) {
+	creds, err := s.credentials.Retrieve()
+	if err != nil {
+		return "", err
+	}
+	// 临时密钥需要在链接中带上token
+	opt := &cos.PresignedURLOptions{Query: &url.Values{}}
+	if creds.SessionToken != "" {
+		opt.Query.Set("x-cos-security-token", creds.SessionToken)
+	}
+	u, err := s.cosClient.Object.GetPresignedURL(
+		context.Background(), strings.ToUpper(method), key, creds.SecretId, creds.SecretKey, expires, opt)
+	if err != nil {
+		logger.Error("GetPresignedURL error", "storage", COS, "file", key, "error", err)
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *QcloudCos) Restore(key string, opt *RestoreOptions) error {
+	restoreOpt := &cos.ObjectRestoreOptions{Days: opt.Days}
+	if opt.Tier != "" {
+		restoreOpt.Tier = &cos.CASJobParameters{Tier: opt.Tier}
+	}
+	_, err := s.cosClient.Object.PostRestore(operationContext("Restore"), key, restoreOpt)
+	if e, ok := cos.IsCOSError(err); ok && e.Code == "RestoreAlreadyInProgress" {
+		return ErrRestoreInProgress
+	}
+	if err != nil {
+		logger.Error("PostRestore error", "storage", COS, "file", key, "error", err)
+	}
+	return err
 }
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"github.com/jorben/osd-tool/config"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// cosTestServer 测试用的cos服务，前failures次请求返回status，之后返回200及body
+func cosTestServer(t *testing.T, failures int32, status int, body string) *QcloudCos {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+	return NewQcloudCos(&config.Profile{Storage: COS, Osd: config.Osd{SecretId: "id", SecretKey: "key",
+		Endpoint: ts.URL, Timeout: 10}})
+}
+
+func TestCosRetryHook(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		call     func(s *QcloudCos) error
+		want     map[string]int
+		wantErr  bool
+	}{
+		{"head retried once", 1, http.StatusInternalServerError,
+			func(s *QcloudCos) error { _, err := s.Head("a.txt", nil); return err }, map[string]int{"Head": 1}, false},
+		{"delete retries exhausted", 5, http.StatusServiceUnavailable,
+			func(s *QcloudCos) error { return s.Delete("a.txt") }, map[string]int{"Delete": 2}, true},
+		{"not found not retried", 1, http.StatusNotFound,
+			func(s *QcloudCos) error { _, err := s.Head("a.txt", nil); return err }, map[string]int{}, true},
+		{"get retried once", 1, http.StatusInternalServerError,
+			func(s *QcloudCos) error { return s.GetFile("a.txt", filepath.Join(t.TempDir(), "a.txt"), nil) },
+			map[string]int{"GetFile": 1}, false},
+	}
+	defer func() { RetryHook = nil }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]int{}
+			RetryHook = func(storage string, operation string) {
+				if storage == COS {
+					got[operation]++
+				}
+			}
+			err := tt.call(cosTestServer(t, tt.failures, tt.status, "hello"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%s error got %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("RetryHook rsp got %v, want %v", got, tt.want)
+			}
+			for op, n := range tt.want {
+				if got[op] != n {
+					t.Errorf("RetryHook rsp got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCosProgress(t *testing.T) {
+	s := cosTestServer(t, 0, http.StatusOK, "hello osd-tool")
+	var got int64
+	err := s.GetFile("a.txt", filepath.Join(t.TempDir(), "a.txt"), &GetOptions{Progress: func(n int64) { got += n }})
+	if err != nil || got != int64(len("hello osd-tool")) {
+		t.Errorf("GetFile progress rsp got %d %v, want %d", got, err, len("hello osd-tool"))
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/logger"
+	"github.com/jorben/osd-tool/provider"
+	"github.com/schollz/progressbar/v3"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 升级来源的前缀
+const (
+	SourceGithub = "https://api.github.com" // 默认的升级来源
+	SourceBucket = "osd://"                 // 配置的对象存储中的路径，如 osd://releases/osd-tool/
+	SourceFile   = "file://"                // 本地的安装包，也可以直接使用本地路径
+)
+
+// DefaultUpgradeTimeout 升级请求的默认超时时间，包含下载安装包的时间
+const DefaultUpgradeTimeout = 5 * time.Minute
+
+// releaseSource 版本发布的来源，提供版本信息并下载版本中的文件
+type releaseSource interface {
+	Latest() (Release, error)
+	Release(version string) (Release, error)
+	List() ([]Release, error)
+	Fetch(url string, path string, bar bool) error
+}
+
+// newReleaseSource 按升级选项获取版本发布的来源
+// source为空时使用GitHub，http地址为与GitHub API相同格式的镜像或GitHub Enterprise的API地址，
+// osd:// 为对象存储中按版本号存放的目录，file:// 或本地路径为安装包文件
+func newReleaseSource(repo string, opt UpgradeOptions) (releaseSource, error) {
+	source := opt.Source
+	switch {
+	case source == "" || strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		if source == "" {
+			source = SourceGithub
+		}
+		client, err := newHttpClient(opt.Proxy, opt.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &apiSource{base: strings.TrimRight(source, "/"), repo: repo, client: client}, nil
+	case strings.HasPrefix(source, SourceBucket):
+		if opt.Provider == nil {
+			return nil, errors.New(fmt.Sprintf("upgrade source '%s' requires a valid storage config", source))
+		}
+		prefix := strings.Trim(strings.TrimPrefix(source, SourceBucket), "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		return &bucketSource{p: opt.Provider, prefix: prefix}, nil
+	default:
+		path := strings.TrimPrefix(source, SourceFile)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("upgrade source '%s' is not found", source))
+		}
+		if info.IsDir() {
+			return nil, errors.New(fmt.Sprintf("upgrade source '%s' is a directory, it should be a package file", source))
+		}
+		return &fileSource{path: path, version: opt.Version}, nil
+	}
+}
+
+// newHttpClient 获取升级使用的http客户端，未指定代理时使用环境变量 HTTPS_PROXY、HTTP_PROXY 中的代理
+func newHttpClient(proxy string, timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return nil, errors.New(fmt.Sprintf("proxy '%s' is invalid, it should be like http://host:port", proxy))
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// sortReleases 按版本号从高到低排序
+func sortReleases(releases []Release) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		return helper.CompareVersion(releases[i].TagName, releases[j].TagName) > 0
+	})
+}
+
+// apiSource 与GitHub API格式相同的版本来源
+type apiSource struct {
+	base   string
+	repo   string
+	client *http.Client
+}
+
+// getApi 获取API地址，path为 /repos/{repo}/releases 之后的路径
+func (s *apiSource) getApi(path string) string {
+	return fmt.Sprintf("%s/repos/%s/releases%s", s.base, s.repo, path)
+}
+
+func (s *apiSource) Latest() (Release, error) {
+	latest := Release{}
+	err := s.getJson(s.getApi("/latest"), &latest)
+	return latest, err
+}
+
+func (s *apiSource) Release(version string) (Release, error) {
+	release := Release{}
+	err := s.getJson(s.getApi("/tags/"+version), &release)
+	if errors.Is(err, errNotFound) {
+		return release, errors.New(fmt.Sprintf("version %s is not found", version))
+	}
+	return release, err
+}
+
+func (s *apiSource) List() ([]Release, error) {
+	var all []Release
+	if err := s.getJson(s.getApi("?per_page=100"), &all); err != nil {
+		return nil, err
+	}
+	// 忽略 nightly 等不是版本号的标签
+	var releases []Release
+	for _, r := range all {
+		if helper.IsVersion(r.TagName) {
+			releases = append(releases, r)
+		}
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+// errNotFound 请求的版本信息不存在
+var errNotFound = errors.New("not found")
+
+// getJson 请求版本信息API并解析返回的JSON
+func (s *apiSource) getJson(url string, v interface{}) error {
+	res, err := s.client.Get(url)
+	if err != nil {
+		logger.Error("get release error", "url", url, "error", err)
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("get release from %s error, status: %s", url, res.Status))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		logger.Error("read release error", "url", url, "error", err)
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		logger.Error("decode release error", "url", url, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Fetch 下载文件到本地，bar为true时显示下载进度
+func (s *apiSource) Fetch(url string, path string, bar bool) error {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("download %s error, status: %s", url, resp.Status))
+	}
+
+	var w io.Writer = fd
+	if bar {
+		w = io.MultiWriter(fd, progressbar.DefaultBytes(resp.ContentLength, "downloading..."))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+	return fd.Close()
+}
+
+// bucketSource 对象存储中的版本来源，每个版本一个目录，目录名为版本号，如
+// releases/osd-tool/v1.0.3/osd-tool_linux_amd64.tgz、releases/osd-tool/v1.0.3/checksums.txt
+// 版本号带有预发布标识的为预发布版本，如 v1.1.0-rc.1
+type bucketSource struct {
+	p      provider.Provider
+	prefix string
+}
+
+func (s *bucketSource) Latest() (Release, error) {
+	releases, err := s.List()
+	if err != nil {
+		return Release{}, err
+	}
+	for _, r := range releases {
+		if !r.Prerelease {
+			return r, nil
+		}
+	}
+	return Release{}, errors.New(fmt.Sprintf("no release is found under '%s'", s.prefix))
+}
+
+func (s *bucketSource) Release(version string) (Release, error) {
+	releases, err := s.List()
+	if err != nil {
+		return Release{}, err
+	}
+	for _, r := range releases {
+		if r.TagName == version {
+			return r, nil
+		}
+	}
+	return Release{}, errors.New(fmt.Sprintf("version %s is not found", version))
+}
+
+func (s *bucketSource) List() ([]Release, error) {
+	objects, err := s.p.List(s.prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	var releases []Release
+	for _, obj := range objects {
+		parts := strings.SplitN(strings.TrimPrefix(obj.Key, s.prefix), "/", 2)
+		// 只识别版本号目录下的文件，忽略 latest 等其他目录
+		if len(parts) != 2 || parts[1] == "" || strings.Contains(parts[1], "/") || !helper.IsVersion(parts[0]) {
+			continue
+		}
+		i, ok := index[parts[0]]
+		if !ok {
+			i = len(releases)
+			index[parts[0]] = i
+			releases = append(releases, Release{TagName: parts[0], Prerelease: helper.IsPrerelease(parts[0])})
+		}
+		r := &releases[i]
+		r.Assets = append(r.Assets, Asset{Name: parts[1], Url: obj.Key})
+		if obj.LastModified.After(r.PublishedAt) {
+			r.PublishedAt = obj.LastModified
+		}
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+// Fetch 下载对象到本地，url为对象路径
+func (s *bucketSource) Fetch(url string, path string, bar bool) error {
+	return s.p.GetFile(url, path, nil)
+}
+
+// fileSource 本地安装包，同目录下的 checksums.txt、checksums.txt.sig 用于校验
+type fileSource struct {
+	path    string
+	version string // 安装包的版本号，未指定时为 local
+}
+
+func (s *fileSource) Latest() (Release, error) {
+	version := s.version
+	if version == "" {
+		version = "local"
+	}
+	r := Release{TagName: version}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return r, err
+	}
+	r.PublishedAt = info.ModTime()
+	dir := filepath.Dir(s.path)
+	for _, name := range []string{filepath.Base(s.path), ChecksumsName, SignatureName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			r.Assets = append(r.Assets, Asset{Name: name, Url: filepath.Join(dir, name)})
+		}
+	}
+	return r, nil
+}
+
+func (s *fileSource) Release(version string) (Release, error) {
+	return s.Latest()
+}
+
+func (s *fileSource) List() ([]Release, error) {
+	r, err := s.Latest()
+	if err != nil {
+		return nil, err
+	}
+	return []Release{r}, nil
+}
+
+// Fetch 复制本地文件，url为文件路径
+func (s *fileSource) Fetch(url string, path string, bar bool) error {
+	return helper.Copy(url, path)
+}
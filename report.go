@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 报告中文件的处理结果
+const (
+	ReportUploaded   = "uploaded"
+	ReportDownloaded = "downloaded"
+	ReportSkipped    = "skipped"
+	ReportFailed     = "failed"
+	ReportDeleted    = "deleted"
+	ReportTotal      = "total" // csv报告最后一行的汇总
+)
+
+// TransferReport 一次执行的传输报告，记录每个文件的处理结果及汇总
+type TransferReport struct {
+	Command     string         `json:"command"`
+	Version     string         `json:"version"`
+	StartTime   time.Time      `json:"start_time"`
+	EndTime     time.Time      `json:"end_time"`
+	Fingerprint string         `json:"config_fingerprint"`
+	Totals      ReportTotals   `json:"totals"`
+	Entries     []*ReportEntry `json:"entries"`
+	mu          sync.Mutex
+	path        string
+	format      string
+}
+
+// ReportTotals 报告的汇总，Bytes为上传、下载成功的容量
+type ReportTotals struct {
+	Files      int   `json:"files"`
+	Uploaded   int   `json:"uploaded"`
+	Downloaded int   `json:"downloaded"`
+	Skipped    int   `json:"skipped"`
+	Failed     int   `json:"failed"`
+	Deleted    int   `json:"deleted"`
+	Bytes      int64 `json:"bytes"`
+}
+
+// ReportEntry 报告中单个文件的处理结果，传输成功时Size和Checksum均为本地文件的大小和sha256，
+// 即上传时压缩、加密之前，下载时解压、解密之后的文件，失败或跳过时Size为源文件或对象的大小
+type ReportEntry struct {
+	Job      string `json:"job,omitempty"`
+	Key      string `json:"key"`
+	Path     string `json:"path"`
+	Action   string `json:"action"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"`
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewTransferReport 获取TransferReport实例，path中的{time}替换为开始时间，format为空时按扩展名判断
+func NewTransferReport(command string, cfg *config.TransferConfig, path string, format string) (*TransferReport, error) {
+	if format == "" {
+		format = config.ReportJson
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			format = config.ReportCsv
+		}
+	}
+	if format != config.ReportJson && format != config.ReportCsv {
+		return nil, errors.New(fmt.Sprintf("report format '%s' is not supported, use %s or %s",
+			format, config.ReportJson, config.ReportCsv))
+	}
+	start := time.Now()
+	return &TransferReport{
+		Command:     command,
+		Version:     Version,
+		StartTime:   start,
+		Fingerprint: cfg.Fingerprint(),
+		Entries:     []*ReportEntry{},
+		path:        strings.Replace(path, "{time}", start.Format("20060102-150405"), -1),
+		format:      format,
+	}, nil
+}
+
+// Add 添加文件的处理结果，为nil时不做任何处理
+func (r *TransferReport) Add(entry *ReportEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Entries = append(r.Entries, entry)
+}
+
+// Enabled 判断是否需要记录报告，不需要时不计算文件的sha256
+func (r *TransferReport) Enabled() bool {
+	return r != nil
+}
+
+// Path 获取报告文件路径
+func (r *TransferReport) Path() string {
+	return r.path
+}
+
+// Save 统计汇总并写入报告文件
+func (r *TransferReport) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.EndTime = time.Now()
+	r.Totals = ReportTotals{Files: len(r.Entries)}
+	for _, e := range r.Entries {
+		switch e.Action {
+		case ReportUploaded:
+			r.Totals.Uploaded++
+			r.Totals.Bytes += e.Size
+		case ReportDownloaded:
+			r.Totals.Downloaded++
+			r.Totals.Bytes += e.Size
+		case ReportSkipped:
+			r.Totals.Skipped++
+		case ReportFailed:
+			r.Totals.Failed++
+		case ReportDeleted:
+			r.Totals.Deleted++
+		}
+	}
+
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	fd, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if r.format == config.ReportCsv {
+		if err = r.writeCsv(fd); err == nil {
+			err = r.writeMeta()
+		}
+	} else {
+		enc := json.NewEncoder(fd)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	}
+	if err != nil {
+		return err
+	}
+	return fd.Close()
+}
+
+// writeCsv 输出csv格式的报告，第一行为表头，之后每行一个文件，最后一行action为total，
+// size为上传、下载成功的容量，duration_ms为整个执行的耗时
+func (r *TransferReport) writeCsv(fd *os.File) error {
+	w := csv.NewWriter(fd)
+	_ = w.Write([]string{"job", "key", "path", "action", "size", "checksum", "duration_ms", "error"})
+	for _, e := range r.Entries {
+		_ = w.Write([]string{e.Job, e.Key, e.Path, e.Action, strconv.FormatInt(e.Size, 10), e.Checksum,
+			strconv.FormatInt(e.Duration, 10), e.Error})
+	}
+	_ = w.Write([]string{"", "", "", ReportTotal, strconv.FormatInt(r.Totals.Bytes, 10), "",
+		strconv.FormatInt(r.EndTime.Sub(r.StartTime).Milliseconds(), 10), ""})
+	w.Flush()
+	return w.Error()
+}
+
+// MetaPath 获取csv报告的元数据文件路径，与报告同名，扩展名为 .meta.json
+func (r *TransferReport) MetaPath() string {
+	return strings.TrimSuffix(r.path, filepath.Ext(r.path)) + ".meta.json"
+}
+
+// writeMeta 把命令、版本、时间、配置指纹及汇总写入csv报告的元数据文件，不包含每个文件的结果
+func (r *TransferReport) writeMeta() error {
+	buf, err := json.MarshalIndent(struct {
+		Command     string       `json:"command"`
+		Version     string       `json:"version"`
+		StartTime   time.Time    `json:"start_time"`
+		EndTime     time.Time    `json:"end_time"`
+		Fingerprint string       `json:"config_fingerprint"`
+		Totals      ReportTotals `json:"totals"`
+		Report      string       `json:"report"`
+	}{r.Command, r.Version, r.StartTime, r.EndTime, r.Fingerprint, r.Totals, filepath.Base(r.path)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.MetaPath(), append(buf, '\n'), 0644)
+}
+
+// errorText 获取错误信息，err为nil时为空
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
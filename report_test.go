@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/jorben/osd-tool/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// reportEntries 测试用的文件处理结果
+var reportEntries = []*ReportEntry{
+	{Job: "photos", Key: "a.jpg", Path: "/data/a.jpg", Action: ReportUploaded, Size: 100, Checksum: "abc", Duration: 12},
+	{Job: "photos", Key: "b.jpg", Path: "/data/b.jpg", Action: ReportFailed, Size: 50, Error: "access denied, \"b.jpg\""},
+	{Job: "photos", Path: "/data/.DS_Store", Action: ReportSkipped, Error: "ignored"},
+	{Job: "photos", Key: "old.jpg", Action: ReportDeleted, Size: 30},
+}
+
+func TestTransferReportSave(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		format     string
+		wantFormat string
+		wantErr    bool
+	}{
+		{"json by default", "report.txt", "", config.ReportJson, false},
+		{"csv by ext", "report-{time}.CSV", "", config.ReportCsv, false},
+		{"format overrides ext", "report.csv", config.ReportJson, config.ReportJson, false},
+		{"unknown format", "report.xml", "xml", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			report, err := NewTransferReport("upload", &config.TransferConfig{}, filepath.Join(dir, "logs", tt.path), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTransferReport error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, e := range reportEntries {
+				report.Add(e)
+			}
+			if err := report.Save(); err != nil {
+				t.Fatalf("Save error: %v", err)
+			}
+			if strings.Contains(report.Path(), "{time}") {
+				t.Errorf("Save path got %v, want {time} replaced", report.Path())
+			}
+			content, err := os.ReadFile(report.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			if tt.wantFormat == config.ReportJson {
+				saved := &TransferReport{}
+				if err := json.Unmarshal(content, saved); err != nil {
+					t.Fatalf("Save json rsp got %s, error: %v", content, err)
+				}
+				want := ReportTotals{Files: 4, Uploaded: 1, Failed: 1, Skipped: 1, Deleted: 1, Bytes: 100}
+				if saved.Totals != want || saved.Fingerprint == "" || saved.EndTime.Before(saved.StartTime) {
+					t.Errorf("Save json rsp got %+v, fingerprint %q, want totals %+v", saved.Totals, saved.Fingerprint, want)
+				}
+				for _, e := range saved.Entries {
+					got = append(got, e.Action)
+				}
+			} else {
+				records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+				if err != nil {
+					t.Fatalf("Save csv rsp got %s, error: %v", content, err)
+				}
+				if records[0][0] != "job" {
+					t.Errorf("Save csv header got %v, want header on the first line", records[0])
+				}
+				last := records[len(records)-1]
+				if last[3] != ReportTotal || last[4] != "100" {
+					t.Errorf("Save csv total got %v, want total row with 100 bytes", last)
+				}
+				for _, record := range records[1 : len(records)-1] {
+					got = append(got, record[3])
+				}
+				if records[2][7] != reportEntries[1].Error {
+					t.Errorf("Save csv error got %v, want %v", records[2][7], reportEntries[1].Error)
+				}
+				buf, err := os.ReadFile(report.MetaPath())
+				if err != nil {
+					t.Fatal(err)
+				}
+				meta := &TransferReport{}
+				want := ReportTotals{Files: 4, Uploaded: 1, Failed: 1, Skipped: 1, Deleted: 1, Bytes: 100}
+				if err := json.Unmarshal(buf, meta); err != nil || meta.Totals != want || meta.Command != "upload" ||
+					len(meta.Entries) != 0 {
+					t.Errorf("Save csv meta rsp got %s, want totals %+v", buf, want)
+				}
+			}
+			want := "uploaded,failed,skipped,deleted"
+			if strings.Join(got, ",") != want {
+				t.Errorf("Save actions got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigFingerprint(t *testing.T) {
+	base := func() *config.TransferConfig {
+		cfg := &config.TransferConfig{Storage: "cos"}
+		cfg.Osd = config.Osd{SecretId: "id", SecretKey: "key", Bucket: "test-1250000000", Region: "ap-guangzhou"}
+		cfg.Encryption = &config.Encryption{Enabled: true, Passphrase: "secret"}
+		return cfg
+	}
+	want := base().Fingerprint()
+
+	tests := []struct {
+		name   string
+		modify func(cfg *config.TransferConfig)
+		same   bool
+	}{
+		{"same config", func(cfg *config.TransferConfig) {}, true},
+		{"rotated secret", func(cfg *config.TransferConfig) { cfg.Osd.SecretKey = "new key" }, true},
+		{"changed passphrase", func(cfg *config.TransferConfig) { cfg.Encryption.Passphrase = "other" }, true},
+		{"changed bucket", func(cfg *config.TransferConfig) { cfg.Osd.Bucket = "other-1250000000" }, false},
+		{"added job", func(cfg *config.TransferConfig) { cfg.Jobs = []config.Job{{Name: "photos"}} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base()
+			tt.modify(cfg)
+			if got := cfg.Fingerprint(); (got == want) != tt.same {
+				t.Errorf("Fingerprint rsp got %v, base %v, want same %v", got, want, tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/logger"
+	"github.com/jorben/osd-tool/provider"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 取回报告中除 provider.RestoreOngoing、provider.RestoreRestored 以外的状态
+const (
+	RestoreArchived = "archived" // 未开启自动取回，未发起取回
+	RestoreFailed   = "failed"   // 查询或发起取回失败
+)
+
+// 默认的取回配置
+const (
+	DefaultRestoreDays     = 1
+	DefaultRestoreInterval = 60
+	DefaultRestoreTimeout  = 48 * 3600
+)
+
+// RestoreEntry 归档对象的取回状态
+type RestoreEntry struct {
+	Key          string `json:"key"`
+	StorageClass string `json:"storage_class"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// restoreObject 查询归档对象的取回状态，未取回且开启了自动取回时发起取回
+func restoreObject(p provider.Provider, obj provider.Object, cfg *config.Restore,
+	sse *config.ServerSideEncryption) *RestoreEntry {
+	entry := &RestoreEntry{Key: obj.Key, StorageClass: obj.StorageClass}
+	head, err := p.Head(obj.Key, &provider.GetOptions{Encryption: sse})
+	if err != nil {
+		entry.Status, entry.Error = RestoreFailed, err.Error()
+		return entry
+	}
+	if entry.Status = provider.RestoreStatus(head.Restore); entry.Status != provider.RestoreNone {
+		return entry
+	}
+	if cfg == nil || !cfg.Enabled {
+		entry.Status = RestoreArchived
+		return entry
+	}
+
+	days := cfg.Days
+	if days <= 0 {
+		days = DefaultRestoreDays
+	}
+	err = p.Restore(obj.Key, &provider.RestoreOptions{Days: days, Tier: cfg.Tier})
+	if err != nil && !errors.Is(err, provider.ErrRestoreInProgress) {
+		entry.Status, entry.Error = RestoreFailed, err.Error()
+		return entry
+	}
+	logger.Info("restore requested", "key", obj.Key, "days", days)
+	entry.Status = provider.RestoreOngoing
+	return entry
+}
+
+// waitRestore 轮询取回中的对象，直到全部取回或超时，对象取回后回调fn
+func waitRestore(p provider.Provider, entries []*RestoreEntry, cfg *config.Restore,
+	sse *config.ServerSideEncryption, fn func(entry *RestoreEntry)) {
+	interval, timeout := cfg.Interval, cfg.Timeout
+	if interval <= 0 {
+		interval = DefaultRestoreInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultRestoreTimeout
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		ongoing := 0
+		for _, entry := range entries {
+			if entry.Status != provider.RestoreOngoing {
+				continue
+			}
+			head, err := p.Head(entry.Key, &provider.GetOptions{Encryption: sse})
+			if err == nil && provider.RestoreStatus(head.Restore) == provider.RestoreRestored {
+				entry.Status = provider.RestoreRestored
+				logger.Info("restore finished", "key", entry.Key)
+				if fn != nil {
+					fn(entry)
+				}
+				continue
+			}
+			ongoing++
+		}
+		if ongoing == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			logger.Warn("wait restore timeout, objects are still being restored", "ongoing", ongoing)
+			return
+		}
+		logger.Info("waiting for objects to be restored", "ongoing", ongoing, "interval", interval)
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+}
+
+// restoreArchived 处理下载时遇到的归档对象，已取回的对象回调fn下载，开启等待时取回完成后再回调fn，返回未能下载的对象
+func restoreArchived(p provider.Provider, job config.Job, archived []provider.Object,
+	fn func(entry *RestoreEntry)) []*RestoreEntry {
+	var entries []*RestoreEntry
+	for _, obj := range archived {
+		entry := restoreObject(p, obj, job.Restore, job.ServerSideEncryption)
+		if entry.Status == provider.RestoreRestored {
+			fn(entry)
+		}
+		entries = append(entries, entry)
+	}
+	if job.Restore != nil && job.Restore.Enabled && job.Restore.Wait {
+		waitRestore(p, entries, job.Restore, job.ServerSideEncryption, fn)
+	}
+
+	var pending []*RestoreEntry
+	for _, entry := range entries {
+		if entry.Status != provider.RestoreRestored {
+			pending = append(pending, entry)
+		}
+	}
+	return pending
+}
+
+// RestorePrefix 对前缀下所有归档对象发起取回，开启等待时等待全部取回完成
+func (t *CloudTransfer) RestorePrefix(profile string, prefix string, cfg *config.Restore) ([]*RestoreEntry, error) {
+	p, err := t.GetProvider(profile)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := p.List(strings.TrimLeft(prefix, "/"), "")
+	if err != nil {
+		return nil, err
+	}
+	var entries []*RestoreEntry
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") || !provider.IsArchived(obj.StorageClass) {
+			continue
+		}
+		entries = append(entries, restoreObject(p, obj, cfg, t.Config.ServerSideEncryption))
+	}
+	if cfg.Wait {
+		waitRestore(p, entries, cfg, t.Config.ServerSideEncryption, nil)
+	}
+	return entries, nil
+}
+
+// PrintRestoreReport 按指定格式输出归档对象的取回状态
+func PrintRestoreReport(w io.Writer, format string, entries []*RestoreEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	switch strings.ToLower(format) {
+	case "", "text":
+		counts := map[string]int{}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tSTORAGE_CLASS\tSTATUS\tERROR")
+		for _, e := range entries {
+			counts[e.Status]++
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Key, e.StorageClass, e.Status, e.Error)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "total: %d, restored: %d, ongoing: %d, archived: %d, failed: %d\n", len(entries),
+			counts[provider.RestoreRestored], counts[provider.RestoreOngoing], counts[RestoreArchived], counts[RestoreFailed])
+		return nil
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("output format '%s' is not supported", format))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/provider"
+	"io"
+	"sort"
+	"time"
+)
+
+// PrintStat 打印对象的详细信息
+func PrintStat(w io.Writer, obj *provider.Object) {
+	fmt.Fprintln(w, "key:", obj.Key)
+	fmt.Fprintf(w, "size: %d (%s)\n", obj.Size, helper.FormatBytes(obj.Size))
+	fmt.Fprintln(w, "etag:", obj.ETag)
+	fmt.Fprintln(w, "last_modified:", obj.LastModified.Format(time.RFC3339))
+	fmt.Fprintln(w, "storage_class:", valueOrNone(obj.StorageClass))
+	fmt.Fprintln(w, "content_encoding:", valueOrNone(obj.ContentEncoding))
+	fmt.Fprintln(w, "server_side_encryption:", valueOrNone(obj.Encryption))
+	if obj.KmsKeyId != "" {
+		fmt.Fprintln(w, "kms_key_id:", obj.KmsKeyId)
+	}
+	if len(obj.Meta) > 0 {
+		fmt.Fprintln(w, "meta:")
+		keys := make([]string, 0, len(obj.Meta))
+		for k := range obj.Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %s: %s\n", k, obj.Meta[k])
+		}
+	}
+}
+
+// valueOrNone 值为空时返回 -
+func valueOrNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
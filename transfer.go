@@ -5,206 +5,659 @@ import (
 	"fmt"
 	"github.com/jorben/osd-tool/config"
 	"github.com/jorben/osd-tool/helper"
+	"github.com/jorben/osd-tool/logger"
 	"github.com/jorben/osd-tool/provider"
 	"io/fs"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // CloudTransfer 对象存储文件传输器
 type CloudTransfer struct {
-	Provider provider.Provider
-	Config   *config.TransferConfig
+	Config    *config.TransferConfig
+	Progress  string           // 传输进度的显示方式，为空时同 auto
+	Report    *TransferReport  // 传输报告，为nil时不记录
+	Metrics   *TransferMetrics // 传输指标，为nil时不记录
+	providers map[string]provider.Provider
+	mu        sync.Mutex
+	failed    int64 // 处理失败的文件数，原子操作
+}
+
+// filesFailedError 任务中有文件处理失败，不影响之后的任务继续执行
+type filesFailedError struct {
+	count int64
+}
+
+func (e *filesFailedError) Error() string {
+	return fmt.Sprintf("%d files failed, see the log or report for details", e.count)
+}
+
+// transferTask 待传输的文件
+type transferTask struct {
+	provider   provider.Provider
+	cipher     *contentCipher
+	compressor *contentCompressor
+	sse        *config.ServerSideEncryption
+	rules      []config.ObjectRule
+	key        string
+	path       string
+	rel        string // 上传时文件相对于源目录的路径，用于匹配对象属性规则
+	size       int64
+	progress   *transferProgress
+	slot       int    // 在传输进度中的位置，用于更新已传输的字节数
+	job        string // 所属任务的名称，用于记录报告
 }
 
 // NewTransfer 获取CloudTransfer实例
 func NewTransfer(cfg *config.TransferConfig) (transfer *CloudTransfer, err error) {
-	transfer = &CloudTransfer{Config: cfg}
-	storage := strings.ToLower(cfg.Storage)
-	switch storage {
+	transfer = &CloudTransfer{Config: cfg, providers: map[string]provider.Provider{}}
+	// 提前检查上传、下载配置所引用的存储配置
+	for _, dir := range append(append([]config.Path{}, cfg.Upload.List...), cfg.Download.List...) {
+		if _, err := transfer.GetProvider(dir.Profile); err != nil {
+			return nil, err
+		}
+	}
+	return transfer, nil
+}
+
+// GetProvider 获取指定名称的存储配置对应的Provider，名称为空时使用默认配置
+func (t *CloudTransfer) GetProvider(name string) (provider.Provider, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	profile, err := t.Config.GetProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := t.providers[name]
+	if !ok {
+		if p, err = newProvider(profile); err != nil {
+			return nil, err
+		}
+		t.providers[name] = p
+	}
+	// 指标在实例化之后才设置，因此每次获取时按需包装
+	return t.Metrics.Provider(p, strings.ToLower(profile.Storage)), nil
+}
+
+// newProvider 按存储类型实例化Provider
+func newProvider(profile *config.Profile) (provider.Provider, error) {
+	switch strings.ToLower(profile.Storage) {
 	case provider.COS:
-		transfer.Provider = provider.NewQcloudCos(cfg)
+		return provider.NewQcloudCos(profile), nil
 	case provider.OSS:
-		transfer.Provider = provider.NewAliyunOss(cfg)
+		return provider.NewAliyunOss(profile), nil
 	default:
-		return nil, errors.New(fmt.Sprintf("storage type '%s' is not supported", cfg.Storage))
+		return nil, errors.New(fmt.Sprintf("storage type '%s' is not supported", profile.Storage))
 	}
-	return transfer, nil
 }
 
+// DefaultConcurrency 默认的并发传输数
+const DefaultConcurrency = 8
+
 // Upload 上传本地配置的文件目录到云端对象存储
 func (t *CloudTransfer) Upload() error {
 	t.PrintUploadConfig()
-	// 多线程执行
-	keysCh := make(chan []string, 8)
-	var wg sync.WaitGroup
-	threads := 8
-	for i := 0; i < threads; i++ {
-		wg.Add(1)
-		go t.AsyncUpload(&wg, keysCh)
-	}
-
-	defer func() {
-		// 关闭管道
-		close(keysCh)
-		// 等待上传完成
-		wg.Wait()
-	}()
-
-	for _, dir := range t.Config.Upload.List {
-		log.Printf("begin to upload, from local: %s, to osd: %s", dir.Source, dir.Dest)
-		err := filepath.Walk(dir.Source, func(path string, info fs.FileInfo, err error) error {
-			if info == nil {
-				log.Printf("no such file or directory: %s", path)
-				return nil
-			}
-
-			if info.IsDir() {
-				// 跳过需要忽略的文件夹
-				if helper.InArray(info.Name(), t.Config.Upload.Ignore) {
-					log.Printf("skipping a dir: %s", path)
-					return filepath.SkipDir
-				}
-				log.Printf("into dir:%s", path)
-				return nil
-			}
-
-			// 跳过需要忽略的文件
-			if helper.InArray(info.Name(), t.Config.Upload.Ignore) {
-				log.Printf("skipping a file:%s", path)
-				return nil
-			}
-
-			// 获取 osd 中的文件路径
-			//osdPath := strings.Replace(path, dir.Source, dir.Dest, 1)
-			osdPath := strings.TrimLeft(strings.Replace(path, dir.Source, dir.Dest, 1), "/")
+	return t.runJobs(t.Config.UploadJobs(), nil)
+}
 
-			// 丢进管道，异步上传
-			keysCh <- []string{osdPath, path}
+// Download 下载配置的云端对象存储的文件到本地
+func (t *CloudTransfer) Download() error {
+	t.PrintDownloadConfig()
+	return t.runJobs(t.Config.DownloadJobs(), nil)
+}
 
-			return nil
-		})
+// RunJobs 依次执行多个传输任务，任务失败时不再执行之后的任务
+func (t *CloudTransfer) RunJobs(jobs []config.Job) error {
+	return t.runJobs(jobs, t.PrintJobConfig)
+}
 
+// runJobs 依次执行传输任务，执行前回调before，before可以为nil
+// 任务出错时不再执行之后的任务，只有文件处理失败时继续执行，最后返回全部任务中失败的文件数
+func (t *CloudTransfer) runJobs(jobs []config.Job, before func(job config.Job)) error {
+	var failed int64
+	for _, job := range jobs {
+		if before != nil {
+			before(job)
+		}
+		err := t.Run(job)
+		var e *filesFailedError
+		if errors.As(err, &e) {
+			failed += e.count
+			continue
+		}
 		if err != nil {
-			log.Printf("filewalk error:%s", err.Error())
 			return err
 		}
 	}
+	if failed > 0 {
+		return &filesFailedError{count: failed}
+	}
 	return nil
 }
 
-// Download 下载配置的云端对象存储的文件到本地
-func (t *CloudTransfer) Download() error {
-	t.PrintDownloadConfig()
+// Run 执行一个传输任务，有文件处理失败时返回失败的文件数
+func (t *CloudTransfer) Run(job config.Job) error {
+	failedBefore := atomic.LoadInt64(&t.failed)
+	if job.Direction != config.DirectionUpload && job.Direction != config.DirectionDownload {
+		return errors.New(fmt.Sprintf("job direction '%s' is not supported", job.Direction))
+	}
+	p, err := t.GetProvider(job.Profile)
+	if err != nil {
+		return err
+	}
+	cipher, err := newContentCipher(job.Encryption)
+	if err != nil {
+		return err
+	}
+	compressor, err := newContentCompressor(job.Compression)
+	if err != nil {
+		return err
+	}
+	dir := job.Path()
+	progress, err := newProgress(t.Progress, job.Direction)
+	if err != nil {
+		return err
+	}
+
 	// 多线程执行
-	keysCh := make(chan []string, 8)
+	threads := job.Concurrency
+	if threads <= 0 {
+		threads = DefaultConcurrency
+	}
+	keysCh := make(chan transferTask, threads)
+	var pending []*RestoreEntry
 	var wg sync.WaitGroup
-	threads := 8
 	for i := 0; i < threads; i++ {
 		wg.Add(1)
-		go t.AsyncDownload(&wg, keysCh)
+		if job.Direction == config.DirectionUpload {
+			go t.AsyncUpload(&wg, keysCh)
+		} else {
+			go t.AsyncDownload(&wg, keysCh)
+		}
 	}
 
-	defer func() {
-		// 关闭管道
-		close(keysCh)
-		// 等待上传完成
-		wg.Wait()
-	}()
-
-	for _, dir := range t.Config.Download.List {
-		log.Printf("begin to download, from osd: %s, to local: %s", dir.Source, dir.Dest)
-
-		prefix := strings.TrimLeft(dir.Source, "/")
-		objs := t.Provider.List(prefix, "")
-		for _, source := range objs {
-			dest := strings.Replace(source, prefix, dir.Dest, 1)
-
+	if job.Direction == config.DirectionUpload {
+		logger.Info("begin to upload", "job", job.Name, "from", dir.Source, "to", dir.Dest)
+		err = t.walkUpload(dir, job.Ignore, func(key string, path string, info fs.FileInfo) {
+			// 丢进管道，异步上传
+			progress.Add(info.Size())
+			keysCh <- transferTask{provider: p, cipher: cipher, compressor: compressor, sse: job.ServerSideEncryption,
+				rules: job.Rules, key: key, path: path, rel: relPath(dir.Source, path), size: info.Size(),
+				progress: progress, job: job.Name}
+		}, func(path string) {
+			t.addEntry(job, &ReportEntry{Job: job.Name, Key: uploadKey(dir, path), Path: path, Action: ReportSkipped,
+				Error: "ignored"}, nil)
+		})
+	} else {
+		logger.Info("begin to download", "job", job.Name, "from", dir.Source, "to", dir.Dest)
+		var archived []provider.Object
+		dests := map[string]string{}
+		sizes := map[string]int64{}
+		err = walkDownload(p, dir, job.Ignore, func(obj provider.Object, dest string) {
 			// 创建本地目录
 			if _, err := os.Stat(path.Dir(dest)); err != nil && os.IsNotExist(err) {
 				err := os.MkdirAll(path.Dir(dest), os.ModePerm)
 				if err != nil {
-					log.Printf("mkdir error:%s", err.Error())
-					continue
+					logger.Error("mkdir error", "dir", path.Dir(dest), "error", err)
+					t.addEntry(job, &ReportEntry{Job: job.Name, Key: obj.Key, Path: dest, Action: ReportFailed,
+						Size: obj.Size, Error: err.Error()}, err)
+					return
 				}
 			}
 
 			// 目录不需要下载
-			if strings.HasSuffix(source, "/") {
-				continue
+			if strings.HasSuffix(obj.Key, "/") {
+				return
+			}
+			// 归档对象需要取回后才能下载
+			if provider.IsArchived(obj.StorageClass) {
+				archived = append(archived, obj)
+				dests[obj.Key], sizes[obj.Key] = dest, obj.Size
+				return
 			}
 			// 丢进管道，异步下载
-			keysCh <- []string{source, dest}
+			progress.Add(obj.Size)
+			keysCh <- transferTask{provider: p, cipher: cipher, compressor: compressor, sse: job.ServerSideEncryption,
+				key: obj.Key, path: dest, size: obj.Size, progress: progress, job: job.Name}
+		}, func(obj provider.Object) {
+			t.addEntry(job, &ReportEntry{Job: job.Name, Key: obj.Key, Action: ReportSkipped, Size: obj.Size,
+				Error: "ignored"}, nil)
+		})
+		pending = restoreArchived(p, job, archived, func(entry *RestoreEntry) {
+			progress.Add(sizes[entry.Key])
+			keysCh <- transferTask{provider: p, cipher: cipher, compressor: compressor, sse: job.ServerSideEncryption,
+				key: entry.Key, path: dests[entry.Key], size: sizes[entry.Key], progress: progress, job: job.Name}
+		})
+		for _, entry := range pending {
+			reason := entry.Error
+			if reason == "" {
+				reason = fmt.Sprintf("archived object is not restored, restore status: %s", entry.Status)
+			}
+			t.addEntry(job, &ReportEntry{Job: job.Name, Key: entry.Key, Path: dests[entry.Key], Action: ReportSkipped,
+				Size: sizes[entry.Key], Error: reason}, nil)
+		}
+	}
+	progress.ScanDone()
+
+	// 关闭管道，等待传输完成
+	close(keysCh)
+	wg.Wait()
+	progress.Stop()
+	if err != nil {
+		logger.Error("filewalk error", "dir", dir.Source, "error", err)
+		return err
+	}
+	if len(pending) > 0 {
+		logger.Warn("archived objects are not downloaded, restore them first", "count", len(pending))
+		_ = PrintRestoreReport(os.Stdout, "text", pending)
+	}
+
+	if job.Delete {
+		if err := t.deleteExtraneous(job, p); err != nil {
+			return err
+		}
+	}
+	if n := atomic.LoadInt64(&t.failed) - failedBefore; n > 0 {
+		logger.Error("job finished with failed files", "job", job.Name, "failed", n)
+		return &filesFailedError{count: n}
+	}
+	return nil
+}
+
+// deleteExtraneous 删除目标端存在而源端不存在的文件
+func (t *CloudTransfer) deleteExtraneous(job config.Job, p provider.Provider) error {
+	locals, remotes, err := t.collect(job)
+	if err != nil {
+		return err
+	}
+	// 源端为空时大概率是路径配置错误，不执行删除
+	if job.Direction == config.DirectionUpload && len(locals) == 0 ||
+		job.Direction == config.DirectionDownload && len(remotes) == 0 {
+		logger.Warn("source is empty, skip deleting", "from", job.Source)
+		return nil
+	}
+
+	if job.Direction == config.DirectionUpload {
+		for key := range remotes {
+			if _, ok := locals[key]; ok {
+				continue
+			}
+			err := p.Delete(key)
+			t.recordDelete(job, key, "", remotes[key].Size, err)
+			if err != nil {
+				continue
+			}
+			logger.Info("delete success", "key", key)
+		}
+		return nil
+	}
+	for key, local := range locals {
+		if _, ok := remotes[key]; ok {
+			continue
+		}
+		err := os.Remove(local.path)
+		t.recordDelete(job, key, local.path, local.info.Size(), err)
+		if err != nil {
+			logger.Error("delete error", "file", local.path, "error", err)
+			continue
+		}
+		logger.Info("delete success", "file", local.path)
+	}
+	return nil
+}
+
+// walkUpload 按上传规则遍历本地目录，对每个需要上传的文件回调其对象存储路径和本地路径，
+// 对忽略的文件和文件夹回调skip，skip可以为nil
+func (t *CloudTransfer) walkUpload(dir config.Path, ignore []string,
+	fn func(key string, path string, info fs.FileInfo), skip func(path string)) error {
+	return filepath.Walk(dir.Source, func(path string, info fs.FileInfo, err error) error {
+		if info == nil {
+			logger.Warn("no such file or directory", "file", path)
+			return nil
+		}
+
+		if info.IsDir() {
+			// 跳过需要忽略的文件夹
+			if helper.InArray(info.Name(), ignore) {
+				logger.Debug("skipping a dir", "dir", path)
+				if skip != nil {
+					skip(path)
+				}
+				return filepath.SkipDir
+			}
+			logger.Debug("into dir", "dir", path)
+			return nil
+		}
+
+		// 跳过需要忽略的文件
+		if helper.InArray(info.Name(), ignore) {
+			logger.Debug("skipping a file", "file", path)
+			if skip != nil {
+				skip(path)
+			}
+			return nil
 		}
 
+		// 获取 osd 中的文件路径
+		fn(uploadKey(dir, path), path, info)
+		return nil
+	})
+}
+
+// dirPrefix 把对象存储中的目录转换为列出、写入对象使用的前缀，去掉开头的/，不为空时以/结尾
+func dirPrefix(dir string) string {
+	prefix := strings.TrimLeft(dir, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// uploadKey 获取本地文件上传后在对象存储中的路径
+func uploadKey(dir config.Path, path string) string {
+	return strings.TrimLeft(strings.Replace(path, dir.Source, dir.Dest, 1), "/")
+}
+
+// walkDownload 按下载规则列出云端对象，对每个需要下载的对象回调其本地路径，对忽略的对象回调skip，skip可以为nil
+// 列出对象失败时不回调任何对象并返回错误
+func walkDownload(p provider.Provider, dir config.Path, ignore []string, fn func(obj provider.Object, dest string),
+	skip func(obj provider.Object)) error {
+	prefix := strings.TrimLeft(dir.Source, "/")
+	objects, err := p.List(prefix, "")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		// 跳过需要忽略的文件和文件夹
+		if isIgnoredKey(strings.TrimPrefix(obj.Key, prefix), ignore) {
+			if skip != nil && !strings.HasSuffix(obj.Key, "/") {
+				skip(obj)
+			}
+			continue
+		}
+		fn(obj, strings.Replace(obj.Key, prefix, dir.Dest, 1))
 	}
 	return nil
 }
 
 // AsyncUpload 多协程上传
-func (t *CloudTransfer) AsyncUpload(wg *sync.WaitGroup, keysCh <-chan []string) {
+func (t *CloudTransfer) AsyncUpload(wg *sync.WaitGroup, keysCh <-chan transferTask) {
 	defer wg.Done()
-	for keys := range keysCh {
-		key := keys[0]
-		filename := keys[1]
+	for task := range keysCh {
 		// 上传到对象存储
-		err := t.Provider.PutFile(key, filename)
+		task.slot = task.progress.Begin(task.key, task.size)
+		begin := time.Now()
+		key, err := t.upload(task)
+		task.progress.Done(task.slot, task.size, err)
+		t.record(task, config.DirectionUpload, ReportUploaded, key, task.path, begin, err)
 		if err != nil {
 			continue
 		}
-		log.Printf("upload success, file:%s", key)
+		logSuccess(task, "upload success")
 	}
 }
 
 // AsyncDownload 多协程下载
-func (t *CloudTransfer) AsyncDownload(wg *sync.WaitGroup, ch <-chan []string) {
+func (t *CloudTransfer) AsyncDownload(wg *sync.WaitGroup, ch <-chan transferTask) {
 	defer wg.Done()
-	for keys := range ch {
-		key := keys[0]
-		filename := keys[1]
-		err := t.Provider.GetFile(key, filename)
+	for task := range ch {
+		task.slot = task.progress.Begin(task.key, task.size)
+		begin := time.Now()
+		dest, err := t.download(task)
+		task.progress.Done(task.slot, task.size, err)
+		t.record(task, config.DirectionDownload, ReportDownloaded, task.key, dest, begin, err)
 		if err != nil {
 			continue
 		}
-		log.Printf("download success, file:%s", filename)
+		logSuccess(task, "download success")
 	}
 }
 
+// logSuccess 记录单个文件传输成功的日志，显示传输进度时降为Debug级别，避免逐个文件的日志刷屏
+func logSuccess(task transferTask, msg string) {
+	if task.progress != nil {
+		logger.Debug(msg, "key", task.key, "file", task.path)
+		return
+	}
+	logger.Info(msg, "key", task.key, "file", task.path)
+}
+
+// record 记录文件的传输结果到报告和指标，成功时在报告中记录本地文件的大小和sha256，指标中记录传输的大小
+func (t *CloudTransfer) record(task transferTask, direction string, action string, key string, path string,
+	begin time.Time, err error) {
+	duration := time.Since(begin)
+	if err != nil {
+		action = ReportFailed
+		atomic.AddInt64(&t.failed, 1)
+	}
+	t.Metrics.File(direction, action, task.size, duration, err)
+	if !t.Report.Enabled() {
+		return
+	}
+	entry := &ReportEntry{Job: task.job, Key: key, Path: path, Action: action, Size: task.size,
+		Duration: duration.Milliseconds(), Error: errorText(err)}
+	if err == nil {
+		// 下载的对象可能经过压缩或加密，与sha256保持一致，记录解码后本地文件的大小
+		if info, err := os.Stat(path); err == nil {
+			entry.Size = info.Size()
+		}
+		if sum, err := helper.FileSha256(path); err == nil {
+			entry.Checksum = sum
+		}
+	}
+	t.Report.Add(entry)
+}
+
+// recordDelete 记录删除目标端多余文件的结果到报告和指标
+func (t *CloudTransfer) recordDelete(job config.Job, key string, path string, size int64, err error) {
+	action := ReportDeleted
+	if err != nil {
+		action = ReportFailed
+	}
+	t.addEntry(job, &ReportEntry{Job: job.Name, Key: key, Path: path, Action: action, Size: size,
+		Error: errorText(err)}, err)
+}
+
+// addEntry 记录未经传输的文件处理结果到报告和指标，如忽略、删除的文件
+func (t *CloudTransfer) addEntry(job config.Job, entry *ReportEntry, err error) {
+	if err != nil {
+		atomic.AddInt64(&t.failed, 1)
+	}
+	t.Metrics.File(job.Direction, entry.Action, entry.Size, 0, err)
+	t.Report.Add(entry)
+}
+
+// upload 上传单个文件并按规则设置对象属性，开启压缩时先压缩，开启客户端加密时再加密后上传，返回上传的对象路径
+func (t *CloudTransfer) upload(task transferTask) (string, error) {
+	opt, err := objectAttributes(task.rules, task.rel, task.path)
+	if err != nil {
+		logger.Error("upload error", "file", task.path, "error", err)
+		return task.key, err
+	}
+	opt.Encryption = task.sse
+	opt.Progress = task.progress.Counter(task.slot)
+	key, path := task.key, task.path
+	if task.compressor != nil && task.compressor.shouldCompress(path) {
+		tmp, meta, err := task.compressor.compressFile(path)
+		if err != nil {
+			logger.Error("compress error", "file", path, "error", err)
+			return key, err
+		}
+		defer os.Remove(tmp)
+		key, path = task.compressor.objectKey(key), tmp
+		for k, v := range meta {
+			opt.Meta[k] = v
+		}
+		// 加密后的内容不是压缩格式，不能设置Content-Encoding
+		if task.cipher == nil && task.compressor.mode == config.CompressionEncoding {
+			opt.ContentEncoding = task.compressor.algorithm
+		}
+		if task.compressor.mode == config.CompressionSuffix {
+			opt.ContentType = compressionTypes[task.compressor.algorithm]
+		}
+	}
+	if task.cipher != nil {
+		tmp, meta, err := task.cipher.encryptFile(path)
+		if err != nil {
+			logger.Error("encrypt error", "file", task.path, "error", err)
+			return key, err
+		}
+		defer os.Remove(tmp)
+		path = tmp
+		opt.ContentType = "application/octet-stream"
+		for k, v := range meta {
+			opt.Meta[k] = v
+		}
+	}
+	return key, task.provider.PutFile(key, path, opt)
+}
+
+// download 下载单个文件，对加密上传的对象下载后解密，对压缩上传的对象下载后解压，其他对象直接下载，返回下载后的本地路径
+// 是否加密、压缩按对象的元数据和Content-Encoding判断，与当前任务的配置无关，因此其他任务或工具上传的对象也能正确还原
+func (t *CloudTransfer) download(task transferTask) (string, error) {
+	opt := &provider.GetOptions{Encryption: task.sse, Progress: task.progress.Counter(task.slot)}
+	obj, err := task.provider.Head(task.key, opt)
+	if err != nil {
+		return task.path, err
+	}
+	encrypted := isEncryptedObject(obj.Meta)
+	algorithm := compressionOf(obj.Meta, obj.ContentEncoding)
+	// 未开启客户端加密时无法解密，不把密文写入本地文件
+	if encrypted && task.cipher == nil {
+		err := errors.New("object is client-side encrypted, configure encryption to download it")
+		logger.Error("download error", "key", task.key, "error", err)
+		return task.path, err
+	}
+	if !encrypted && algorithm == "" {
+		return task.path, task.provider.GetFile(task.key, task.path, opt)
+	}
+
+	// 按后缀方式压缩的对象，下载到去掉后缀的本地路径
+	dest := decompressedPath(task.path, obj.Meta, obj.ContentEncoding)
+	// 获取原始的压缩内容，避免http客户端按Content-Encoding自动解压
+	opt.AcceptEncoding = obj.ContentEncoding
+
+	tmp := task.path + ".osd-tool.part"
+	defer os.Remove(tmp)
+	if err := task.provider.GetFile(task.key, tmp, opt); err != nil {
+		return dest, err
+	}
+	if encrypted {
+		plain := dest
+		if algorithm != "" {
+			plain = task.path + ".osd-tool.dec"
+			defer os.Remove(plain)
+		}
+		if err := task.cipher.decryptFile(tmp, plain, obj.Meta); err != nil {
+			logger.Error("decrypt error", "key", task.key, "error", err)
+			return dest, err
+		}
+		tmp = plain
+	}
+	if algorithm != "" {
+		if err := decompressFile(tmp, dest, algorithm); err != nil {
+			logger.Error("decompress error", "key", task.key, "error", err)
+			return dest, err
+		}
+	}
+	return dest, nil
+}
+
 // PrintUploadConfig 打印上传相关配置
 func (t *CloudTransfer) PrintUploadConfig() {
 	fmt.Println("--------------- CONFIG ---------------")
-	fmt.Println("storage:", t.Config.Storage)
-	fmt.Println("osd config:")
-	fmt.Printf("  bucket: %s\n", t.Config.Osd.Bucket)
-	fmt.Println("  region:", t.Config.Osd.Region)
-	fmt.Println("  secret_id:", helper.HideSecret(t.Config.Osd.SecretId, 8))
-	fmt.Println("  secret_key:", helper.HideSecret(t.Config.Osd.SecretKey, 8))
+	t.printProfiles(t.Config.Upload.List)
 	fmt.Println("upload config:")
 	fmt.Println("  ignore:", t.Config.Upload.Ignore)
 	fmt.Println("  list:")
-	for _, p := range t.Config.Upload.List {
-		fmt.Printf("    %s -> %s\n", p.Source, p.Dest)
-	}
+	printPaths(t.Config.Upload.List)
 	fmt.Println("--------------------------------------")
 }
 
 // PrintDownloadConfig 打印下载相关配置
 func (t *CloudTransfer) PrintDownloadConfig() {
 	fmt.Println("--------------- CONFIG ---------------")
-	fmt.Println("storage:", t.Config.Storage)
-	fmt.Println("osd config:")
-	fmt.Printf("  bucket: %s\n", t.Config.Osd.Bucket)
-	fmt.Println("  region:", t.Config.Osd.Region)
-	fmt.Println("  secret_id:", helper.HideSecret(t.Config.Osd.SecretId, 8))
-	fmt.Println("  secret_key:", helper.HideSecret(t.Config.Osd.SecretKey, 8))
+	t.printProfiles(t.Config.Download.List)
 	fmt.Println("download config:")
 	fmt.Println("  list:")
-	for _, p := range t.Config.Download.List {
-		fmt.Printf("    %s -> %s\n", p.Source, p.Dest)
+	printPaths(t.Config.Download.List)
+	fmt.Println("--------------------------------------")
+}
+
+// PrintJobConfig 打印任务相关配置
+func (t *CloudTransfer) PrintJobConfig(job config.Job) {
+	fmt.Println("--------------- CONFIG ---------------")
+	t.printProfiles([]config.Path{job.Path()})
+	fmt.Printf("job %s:\n", job.Name)
+	fmt.Println("  direction:", job.Direction)
+	fmt.Println("  ignore:", job.Ignore)
+	fmt.Println("  concurrency:", job.Concurrency)
+	fmt.Println("  delete:", job.Delete)
+	if job.ServerSideEncryption != nil {
+		fmt.Println("  server_side_encryption:", job.ServerSideEncryption.Mode)
+	}
+	if job.Compression != nil && job.Compression.Enabled {
+		fmt.Println("  compression:", job.Compression.Algorithm, job.Compression.Mode)
+	}
+	if len(job.Rules) > 0 {
+		fmt.Println("  rules:", len(job.Rules))
 	}
+	if job.Restore != nil && job.Restore.Enabled {
+		fmt.Printf("  restore: days %d, tier %s, wait %t\n", job.Restore.Days, valueOrNone(job.Restore.Tier), job.Restore.Wait)
+	}
+	fmt.Println("  list:")
+	printPaths([]config.Path{job.Path()})
 	fmt.Println("--------------------------------------")
 }
+
+// printProfiles 打印目录映射中用到的存储配置
+func (t *CloudTransfer) printProfiles(list []config.Path) {
+	printed := map[string]bool{}
+	for _, dir := range list {
+		if printed[dir.Profile] {
+			continue
+		}
+		printed[dir.Profile] = true
+		p, err := t.Config.GetProfile(dir.Profile)
+		if err != nil {
+			continue
+		}
+		name := dir.Profile
+		if name == "" {
+			name = t.Config.Profile
+		}
+		if name == "" {
+			fmt.Println("osd config:")
+		} else {
+			fmt.Printf("osd config (profile %s):\n", name)
+		}
+		fmt.Println("  storage:", p.Storage)
+		fmt.Printf("  bucket: %s\n", p.Bucket)
+		fmt.Println("  region:", p.Region)
+		if p.Endpoint != "" {
+			fmt.Println("  endpoint:", p.Endpoint)
+		}
+		source := ""
+		if p.SecretSource != "" {
+			source = fmt.Sprintf(" (from %s)", p.SecretSource)
+		}
+		fmt.Printf("  secret_id: %s%s\n", helper.HideSecret(p.SecretId, 8), source)
+		fmt.Printf("  secret_key: %s%s\n", helper.HideSecret(p.SecretKey, 8), source)
+	}
+}
+
+// printPaths 打印目录映射列表
+func printPaths(list []config.Path) {
+	for _, p := range list {
+		if p.Profile != "" {
+			fmt.Printf("    %s -> %s (profile %s)\n", p.Source, p.Dest, p.Profile)
+			continue
+		}
+		fmt.Printf("    %s -> %s\n", p.Source, p.Dest)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"github.com/jorben/osd-tool/config"
+	"github.com/jorben/osd-tool/provider"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memProvider 测试用的Provider，对象及元数据保存在内存中，可指定列出和传输时返回的错误
+type memProvider struct {
+	provider.Provider
+	mu      sync.Mutex
+	objects map[string][]byte
+	meta    map[string]map[string]string
+	classes map[string]string // 对象的存储类型
+	listErr error
+	putErr  error
+	getErr  error
+}
+
+func newMemProvider() *memProvider {
+	return &memProvider{objects: map[string][]byte{}, meta: map[string]map[string]string{}}
+}
+
+func (s *memProvider) PutFile(key string, filepath string, opt *provider.PutOptions) error {
+	if s.putErr != nil {
+		return s.putErr
+	}
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = content
+	if opt != nil {
+		s.meta[key] = opt.Meta
+	}
+	return nil
+}
+
+func (s *memProvider) GetFile(key string, filepath string, opt *provider.GetOptions) error {
+	if s.getErr != nil {
+		return s.getErr
+	}
+	s.mu.Lock()
+	content, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("no such key")
+	}
+	return os.WriteFile(filepath, content, 0600)
+}
+
+func (s *memProvider) Head(key string, opt *provider.GetOptions) (*provider.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.objects[key]
+	if !ok {
+		return nil, errors.New("no such key")
+	}
+	return &provider.Object{Key: key, Size: int64(len(content)), Meta: s.meta[key]}, nil
+}
+
+func (s *memProvider) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	delete(s.meta, key)
+	return nil
+}
+
+func (s *memProvider) List(prefix string, marker string) ([]provider.Object, error) {
+	if s.listErr != nil {
+		return nil, s.listErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []provider.Object
+	for key, content := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			list = append(list, provider.Object{Key: key, Size: int64(len(content)), StorageClass: s.classes[key]})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list, nil
+}
+
+// newMemTransfer 使用memProvider作为默认存储的CloudTransfer
+func newMemTransfer(p *memProvider) *CloudTransfer {
+	cfg := &config.TransferConfig{Storage: provider.COS}
+	return &CloudTransfer{Config: cfg, providers: map[string]provider.Provider{"": p}}
+}
+
+func TestDeleteExtraneous(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []string
+		listErr error
+		want    []string // 删除后保留的本地文件
+		wantErr bool
+	}{
+		{"extraneous deleted", []string{"backup/a.txt"}, nil, []string{"a.txt"}, false},
+		{"empty source skipped", nil, nil, []string{"a.txt", "b.txt"}, false},
+		{"list failed", []string{"backup/a.txt"}, errors.New("list error"), []string{"a.txt", "b.txt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			for _, name := range []string{"a.txt", "b.txt"} {
+				if err := os.WriteFile(filepath.Join(dest, name), []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := newMemProvider()
+			for _, key := range tt.objects {
+				p.objects[key] = []byte(key)
+			}
+			p.listErr = tt.listErr
+			job := config.Job{Name: "mirror", Direction: config.DirectionDownload, Source: "/backup", Dest: dest,
+				Delete: true}
+			err := newMemTransfer(p).deleteExtraneous(job, p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deleteExtraneous error got %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			entries, _ := os.ReadDir(dest)
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("deleteExtraneous rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadEncryptedWithoutKey(t *testing.T) {
+	p := newMemProvider()
+	p.objects["backup/a.txt"] = []byte("ciphertext")
+	p.meta["backup/a.txt"] = map[string]string{MetaEncryption: EncryptionAlgorithm}
+	p.objects["backup/b.txt"] = []byte("plaintext")
+	dest := t.TempDir()
+	transfer := newMemTransfer(p)
+	transfer.Report = &TransferReport{}
+	err := transfer.RunJobs([]config.Job{{Name: "a", Direction: config.DirectionDownload, Source: "/backup",
+		Dest: dest}})
+	if err == nil || !strings.Contains(err.Error(), "1 files failed") {
+		t.Errorf("RunJobs error got %v, want 1 files failed", err)
+	}
+	// 未配置客户端加密时不把密文写入本地文件
+	if _, err := os.Stat(filepath.Join(dest, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("encrypted object got downloaded, want not exist, error %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dest, "b.txt")); string(content) != "plaintext" {
+		t.Errorf("plain object got %q, want plaintext", content)
+	}
+	for _, entry := range transfer.Report.Entries {
+		if entry.Key == "backup/a.txt" && entry.Action != ReportFailed {
+			t.Errorf("report action got %s, want %s", entry.Action, ReportFailed)
+		}
+	}
+}
+
+func TestDirPrefix(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"backup", "backup/"},
+		{"/backup", "backup/"},
+		{"/backup/", "backup/"},
+		{"backup/photos", "backup/photos/"},
+	}
+	for _, tt := range tests {
+		if got := dirPrefix(tt.dir); got != tt.want {
+			t.Errorf("dirPrefix(%q) rsp got %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
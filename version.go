@@ -1,98 +1,159 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jorben/osd-tool/helper"
-	"github.com/schollz/progressbar/v3"
+	"github.com/jorben/osd-tool/logger"
+	"github.com/jorben/osd-tool/provider"
 	"io"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SignPublicKey 校验发布签名的ed25519公钥，base64编码，发布时通过 -ldflags "-X main.SignPublicKey=..." 嵌入
+// 为空时只校验sha256，不校验签名
+var SignPublicKey = ""
+
+// 升级的发布渠道
+const (
+	ChannelStable     = "stable"     // 正式版本
+	ChannelPrerelease = "prerelease" // 包含预发布版本
 )
 
 // Updater 版本更新器
 type Updater struct {
-	latest   *Release
+	latest   *Release // 升级的目标版本
+	source   releaseSource
+	opt      UpgradeOptions
 	repoName string
 	pkgName  string
 	binName  string
 }
 
+// UpgradeOptions 升级选项
+type UpgradeOptions struct {
+	Version  string            // 指定的版本，可以低于当前版本，为空时按渠道获取最新版本
+	Channel  string            // 发布渠道，取值为 stable、prerelease，默认为 stable
+	Source   string            // 升级来源，为空时使用GitHub，支持API地址、osd://路径、本地安装包
+	Proxy    string            // http代理，为空时使用环境变量中的代理
+	Timeout  time.Duration     // http请求的超时时间，默认5分钟
+	Provider provider.Provider // 升级来源为 osd:// 时使用的对象存储
+	// InsecureSkipVerify 目标版本没有发布校验文件时跳过校验，用于安装 v1.0.3 及之前未发布 checksums.txt 的版本
+	// 发布了校验文件的版本仍然校验
+	InsecureSkipVerify bool
+}
+
 // Release API response 结构
 type Release struct {
-	TagName string `json:"tag_name"`
-	Assets  []struct {
-		Name string `json:"name"`
-		Url  string `json:"browser_download_url"`
-	} `json:"assets"`
+	TagName     string    `json:"tag_name"`
+	Prerelease  bool      `json:"prerelease"`
+	Draft       bool      `json:"draft"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []Asset   `json:"assets"`
+}
+
+// Asset 版本中的文件
+type Asset struct {
+	Name string `json:"name"`
+	Url  string `json:"browser_download_url"`
 }
 
 // NewUpdater 获取Version实例
-func NewUpdater(repo string, bin string, pkg string) (*Updater, error) {
-	latest, err := getLatest(repo)
+func NewUpdater(repo string, bin string, pkg string, opt UpgradeOptions) (*Updater, error) {
+	if opt.Version != "" && !strings.HasPrefix(opt.Version, "v") {
+		opt.Version = "v" + opt.Version
+	}
+	source, err := newReleaseSource(repo, opt)
 	if err != nil {
 		return nil, err
 	}
 
+	pkgName := strings.Replace(strings.Replace(pkg, "{arch}", runtime.GOARCH, -1), "{os}", runtime.GOOS, -1)
+	binName := strings.Replace(strings.Replace(bin, "{arch}", runtime.GOARCH, -1), "{os}", runtime.GOOS, -1)
+	// windows版本发布为zip包
+	if runtime.GOOS == "windows" {
+		pkgName = strings.TrimSuffix(pkgName, filepath.Ext(pkgName)) + ".zip"
+		binName += ".exe"
+	}
+	// 本地安装包按实际的文件名查找校验值
+	if s, ok := source.(*fileSource); ok {
+		pkgName = filepath.Base(s.path)
+	}
 	return &Updater{
-		latest:   &latest,
+		source:   source,
+		opt:      opt,
 		repoName: repo,
-		pkgName:  strings.Replace(strings.Replace(pkg, "{arch}", runtime.GOARCH, -1), "{os}", runtime.GOOS, -1),
-		binName:  strings.Replace(strings.Replace(bin, "{arch}", runtime.GOARCH, -1), "{os}", runtime.GOOS, -1),
+		pkgName:  pkgName,
+		binName:  binName,
 	}, nil
 }
 
-// Upgrade 执行版本升级
-func (s *Updater) Upgrade() error {
-	url := s.getLatestUrl()
-	if url == "" {
-		return errors.New(
-			fmt.Sprintf("The latest version does not support your system: %s-%s", runtime.GOOS, runtime.GOARCH))
-	}
-
-	// 创建本地临时目录
-	tmpPath := fmt.Sprintf("%s/.%s/", os.TempDir(), s.repoName)
-	if _, err := os.Stat(tmpPath); err != nil && os.IsNotExist(err) {
-		if err := os.MkdirAll(tmpPath, os.ModePerm); err != nil {
-			return err
+// Resolve 按升级选项获取目标版本
+func (s *Updater) Resolve() error {
+	var latest Release
+	var err error
+	switch {
+	case s.opt.Version != "":
+		latest, err = s.source.Release(s.opt.Version)
+	case s.opt.Channel == "" || s.opt.Channel == ChannelStable:
+		latest, err = s.source.Latest()
+	case s.opt.Channel == ChannelPrerelease:
+		var releases []Release
+		if releases, err = s.Releases(); err == nil {
+			if len(releases) == 0 {
+				return errors.New("no release is found")
+			}
+			latest = releases[0]
 		}
+	default:
+		return errors.New(fmt.Sprintf("channel '%s' is not supported, use %s or %s",
+			s.opt.Channel, ChannelStable, ChannelPrerelease))
 	}
-	defer os.RemoveAll(tmpPath)
-
-	// 下载包到本地
-	pkgPath := filepath.Join(tmpPath, s.pkgName)
-	binPath := filepath.Join(tmpPath, s.binName)
-
-	fd, err := os.OpenFile(pkgPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	if err != nil {
 		return err
 	}
-	defer fd.Close()
+	s.latest = &latest
+	return nil
+}
 
-	resp, err := http.Get(url)
+// Releases 获取已发布的版本，按版本号从高到低排序，渠道为prerelease时包含预发布版本
+func (s *Updater) Releases() ([]Release, error) {
+	all, err := s.source.List()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	bar := progressbar.DefaultBytes(resp.ContentLength, "downloading...")
-
-	if _, err := io.Copy(io.MultiWriter(fd, bar), resp.Body); err != nil {
-		return err
+	var releases []Release
+	for _, r := range all {
+		if r.Draft || (r.Prerelease && s.opt.Channel != ChannelPrerelease) {
+			continue
+		}
+		releases = append(releases, r)
 	}
+	return releases, nil
+}
 
-	// 解压压缩包
-	if err := helper.Unarchive(pkgPath, tmpPath); err != nil {
+// IsLocal 判断是否使用本地安装包升级，本地安装包不比较版本号
+func (s *Updater) IsLocal() bool {
+	_, ok := s.source.(*fileSource)
+	return ok
+}
+
+// Upgrade 执行版本升级
+func (s *Updater) Upgrade() error {
+	// 创建仅当前用户可访问的临时目录，避免安装包在校验后被其他用户替换
+	tmpPath, err := os.MkdirTemp("", "osd-tool-upgrade-*")
+	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(tmpPath)
 
-	// 判断文件是否存在
-	if _, err := os.Stat(binPath); err != nil && os.IsNotExist(err) {
+	binPath, err := s.prepare(tmpPath)
+	if err != nil {
 		return err
 	}
 
@@ -102,11 +163,13 @@ func (s *Updater) Upgrade() error {
 		return err
 	}
 
-	// 必须先rename 否则替换后无法执行
+	// 必须先rename 否则替换后无法执行，保留旧版本用于 upgrade --rollback
+	if err := os.Remove(self + ".bak"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	if err := os.Rename(self, self+".bak"); err != nil {
 		return err
 	}
-	defer os.Remove(self + ".bak")
 
 	if err := helper.Copy(binPath, self); err != nil {
 		rollback(self+".bak", self)
@@ -121,7 +184,50 @@ func (s *Updater) Upgrade() error {
 	return nil
 }
 
-// IsLatest 判断当前版本是否最新版
+// prepare 下载、校验并解压安装包到tmpPath，返回解压后的程序路径
+func (s *Updater) prepare(tmpPath string) (string, error) {
+	url := s.getLatestUrl()
+	if url == "" {
+		return "", errors.New(
+			fmt.Sprintf("Version %s does not support your system: %s-%s", s.latest.TagName, runtime.GOOS, runtime.GOARCH))
+	}
+
+	// 下载包到本地
+	pkgPath := filepath.Join(tmpPath, s.pkgName)
+	binPath := filepath.Join(tmpPath, s.binName)
+	if err := s.source.Fetch(url, pkgPath, true); err != nil {
+		return "", err
+	}
+
+	// 校验和解压使用同一个文件句柄，校验通过后才解压替换
+	pkg, err := os.Open(pkgPath)
+	if err != nil {
+		return "", err
+	}
+	defer pkg.Close()
+	if err := s.verify(tmpPath, pkg); err != nil {
+		return "", err
+	}
+
+	// 解压压缩包
+	if err := helper.UnarchiveFile(pkg, tmpPath); err != nil {
+		return "", err
+	}
+
+	// 判断文件是否存在
+	if _, err := os.Stat(binPath); err != nil {
+		return "", err
+	}
+	return binPath, nil
+}
+
+// Version 获取目标版本号
+func (s *Updater) Version() string {
+	return s.latest.TagName
+}
+
+// IsLatest 判断当前版本是否最新版，按SemVer比较，目标版本不高于当前版本时不升级，
+// 因此运行预发布版本时不会被降级到较低的正式版本，运行正式版本时也不会被同版本号的预发布版本替换
 func (s *Updater) IsLatest(currVersion string) bool {
 	if 1 == helper.CompareVersion(s.latest.TagName, currVersion) {
 		return false
@@ -131,49 +237,126 @@ func (s *Updater) IsLatest(currVersion string) bool {
 
 // GetLatestUrl 获取与系统匹配的版本链接
 func (s *Updater) getLatestUrl() string {
+	return s.getAssetUrl(s.pkgName)
+}
+
+// getAssetUrl 获取版本中指定名称的文件链接
+func (s *Updater) getAssetUrl(name string) string {
 	for _, pkg := range s.latest.Assets {
-		if s.pkgName == pkg.Name {
+		if name == pkg.Name {
 			return pkg.Url
 		}
 	}
 	return ""
 }
 
+// HasChecksums 判断目标版本是否发布了sha256校验文件
+func (s *Updater) HasChecksums() bool {
+	return s.getAssetUrl(ChecksumsName) != ""
+}
+
+// verify 按版本发布的sha256校验文件校验安装包，嵌入了公钥时先校验校验文件的签名
+func (s *Updater) verify(tmpPath string, pkg *os.File) error {
+	url := s.getAssetUrl(ChecksumsName)
+	if url == "" && s.opt.InsecureSkipVerify {
+		logger.Warn("release has no checksums, skip verification", "version", s.latest.TagName)
+		return nil
+	}
+	if url == "" {
+		return errors.New(fmt.Sprintf("release %s has no %s, refuse to upgrade without checksum verification, "+
+			"use --insecure-skip-verify to install it anyway", s.latest.TagName, ChecksumsName))
+	}
+	sumsPath := filepath.Join(tmpPath, ChecksumsName)
+	if err := s.source.Fetch(url, sumsPath, false); err != nil {
+		return err
+	}
+	content, err := os.ReadFile(sumsPath)
+	if err != nil {
+		return err
+	}
+
+	if SignPublicKey != "" {
+		url := s.getAssetUrl(SignatureName)
+		if url == "" {
+			return errors.New(fmt.Sprintf("release %s has no %s, refuse to upgrade without signature verification",
+				s.latest.TagName, SignatureName))
+		}
+		sigPath := filepath.Join(tmpPath, SignatureName)
+		if err := s.source.Fetch(url, sigPath, false); err != nil {
+			return err
+		}
+		signature, err := os.ReadFile(sigPath)
+		if err != nil {
+			return err
+		}
+		if err := helper.VerifySignature(SignPublicKey, content, string(signature)); err != nil {
+			return errors.New(fmt.Sprintf("verify signature of %s error, %s", ChecksumsName, err.Error()))
+		}
+	}
+
+	sums, err := helper.ParseChecksums(content)
+	if err != nil {
+		return err
+	}
+	want, ok := sums[s.pkgName]
+	if !ok {
+		return errors.New(fmt.Sprintf("checksum of %s is not found in %s", s.pkgName, ChecksumsName))
+	}
+	got, err := helper.Sha256(pkg)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return errors.New(fmt.Sprintf("checksum of %s does not match, want %s, got %s, the package may be corrupted or tampered",
+			s.pkgName, want, got))
+	}
+	return nil
+}
+
+// PrintReleases 输出已发布的版本，标记当前版本
+func PrintReleases(w io.Writer, releases []Release, current string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tPRERELEASE\tPUBLISHED\tCURRENT")
+	for _, r := range releases {
+		mark := ""
+		if helper.CompareVersion(r.TagName, current) == 0 {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%t\t%s\t%s\n", r.TagName, r.Prerelease, r.PublishedAt.Format("2006-01-02"), mark)
+	}
+	return tw.Flush()
+}
+
 func rollback(src string, dest string) {
-	log.Println("Upgrade failed, rolled back")
+	logger.Warn("upgrade failed, rolling back", "file", dest)
 	// 回滚
 	if err := os.Rename(src, dest); err != nil {
-		log.Printf("Error while rollback - %s", err.Error())
+		logger.Error("rollback error", "file", dest, "backup", src, "error", err)
 		return
 	}
 	return
 }
 
-// getApi 获取API地址
-func getApi(repo string) string {
-	return fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
-}
-
-// getLatest 获取最新版本信息
-func getLatest(repo string) (Release, error) {
-	latest := Release{}
-	res, err := http.Get(getApi(repo))
+// Rollback 恢复升级前的版本，当前版本保存为备份，可以再次执行回滚恢复
+func Rollback() error {
+	self, err := os.Executable()
 	if err != nil {
-		log.Println("Error getting latest release from Github:", err)
-		return latest, err
+		return err
 	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Println("Error reading latest release from Github:", err)
-		return latest, err
+	backup := self + ".bak"
+	if _, err := os.Stat(backup); err != nil {
+		if os.IsNotExist(err) {
+			return errors.New(fmt.Sprintf("backup '%s' is not found, there is nothing to roll back", backup))
+		}
+		return err
 	}
-
-	if err := json.Unmarshal(body, &latest); err != nil {
-		log.Println("Error decoding latest release from Github:", err)
-		return latest, err
+	tmp := self + ".tmp"
+	if err := os.Rename(self, tmp); err != nil {
+		return err
 	}
-
-	return latest, nil
+	if err := os.Rename(backup, self); err != nil {
+		rollback(tmp, self)
+		return err
+	}
+	return os.Rename(tmp, backup)
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// releaseServer 模拟与GitHub API格式相同的版本来源
+type releaseServer struct {
+	*httptest.Server
+	releases []Release
+	files    map[string][]byte
+}
+
+func newReleaseServer(t *testing.T) *releaseServer {
+	s := &releaseServer{files: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := "/repos/" + RepoName + "/releases"
+		switch {
+		case r.URL.Path == prefix:
+			json.NewEncoder(w).Encode(s.releases)
+		case r.URL.Path == prefix+"/latest":
+			for _, release := range s.releases {
+				if !release.Prerelease && !release.Draft {
+					json.NewEncoder(w).Encode(release)
+					return
+				}
+			}
+			http.NotFound(w, r)
+		case strings.HasPrefix(r.URL.Path, prefix+"/tags/"):
+			for _, release := range s.releases {
+				if release.TagName == strings.TrimPrefix(r.URL.Path, prefix+"/tags/") {
+					json.NewEncoder(w).Encode(release)
+					return
+				}
+			}
+			http.NotFound(w, r)
+		case strings.HasPrefix(r.URL.Path, "/download/"):
+			content, ok := s.files[strings.TrimPrefix(r.URL.Path, "/download/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// addRelease 添加版本，files为版本中的文件名及内容
+func (s *releaseServer) addRelease(tag string, prerelease bool, files map[string][]byte) {
+	release := Release{TagName: tag, Prerelease: prerelease}
+	for name, content := range files {
+		s.files[tag+"/"+name] = content
+		release.Assets = append(release.Assets, Asset{Name: name, Url: s.URL + "/download/" + tag + "/" + name})
+	}
+	s.releases = append(s.releases, release)
+}
+
+// makePackage 生成包含程序文件的tgz安装包及其checksums.txt
+func makePackage(t *testing.T, pkgName string, binName string, body string) map[string][]byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: binName, Mode: 0755, Size: int64(len(body)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(body))
+	tw.Close()
+	gw.Close()
+	sum := sha256.Sum256(buf.Bytes())
+	return map[string][]byte{
+		pkgName:       buf.Bytes(),
+		ChecksumsName: []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), pkgName)),
+	}
+}
+
+func TestUpdaterResolve(t *testing.T) {
+	// 与GitHub一致，按发布时间从新到旧
+	server := newReleaseServer(t)
+	server.addRelease("v1.2.0-rc.1", true, nil)
+	server.addRelease("v1.1.0", false, nil)
+	server.addRelease("v1.0.2", false, nil)
+
+	tests := []struct {
+		name    string
+		opt     UpgradeOptions
+		want    string
+		wantErr bool
+	}{
+		{"stable", UpgradeOptions{}, "v1.1.0", false},
+		{"prerelease", UpgradeOptions{Channel: ChannelPrerelease}, "v1.2.0-rc.1", false},
+		{"pinned version", UpgradeOptions{Version: "v1.1.0"}, "v1.1.0", false},
+		{"pinned version without v", UpgradeOptions{Version: "1.1.0"}, "v1.1.0", false},
+		{"version not found", UpgradeOptions{Version: "v9.9.9"}, "", true},
+		{"unknown channel", UpgradeOptions{Channel: "nightly"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opt.Source = server.URL
+			updater, err := NewUpdater(RepoName, BinName, PackageName, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = updater.Resolve()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && updater.Version() != tt.want {
+				t.Errorf("Resolve rsp got %v, want %v", updater.Version(), tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdaterReleases(t *testing.T) {
+	server := newReleaseServer(t)
+	server.addRelease("v1.0.2", false, nil)
+	server.addRelease("v1.2.0-rc.1", true, nil)
+	server.addRelease("v1.1.0", false, nil)
+
+	tests := []struct {
+		name    string
+		channel string
+		want    []string
+	}{
+		{"stable", ChannelStable, []string{"v1.1.0", "v1.0.2"}},
+		{"prerelease", ChannelPrerelease, []string{"v1.2.0-rc.1", "v1.1.0", "v1.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater, err := NewUpdater(RepoName, BinName, PackageName, UpgradeOptions{Source: server.URL, Channel: tt.channel})
+			if err != nil {
+				t.Fatal(err)
+			}
+			releases, err := updater.Releases()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range releases {
+				got = append(got, r.TagName)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Releases rsp got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdaterPrepare(t *testing.T) {
+	server := newReleaseServer(t)
+	updater, err := NewUpdater(RepoName, BinName, PackageName, UpgradeOptions{Source: server.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := makePackage(t, updater.pkgName, updater.binName, "new binary")
+	server.addRelease("v1.0.0", true, good)
+	tampered := makePackage(t, updater.pkgName, updater.binName, "new binary")
+	tampered[ChecksumsName] = makePackage(t, updater.pkgName, updater.binName, "other binary")[ChecksumsName]
+	server.addRelease("v1.0.1", true, tampered)
+	server.addRelease("v1.0.2", true, map[string][]byte{updater.pkgName: good[updater.pkgName]})
+
+	tests := []struct {
+		name    string
+		version string
+		skip    bool
+		wantErr string
+	}{
+		{"verified", "v1.0.0", false, ""},
+		{"checksum mismatch", "v1.0.1", false, "does not match"},
+		{"checksums missing", "v1.0.2", false, "refuse to upgrade"},
+		{"checksums missing skipped", "v1.0.2", true, ""},
+		{"skip does not ignore mismatch", "v1.0.1", true, "does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater.opt.Version = tt.version
+			updater.opt.InsecureSkipVerify = tt.skip
+			if err := updater.Resolve(); err != nil {
+				t.Fatal(err)
+			}
+			binPath, err := updater.prepare(t.TempDir())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("prepare error got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("prepare error: %v", err)
+			}
+			if content, _ := os.ReadFile(binPath); string(content) != "new binary" {
+				t.Errorf("prepare rsp got %q, want %q", content, "new binary")
+			}
+		})
+	}
+}
+
+func TestUpdaterLocalPackage(t *testing.T) {
+	dir := t.TempDir()
+	updater, err := NewUpdater(RepoName, BinName, PackageName, UpgradeOptions{Source: SourceGithub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range makePackage(t, "custom.tgz", updater.binName, "local binary") {
+		os.WriteFile(filepath.Join(dir, name), content, 0644)
+	}
+
+	updater, err = NewUpdater(RepoName, BinName, PackageName, UpgradeOptions{Source: SourceFile + filepath.Join(dir, "custom.tgz")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	binPath, err := updater.prepare(t.TempDir())
+	if err != nil {
+		t.Fatalf("prepare error: %v", err)
+	}
+	if content, _ := os.ReadFile(binPath); string(content) != "local binary" {
+		t.Errorf("prepare rsp got %q, want %q", content, "local binary")
+	}
+}
+
+func TestUpdaterProxyAndTimeout(t *testing.T) {
+	// 代理服务器直接返回版本信息，并记录请求的目标地址
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.URL.Host
+		json.NewEncoder(w).Encode(Release{TagName: "v2.0.0"})
+	}))
+	defer proxy.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name    string
+		opt     UpgradeOptions
+		want    string
+		wantErr bool
+	}{
+		{"through proxy", UpgradeOptions{Source: "http://mirror.example.com", Proxy: proxy.URL}, "v2.0.0", false},
+		{"invalid proxy", UpgradeOptions{Source: "http://mirror.example.com", Proxy: "::"}, "", true},
+		{"timeout", UpgradeOptions{Source: slow.URL, Timeout: 50 * time.Millisecond}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater, err := NewUpdater(RepoName, BinName, PackageName, tt.opt)
+			if err == nil {
+				err = updater.Resolve()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve error got %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if updater.Version() != tt.want || host != "mirror.example.com" {
+				t.Errorf("Resolve rsp got %v via %v, want %v via mirror.example.com", updater.Version(), host, tt.want)
+			}
+		})
+	}
+}